@@ -4,8 +4,10 @@ import (
     "log"
     "github.com/gin-gonic/gin"
     "github.com/joho/godotenv"
+    "github.com/grrywlsn/imagerr/src/analytics"
     "github.com/grrywlsn/imagerr/src/api"
     "github.com/grrywlsn/imagerr/src/db"
+    "github.com/grrywlsn/imagerr/src/gc"
     "github.com/grrywlsn/imagerr/src/storage"
     "github.com/grrywlsn/imagerr/src/search"
 )
@@ -18,7 +20,11 @@ func main() {
     // Initialize services
     db.InitDB()
     storage.InitS3()
-    search.InitElasticsearch()
+    search.Init()
+
+    // Start background jobs
+    gc.StartScheduler()
+    analytics.StartPurger()
 
     // Setup router
     r := gin.Default()
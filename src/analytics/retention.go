@@ -0,0 +1,70 @@
+package analytics
+
+import (
+    "fmt"
+    "log"
+    "math"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+    "github.com/grrywlsn/imagerr/src/db"
+)
+
+const (
+    defaultRetention = 90 * 24 * time.Hour
+    purgeInterval    = 24 * time.Hour
+)
+
+// Retention is how long search logs are kept, from SEARCH_LOG_RETENTION as
+// a Go duration or a number of days such as 90d. A retention of 0 keeps them
+// forever.
+func Retention() time.Duration {
+    value := os.Getenv("SEARCH_LOG_RETENTION")
+    if value == "" {
+        return defaultRetention
+    }
+    retention, err := parseRetention(value)
+    if err != nil || retention < 0 {
+        log.Printf("Invalid SEARCH_LOG_RETENTION %q, using %s", value, defaultRetention)
+        return defaultRetention
+    }
+    return retention
+}
+
+// parseRetention reads a Go duration, or a whole number of days with a "d"
+// suffix, which time.ParseDuration doesn't accept.
+func parseRetention(value string) (time.Duration, error) {
+    days, ok := strings.CutSuffix(value, "d")
+    if !ok {
+        return time.ParseDuration(value)
+    }
+    n, err := strconv.ParseInt(days, 10, 64)
+    if err != nil || n > math.MaxInt64/int64(24*time.Hour) {
+        return 0, fmt.Errorf("invalid number of days %q", value)
+    }
+    return time.Duration(n) * 24 * time.Hour, nil
+}
+
+// StartPurger deletes search logs older than the retention period at
+// startup and once a day after that.
+func StartPurger() {
+    retention := Retention()
+    if retention == 0 {
+        return
+    }
+
+    go func() {
+        ticker := time.NewTicker(purgeInterval)
+        defer ticker.Stop()
+        for {
+            deleted, err := db.DeleteSearchLogsBefore(time.Now().Add(-retention))
+            if err != nil {
+                log.Printf("Error purging search logs: %v", err)
+            } else if deleted > 0 {
+                log.Printf("Purged %d search logs older than %s", deleted, retention)
+            }
+            <-ticker.C
+        }
+    }()
+}
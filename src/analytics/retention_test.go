@@ -0,0 +1,50 @@
+package analytics
+
+import (
+    "testing"
+    "time"
+)
+
+func TestParseRetention(t *testing.T) {
+    for _, test := range []struct {
+        value string
+        want  time.Duration
+    }{
+        {"90d", 90 * 24 * time.Hour},
+        {"1d", 24 * time.Hour},
+        {"0d", 0},
+        {"0", 0},
+        {"720h", 720 * time.Hour},
+        {"36h30m", 36*time.Hour + 30*time.Minute},
+        {"-1d", -24 * time.Hour},
+    } {
+        if got, err := parseRetention(test.value); err != nil || got != test.want {
+            t.Errorf("parseRetention(%q) = %s, %v, want %s", test.value, got, err, test.want)
+        }
+    }
+
+    for _, value := range []string{"", "d", "90", "1.5d", "90 d", "ninety days", "1d12h", "9999999d"} {
+        if got, err := parseRetention(value); err == nil {
+            t.Errorf("parseRetention(%q) = %s, want an error", value, got)
+        }
+    }
+}
+
+func TestRetention(t *testing.T) {
+    for _, test := range []struct {
+        value string
+        want  time.Duration
+    }{
+        {"", defaultRetention},
+        {"30d", 30 * 24 * time.Hour},
+        {"48h", 48 * time.Hour},
+        {"0", 0},
+        {"-1d", defaultRetention},
+        {"forever", defaultRetention},
+    } {
+        t.Setenv("SEARCH_LOG_RETENTION", test.value)
+        if got := Retention(); got != test.want {
+            t.Errorf("Retention() with %q = %s, want %s", test.value, got, test.want)
+        }
+    }
+}
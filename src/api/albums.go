@@ -0,0 +1,249 @@
+package api
+
+import (
+    "errors"
+    "log"
+    "math"
+    "net/http"
+    "strconv"
+    "strings"
+    "github.com/gin-gonic/gin"
+    "github.com/grrywlsn/imagerr/src/db"
+    "github.com/grrywlsn/imagerr/src/storage"
+)
+
+const (
+    defaultAlbumPageSize = 24
+    maxAlbumPageSize     = 200
+)
+
+type createAlbumRequest struct {
+    Name        string `json:"name" binding:"required"`
+    Description string `json:"description"`
+}
+
+// updateAlbumRequest changes only the fields present. A cover_image_id of
+// 0 goes back to using the first image as the cover.
+type updateAlbumRequest struct {
+    Name         *string `json:"name"`
+    Description  *string `json:"description"`
+    CoverImageID *int64  `json:"cover_image_id"`
+}
+
+type albumImagesRequest struct {
+    ImageIDs []int64 `json:"image_ids" binding:"required"`
+}
+
+// albumError responds to the errors the album operations share.
+func albumError(c *gin.Context, err error, message string) {
+    switch {
+    case errors.Is(err, db.ErrAlbumNotFound):
+        c.JSON(http.StatusNotFound, gin.H{"error": "Album not found"})
+    case errors.Is(err, db.ErrImageNotFound):
+        c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+    case errors.Is(err, db.ErrImageNotInAlbum):
+        c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+    case errors.Is(err, db.ErrAlbumExists):
+        c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+    case errors.Is(err, db.ErrAlbumOrderMismatch):
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+    default:
+        log.Printf("%s: %v", message, err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+    }
+}
+
+// idParam reads a positive ID route parameter, responding with an error
+// and returning false if it is invalid.
+func idParam(c *gin.Context, name, what string) (int64, bool) {
+    id, err := strconv.ParseInt(c.Param(name), 10, 64)
+    if err != nil || id < 1 {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + what + " ID"})
+        return 0, false
+    }
+    return id, true
+}
+
+func ListAlbums(c *gin.Context) {
+    albums, err := db.GetAlbums()
+    if err != nil {
+        albumError(c, err, "Failed to fetch albums")
+        return
+    }
+
+    c.JSON(http.StatusOK, albums)
+}
+
+func CreateAlbum(c *gin.Context) {
+    var req createAlbumRequest
+    if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "'name' is required"})
+        return
+    }
+
+    album, err := db.CreateAlbum(strings.TrimSpace(req.Name), req.Description)
+    if err != nil {
+        albumError(c, err, "Failed to create album")
+        return
+    }
+
+    c.JSON(http.StatusCreated, album)
+}
+
+// GetAlbum returns an album with a page of its images in album order.
+func GetAlbum(c *gin.Context) {
+    id, ok := idParam(c, "id", "album")
+    if !ok {
+        return
+    }
+    page, ok := queryInt(c, "page", 1, math.MaxInt32)
+    if !ok {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page"})
+        return
+    }
+    perPage, ok := queryInt(c, "per_page", defaultAlbumPageSize, maxAlbumPageSize)
+    if !ok {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid per_page"})
+        return
+    }
+
+    album, err := db.GetAlbum(id)
+    if err != nil {
+        albumError(c, err, "Failed to fetch album")
+        return
+    }
+    images, total, err := db.GetAlbumImages(id, (page-1)*perPage, perPage)
+    if err != nil {
+        albumError(c, err, "Failed to fetch album images")
+        return
+    }
+    for i := range images {
+        images[i].URL = storage.GetFileURL(images[i].StoragePath)
+    }
+
+    c.JSON(http.StatusOK, gin.H{
+        "album":    album,
+        "images":   images,
+        "page":     page,
+        "per_page": perPage,
+        "total":    total,
+    })
+}
+
+func UpdateAlbum(c *gin.Context) {
+    id, ok := idParam(c, "id", "album")
+    if !ok {
+        return
+    }
+    var req updateAlbumRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid album update"})
+        return
+    }
+    if req.Name != nil {
+        name := strings.TrimSpace(*req.Name)
+        if name == "" {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "'name' can't be empty"})
+            return
+        }
+        req.Name = &name
+    }
+
+    album, err := db.UpdateAlbum(id, db.AlbumUpdate{
+        Name:         req.Name,
+        Description:  req.Description,
+        CoverImageID: req.CoverImageID,
+    })
+    if err != nil {
+        albumError(c, err, "Failed to update album")
+        return
+    }
+
+    c.JSON(http.StatusOK, album)
+}
+
+// DeleteAlbum deletes an album but not its images, which are reindexed so
+// they stop matching searches for the album.
+func DeleteAlbum(c *gin.Context) {
+    id, ok := idParam(c, "id", "album")
+    if !ok {
+        return
+    }
+
+    ids, err := db.DeleteAlbum(id)
+    if err != nil {
+        albumError(c, err, "Failed to delete album")
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{
+        "message":          "Album deleted",
+        "reindex_failures": reindexImages(ids),
+    })
+}
+
+// AddAlbumImages appends images to the end of an album.
+func AddAlbumImages(c *gin.Context) {
+    id, ok := idParam(c, "id", "album")
+    if !ok {
+        return
+    }
+    var req albumImagesRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "'image_ids' is required"})
+        return
+    }
+
+    added, err := db.AddAlbumImages(id, req.ImageIDs)
+    if err != nil {
+        albumError(c, err, "Failed to add images to album")
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{
+        "added":            len(added),
+        "reindex_failures": reindexImages(added),
+    })
+}
+
+func RemoveAlbumImage(c *gin.Context) {
+    id, ok := idParam(c, "id", "album")
+    if !ok {
+        return
+    }
+    imageID, ok := idParam(c, "image_id", "image")
+    if !ok {
+        return
+    }
+
+    if err := db.RemoveAlbumImage(id, imageID); err != nil {
+        albumError(c, err, "Failed to remove image from album")
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{
+        "message":          "Image removed from album",
+        "reindex_failures": reindexImages([]int64{imageID}),
+    })
+}
+
+// ReorderAlbumImages sets the order of an album's images from a list of
+// all of their IDs.
+func ReorderAlbumImages(c *gin.Context) {
+    id, ok := idParam(c, "id", "album")
+    if !ok {
+        return
+    }
+    var req albumImagesRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "'image_ids' is required"})
+        return
+    }
+
+    if err := db.ReorderAlbumImages(id, req.ImageIDs); err != nil {
+        albumError(c, err, "Failed to reorder album")
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"message": "Album reordered"})
+}
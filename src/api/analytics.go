@@ -0,0 +1,94 @@
+package api
+
+import (
+    "errors"
+    "log"
+    "net/http"
+    "strings"
+    "time"
+    "github.com/gin-gonic/gin"
+    "github.com/grrywlsn/imagerr/src/db"
+    "github.com/grrywlsn/imagerr/src/search"
+)
+
+const (
+    defaultReportDays   = 30
+    maxReportDays       = 3650
+    defaultReportLength = 20
+    maxReportLength     = 500
+)
+
+type searchClickRequest struct {
+    SearchID int64 `json:"search_id" binding:"required"`
+    ImageID  int64 `json:"image_id" binding:"required"`
+    Position int   `json:"position" binding:"required,min=1"`
+}
+
+// logSearch records a search for the analytics report and returns its ID,
+// or 0 if it couldn't be recorded. Tags are logged as requested, before
+// synonym expansion.
+func logSearch(params search.SearchParams, resultCount int, latency time.Duration) int64 {
+    var tags []string
+    if params.Tags != "" {
+        tags = strings.Split(params.Tags, ",")
+    }
+
+    id, err := db.LogSearch(db.SearchLog{
+        Query:       params.Query,
+        Tags:        tags,
+        ResultCount: resultCount,
+        Latency:     latency,
+    })
+    if err != nil {
+        log.Printf("Warning: Failed to log search: %v", err)
+        return 0
+    }
+    return id
+}
+
+// RecordSearchClick records that a search result was opened, using the
+// search_id returned with the results.
+func RecordSearchClick(c *gin.Context) {
+    var req searchClickRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "'search_id', 'image_id' and a 'position' of at least 1 are required"})
+        return
+    }
+
+    err := db.LogSearchClick(req.SearchID, req.ImageID, req.Position)
+    if errors.Is(err, db.ErrSearchLogNotFound) {
+        c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+        return
+    }
+    if err != nil {
+        log.Printf("Error recording search click: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record click"})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"message": "Click recorded"})
+}
+
+// SearchReport summarises the searches of the last days days: top queries,
+// queries that found nothing and click-through rates.
+func SearchReport(c *gin.Context) {
+    days, ok := queryInt(c, "days", defaultReportDays, maxReportDays)
+    if !ok {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid days"})
+        return
+    }
+    limit, ok := queryInt(c, "limit", defaultReportLength, maxReportLength)
+    if !ok {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+        return
+    }
+
+    report, err := db.GetSearchReport(time.Now().AddDate(0, 0, -days), limit)
+    if err != nil {
+        log.Printf("Error building search report: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build search report"})
+        return
+    }
+
+    c.JSON(http.StatusOK, report)
+}
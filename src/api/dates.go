@@ -0,0 +1,15 @@
+package api
+
+import (
+    "time"
+    "github.com/gin-gonic/gin"
+    "github.com/grrywlsn/imagerr/src/db"
+    "github.com/grrywlsn/imagerr/src/search"
+)
+
+// dateFilter reads the from, to and date query parameters of a search.
+// date selects whether the range applies to the upload time (the default)
+// or the time the photo was taken.
+func dateFilter(c *gin.Context) (db.DateFilter, error) {
+    return search.ParseDateFilter(c.DefaultQuery("date", "uploaded"), c.Query("from"), c.Query("to"), time.Now())
+}
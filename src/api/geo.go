@@ -0,0 +1,107 @@
+package api
+
+import (
+    "errors"
+    "log"
+    "net/http"
+    "strconv"
+    "strings"
+    "github.com/gin-gonic/gin"
+    "github.com/grrywlsn/imagerr/src/db"
+    "github.com/grrywlsn/imagerr/src/search"
+    "github.com/grrywlsn/imagerr/src/storage"
+)
+
+const (
+    defaultLocationLimit = 1000
+    maxLocationLimit     = 10000
+)
+
+var errInvalidLocation = errors.New("'latitude' and 'longitude' must be given together, in decimal degrees")
+
+// uploadLocation reads the optional latitude and longitude form fields of
+// an upload.
+func uploadLocation(c *gin.Context) (*float64, *float64, error) {
+    latValue := strings.TrimSpace(c.PostForm("latitude"))
+    lonValue := strings.TrimSpace(c.PostForm("longitude"))
+    if latValue == "" && lonValue == "" {
+        return nil, nil, nil
+    }
+
+    latitude, err := strconv.ParseFloat(latValue, 64)
+    if err != nil || !search.ValidLatitude(latitude) {
+        return nil, nil, errInvalidLocation
+    }
+    longitude, err := strconv.ParseFloat(lonValue, 64)
+    if err != nil || !search.ValidLongitude(longitude) {
+        return nil, nil, errInvalidLocation
+    }
+    return &latitude, &longitude, nil
+}
+
+type geoJSONFeatureCollection struct {
+    Type     string           `json:"type"`
+    Features []geoJSONFeature `json:"features"`
+}
+
+type geoJSONFeature struct {
+    Type       string                 `json:"type"`
+    ID         int64                  `json:"id"`
+    Geometry   geoJSONPoint           `json:"geometry"`
+    Properties map[string]interface{} `json:"properties"`
+}
+
+type geoJSONPoint struct {
+    Type        string    `json:"type"`
+    Coordinates []float64 `json:"coordinates"`
+}
+
+// ImageLocations returns located images as a GeoJSON FeatureCollection of
+// points for map display, newest first, optionally limited to a bbox of
+// west,south,east,north.
+func ImageLocations(c *gin.Context) {
+    var box *db.BoundingBox
+    if value := c.Query("bbox"); value != "" {
+        var err error
+        if box, err = search.ParseBoundingBox(value); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+    }
+    limit, ok := queryInt(c, "limit", defaultLocationLimit, maxLocationLimit)
+    if !ok {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+        return
+    }
+
+    images, err := db.GetImageLocations(box, limit)
+    if err != nil {
+        log.Printf("Error fetching image locations: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch image locations"})
+        return
+    }
+
+    collection := geoJSONFeatureCollection{Type: "FeatureCollection", Features: []geoJSONFeature{}}
+    for _, image := range images {
+        properties := map[string]interface{}{
+            "original_filename": image.OriginalFilename,
+            "description":       image.Description,
+            "tags":              image.Tags,
+            "url":               storage.GetFileURL(image.StoragePath),
+            "created_at":        image.CreatedAt,
+        }
+        if image.TakenAt != nil {
+            properties["taken_at"] = image.TakenAt
+        }
+        collection.Features = append(collection.Features, geoJSONFeature{
+            Type: "Feature",
+            ID:   image.ID,
+            // GeoJSON positions are longitude first
+            Geometry:   geoJSONPoint{Type: "Point", Coordinates: []float64{*image.Longitude, *image.Latitude}},
+            Properties: properties,
+        })
+    }
+
+    c.Header("Content-Type", "application/geo+json")
+    c.JSON(http.StatusOK, collection)
+}
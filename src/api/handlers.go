@@ -1,34 +1,62 @@
 package api
 
 import (
+    "errors"
+    "fmt"
+    "io"
     "log"
     "net/http"
+    "net/url"
     "strings"
     "path/filepath"
     "github.com/gin-gonic/gin"
     "github.com/grrywlsn/imagerr/src/db"
+    "github.com/grrywlsn/imagerr/src/features"
+    "github.com/grrywlsn/imagerr/src/gc"
+    "github.com/grrywlsn/imagerr/src/metadata"
+    "github.com/grrywlsn/imagerr/src/notify"
     "github.com/grrywlsn/imagerr/src/storage"
     "github.com/grrywlsn/imagerr/src/search"
     "strconv"
+    "time"
     "github.com/google/uuid"
 )
 
 func SuggestTags(c *gin.Context) {
-    query := c.Query("q")
+    query := db.NormalizeTag(c.Query("q"))
     if query == "" {
         c.JSON(http.StatusBadRequest, gin.H{"error": "Query parameter 'q' is required"})
         return
     }
 
-    tags, err := search.SuggestTags(query)
+    recent := db.ParseTags(c.Query("recent"))
+    tags, err := search.SuggestTags(query, recent)
     if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tag suggestions"})
+        log.Printf("Error fetching tag suggestions: %v", err)
+        searchError(c, err, "Failed to fetch tag suggestions")
         return
     }
 
     c.JSON(http.StatusOK, tags)
 }
 
+// searchError responds with 503 when the search backend is unreachable or
+// overloaded and 502 when it rejected the request, passing on its reason.
+func searchError(c *gin.Context, err error, message string) {
+    if errors.Is(err, search.ErrUnavailable) {
+        c.JSON(http.StatusServiceUnavailable, gin.H{"error": message, "reason": "Search is temporarily unavailable"})
+        return
+    }
+
+    var searchErr *search.Error
+    if errors.As(err, &searchErr) {
+        c.JSON(http.StatusBadGateway, gin.H{"error": message, "reason": searchErr.Reason})
+        return
+    }
+
+    c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+}
+
 func UploadImage(c *gin.Context) {
     // Get the file from form
     file, header, err := c.Request.FormFile("image")
@@ -40,10 +68,16 @@ func UploadImage(c *gin.Context) {
 
     // Get other form data
     description := c.PostForm("description")
-    tagsStr := c.PostForm("tags")
-    tags := strings.Split(tagsStr, ",")
-    for i := range tags {
-        tags[i] = strings.TrimSpace(tags[i])
+    tags := db.ParseTags(c.PostForm("tags"))
+    language := strings.ToLower(c.DefaultPostForm("language", search.DefaultLanguage()))
+    if !search.ValidLanguage(language) {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported language", "languages": search.Languages()})
+        return
+    }
+    latitude, longitude, err := uploadLocation(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
     }
 
     // Generate UUID for filename
@@ -51,6 +85,29 @@ func UploadImage(c *gin.Context) {
     fileExt := filepath.Ext(originalFilename)
     uuidFilename := uuid.New().String() + fileExt
 
+    // Read capture details before the file is uploaded
+    exif, err := metadata.ReadEXIF(file)
+    if err != nil {
+        log.Printf("Warning: Failed to read EXIF data from %s: %v", originalFilename, err)
+    }
+    if _, err := file.Seek(0, io.SeekStart); err != nil {
+        log.Printf("Error rewinding uploaded file: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read uploaded file"})
+        return
+    }
+    tags, suggestions := applyTagSuggestions(tags, metadata.SuggestTags(originalFilename, exif))
+
+    // Formats the image package can't decode are stored without features
+    embedding, err := features.Decode(file)
+    if err != nil {
+        log.Printf("Warning: Failed to compute visual features of %s: %v", originalFilename, err)
+    }
+    if _, err := file.Seek(0, io.SeekStart); err != nil {
+        log.Printf("Error rewinding uploaded file: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read uploaded file"})
+        return
+    }
+
     // Upload to S3
     storagePath, err := storage.UploadFile(file, uuidFilename)
     if err != nil {
@@ -60,7 +117,27 @@ func UploadImage(c *gin.Context) {
     }
 
     // Save to database with both original and UUID filenames
-    image, err := db.CreateImage(originalFilename, uuidFilename, description, storagePath, tags)
+    image := &db.Image{
+        OriginalFilename: originalFilename,
+        UUIDFilename:     uuidFilename,
+        Description:      description,
+        Tags:             tags,
+        StoragePath:      storagePath,
+        Embedding:        embedding,
+        Language:         language,
+        Latitude:         latitude,
+        Longitude:        longitude,
+    }
+    if exif != nil {
+        image.TakenAt = exif.TakenAt
+        image.CameraMake = exif.Make
+        image.CameraModel = exif.Model
+        // A location given with the upload wins over the camera's
+        if image.Latitude == nil {
+            image.Latitude, image.Longitude = exif.Latitude, exif.Longitude
+        }
+    }
+    image, err = db.CreateImage(image)
     if err != nil {
         log.Printf("Error saving to database: %v", err)
         c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save to database"})
@@ -73,7 +150,47 @@ func UploadImage(c *gin.Context) {
         // Don't return error to client as the image is already saved
     }
 
-    c.JSON(http.StatusOK, image)
+    // Saved search notifications shouldn't hold up the upload
+    go notify.NewImage(image)
+
+    c.JSON(http.StatusOK, struct {
+        *db.Image
+        SuggestedTags []metadata.Suggestion `json:"suggested_tags"`
+    }{image, suggestions})
+}
+
+// applyTagSuggestions adds the suggestions from sources in AUTO_TAG_SOURCES
+// to tags and returns the rest, leaving out any the image already has.
+func applyTagSuggestions(tags []string, suggestions []metadata.Suggestion) ([]string, []metadata.Suggestion) {
+    auto := metadata.AutoTagSources()
+    remaining := []metadata.Suggestion{}
+    for _, suggestion := range suggestions {
+        tag := db.NormalizeTag(suggestion.Tag)
+        if tag == "" || containsTag(tags, tag) {
+            continue
+        }
+        if auto[suggestion.Source] {
+            tags = append(tags, tag)
+            continue
+        }
+        suggestion.Tag = tag
+        remaining = append(remaining, suggestion)
+    }
+    return db.NormalizeTags(tags), remaining
+}
+
+func containsTag(tags []string, tag string) bool {
+    for _, t := range tags {
+        if t == tag {
+            return true
+        }
+    }
+    return false
+}
+
+// ListLanguages returns the languages images may be described in.
+func ListLanguages(c *gin.Context) {
+    c.JSON(http.StatusOK, gin.H{"languages": search.Languages(), "default": search.DefaultLanguage()})
 }
 
 func GetImage(c *gin.Context) {
@@ -98,40 +215,181 @@ func GetImage(c *gin.Context) {
     c.HTML(http.StatusOK, "image.html", gin.H{"Image": image})
 }
 
+// Searches respond with a plain array of images. A spelling suggestion
+// and the ID of the logged search, for recording clicks, are sent in these
+// headers so existing clients keep working. The suggestion is URL encoded
+// as header values can't reliably carry non-ASCII text.
+const (
+    didYouMeanHeader = "X-Did-You-Mean"
+    searchIDHeader   = "X-Search-ID"
+)
+
+// searchResultImages converts search results to images with their URLs.
+func searchResultImages(results []search.SearchResult) []db.Image {
+    images := []db.Image{}
+    for _, result := range results {
+        image := db.Image{
+            ID:               result.ID,
+            OriginalFilename: result.OriginalFilename,
+            UUIDFilename:     result.UUIDFilename,
+            Description:      result.Description,
+            Tags:             result.Tags,
+            StoragePath:      result.StoragePath,
+            CreatedAt:        result.CreatedAt,
+            ViewCount:        result.ViewCount,
+            TakenAt:          result.TakenAt,
+            Language:         result.Language,
+            Latitude:         result.Latitude,
+            Longitude:        result.Longitude,
+            Albums:           result.Albums,
+        }
+        image.URL = storage.GetFileURL(result.StoragePath)
+        images = append(images, image)
+    }
+    return images
+}
+
+// imageFromParam loads the image named by the :id route parameter, writing
+// an error response and returning nil if it can't.
+func imageFromParam(c *gin.Context) *db.Image {
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID"})
+        return nil
+    }
+
+    image, err := db.GetImageByID(id)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch image"})
+        return nil
+    }
+    if image == nil {
+        c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+        return nil
+    }
+    image.URL = storage.GetFileURL(image.StoragePath)
+    return image
+}
+
+// GetImageDetails returns an image's details as JSON for the image modal.
+func GetImageDetails(c *gin.Context) {
+    image := imageFromParam(c)
+    if image == nil {
+        return
+    }
+    c.JSON(http.StatusOK, image)
+}
+
+const (
+    defaultRelatedImages = 6
+    maxRelatedImages     = 50
+)
+
+// RelatedImages returns up to limit images similar to the given one.
+func RelatedImages(c *gin.Context) {
+    limit, ok := queryInt(c, "limit", defaultRelatedImages, maxRelatedImages)
+    if !ok {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+        return
+    }
+    image := imageFromParam(c)
+    if image == nil {
+        return
+    }
+
+    related, err := search.RelatedImages(image, limit)
+    if err != nil {
+        log.Printf("Error fetching images related to %d: %v", image.ID, err)
+        searchError(c, err, "Failed to fetch related images")
+        return
+    }
+
+    c.JSON(http.StatusOK, searchResultImages(related))
+}
+
+// RecordView counts a view of an image and updates the count in the search
+// index so it feeds into search ranking.
+func RecordView(c *gin.Context) {
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID"})
+        return
+    }
+
+    image, err := db.IncrementViewCount(id)
+    if err != nil {
+        log.Printf("Error recording view of image %d: %v", id, err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record view"})
+        return
+    }
+    if image == nil {
+        c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+        return
+    }
+
+    if err := search.UpdateViewCount(image); err != nil {
+        log.Printf("Warning: Failed to update view count of image %d in search index: %v", id, err)
+    }
+
+    c.JSON(http.StatusOK, gin.H{"id": image.ID, "view_count": image.ViewCount})
+}
+
 func SearchImages(c *gin.Context) {
-    query := c.Query("q")
-    tags := c.Query("tags")
-    var images []db.Image
-    if query == "" && tags == "" {
+    dates, err := dateFilter(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    geo, err := search.ParseGeoFilter(c.Query("bbox"), c.Query("near"), c.Query("radius"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    var album int64
+    if value := c.Query("album"); value != "" {
+        if album, err = strconv.ParseInt(value, 10, 64); err != nil || album < 1 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid album ID"})
+            return
+        }
+    }
+    runSearch(c, search.SearchParams{
+        Query:         c.Query("q"),
+        Tags:          strings.Join(db.ParseTags(c.Query("tags")), ","),
+        RelevanceOnly: c.Query("ranking") == "relevance",
+        Dates:         dates,
+        Geo:           geo,
+        Album:         album,
+    })
+}
+
+// runSearch responds with the results of a search, or the most recent
+// images when it has no query, tags or filters.
+func runSearch(c *gin.Context, params search.SearchParams) {
+    images := []db.Image{}
+    if params.Query == "" && params.Tags == "" && !params.Filtered() {
         // Fetch the 9 most recent images from the database
-        var err error
-        images, err = db.GetRecentImages(9)
+        recent, err := db.GetRecentImages(9)
         if err != nil {
             c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch recent images"})
             return
         }
+        images = append(images, recent...)
     } else {
         // Search in Elasticsearch
-        searchResults, err := search.SearchImages(query, tags)
+        start := time.Now()
+        searchResponse, err := search.SearchImages(params)
         if err != nil {
-            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search images"})
+            log.Printf("Error searching images: %v", err)
+            searchError(c, err, "Failed to search images")
             return
         }
 
-        // Convert search results to images and construct URLs
-        for _, result := range searchResults {
-            image := db.Image{
-                ID:               result.ID,
-                OriginalFilename: result.OriginalFilename,
-                UUIDFilename:     result.UUIDFilename,
-                Description:      result.Description,
-                Tags:             result.Tags,
-                StoragePath:      result.StoragePath,
-                CreatedAt:        result.CreatedAt,
-                ViewCount:        result.ViewCount,
-            }
-            image.URL = storage.GetFileURL(result.StoragePath)
-            images = append(images, image)
+        images = append(images, searchResultImages(searchResponse.Results)...)
+        if searchResponse.DidYouMean != "" {
+            c.Header(didYouMeanHeader, url.PathEscape(searchResponse.DidYouMean))
+        }
+        if searchID := logSearch(params, len(images), time.Since(start)); searchID != 0 {
+            c.Header(searchIDHeader, strconv.FormatInt(searchID, 10))
         }
     }
 
@@ -150,9 +408,58 @@ func ReindexImages(c *gin.Context) {
     // Reindex all images in Elasticsearch
     if err := search.ReindexAll(images); err != nil {
         log.Printf("Error reindexing images: %v", err)
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reindex images"})
+        searchError(c, err, "Failed to reindex images")
         return
     }
 
     c.JSON(http.StatusOK, gin.H{"message": "Successfully reindexed all images"})
-}
\ No newline at end of file
+}
+
+func CollectOrphans(c *gin.Context) {
+    // Default to a dry run so the report can be reviewed before deleting
+    dryRun := true
+    if value := c.Query("dry_run"); value != "" {
+        parsed, err := strconv.ParseBool(value)
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid dry_run value"})
+            return
+        }
+        dryRun = parsed
+    }
+
+    gracePeriod := gc.GracePeriod()
+    if value := c.Query("grace"); value != "" {
+        parsed, err := time.ParseDuration(value)
+        if err != nil || parsed < 0 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid grace period"})
+            return
+        }
+        if parsed < gc.MinGracePeriod {
+            c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Grace period must be at least %s", gc.MinGracePeriod)})
+            return
+        }
+        gracePeriod = parsed
+    }
+
+    report, err := gc.CollectOrphans(gracePeriod, dryRun)
+    if err != nil {
+        log.Printf("Error collecting orphaned objects: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to collect orphaned objects"})
+        return
+    }
+
+    c.JSON(http.StatusOK, report)
+}
+
+// IndexStatus reports whether the search index exists and matches the
+// current mapping, and how its document count compares with the database.
+func IndexStatus(c *gin.Context) {
+    status, err := search.GetIndexStatus()
+    if err != nil {
+        log.Printf("Error checking search index: %v", err)
+        searchError(c, err, "Failed to check search index")
+        return
+    }
+
+    c.JSON(http.StatusOK, status)
+}
@@ -17,6 +17,47 @@ func SetupRoutes(r *gin.Engine) {
     r.POST("/upload", UploadImage)
     r.GET("/search", SearchImages)
     r.GET("/image/:id", GetImage)
+    r.GET("/api/images/locations", ImageLocations)
+    r.GET("/api/images/:id", GetImageDetails)
+    r.GET("/api/images/:id/related", RelatedImages)
+    r.GET("/api/images/:id/similar", SimilarImages)
+    r.POST("/api/search/image", SearchByImage)
+    r.POST("/api/search/clicks", RecordSearchClick)
+    r.POST("/api/images/:id/view", RecordView)
     r.GET("/reindex", ReindexImages)
+    r.GET("/api/languages", ListLanguages)
+    r.GET("/api/tags", ListTags)
+    r.GET("/api/tags/cloud", TagCloud)
     r.GET("/api/tags/suggest", SuggestTags)
+    r.GET("/api/tags/related", RelatedTags)
+
+    // Saved search routes
+    r.GET("/api/searches", ListSavedSearches)
+    r.POST("/api/searches", CreateSavedSearch)
+    r.GET("/api/searches/:id", GetSavedSearch)
+    r.DELETE("/api/searches/:id", DeleteSavedSearch)
+    r.GET("/api/searches/:id/results", RunSavedSearch)
+    r.GET("/api/notifications", ListNotifications)
+
+    // Album routes
+    r.GET("/api/albums", ListAlbums)
+    r.POST("/api/albums", CreateAlbum)
+    r.GET("/api/albums/:id", GetAlbum)
+    r.PATCH("/api/albums/:id", UpdateAlbum)
+    r.DELETE("/api/albums/:id", DeleteAlbum)
+    r.POST("/api/albums/:id/images", AddAlbumImages)
+    r.PUT("/api/albums/:id/images", ReorderAlbumImages)
+    r.DELETE("/api/albums/:id/images/:image_id", RemoveAlbumImage)
+
+    // Admin routes
+    r.POST("/api/admin/gc", CollectOrphans)
+    r.POST("/api/admin/embeddings", ComputeEmbeddings)
+    r.GET("/api/admin/search/report", SearchReport)
+    r.GET("/api/admin/search/index", IndexStatus)
+    r.POST("/api/admin/tags/rename", RenameTag)
+    r.POST("/api/admin/tags/merge", MergeTags)
+    r.DELETE("/api/admin/tags", DeleteTag)
+    r.GET("/api/admin/synonyms", ListTagSynonyms)
+    r.PUT("/api/admin/synonyms", SetTagSynonym)
+    r.DELETE("/api/admin/synonyms", DeleteTagSynonym)
 }
\ No newline at end of file
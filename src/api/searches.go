@@ -0,0 +1,204 @@
+package api
+
+import (
+    "errors"
+    "log"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+    "github.com/gin-gonic/gin"
+    "github.com/grrywlsn/imagerr/src/db"
+    "github.com/grrywlsn/imagerr/src/notify"
+    "github.com/grrywlsn/imagerr/src/search"
+)
+
+const (
+    defaultNotificationsPerPage = 50
+    maxNotificationsPerPage     = 500
+)
+
+type savedSearchRequest struct {
+    Name       string `json:"name"`
+    Query      string `json:"q"`
+    Tags       string `json:"tags"`
+    Date       string `json:"date"`
+    From       string `json:"from"`
+    To         string `json:"to"`
+    Notify     bool   `json:"notify"`
+    WebhookURL string `json:"webhook_url"`
+}
+
+// savedSearchFromParam loads the saved search named by the :id route
+// parameter, responding with an error and returning nil if it can't.
+func savedSearchFromParam(c *gin.Context) *db.SavedSearch {
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid saved search ID"})
+        return nil
+    }
+
+    saved, err := db.GetSavedSearch(id)
+    if errors.Is(err, db.ErrSavedSearchNotFound) {
+        c.JSON(http.StatusNotFound, gin.H{"error": "Saved search not found"})
+        return nil
+    }
+    if err != nil {
+        log.Printf("Error fetching saved search %d: %v", id, err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch saved search"})
+        return nil
+    }
+    return saved
+}
+
+func ListSavedSearches(c *gin.Context) {
+    searches, err := db.GetSavedSearches()
+    if err != nil {
+        log.Printf("Error fetching saved searches: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch saved searches"})
+        return
+    }
+
+    c.JSON(http.StatusOK, searches)
+}
+
+func GetSavedSearch(c *gin.Context) {
+    if saved := savedSearchFromParam(c); saved != nil {
+        c.JSON(http.StatusOK, saved)
+    }
+}
+
+// CreateSavedSearch saves a search under a name. The query, tags and date
+// fields take the same values as the /search parameters of the same name.
+func CreateSavedSearch(c *gin.Context) {
+    var req savedSearchRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid saved search"})
+        return
+    }
+
+    saved := &db.SavedSearch{
+        Name:       strings.TrimSpace(req.Name),
+        Query:      strings.TrimSpace(req.Query),
+        Tags:       db.ParseTags(req.Tags),
+        Date:       req.Date,
+        From:       req.From,
+        To:         req.To,
+        Notify:     req.Notify,
+        WebhookURL: strings.TrimSpace(req.WebhookURL),
+    }
+    if saved.Name == "" {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "'name' is required"})
+        return
+    }
+    if _, err := search.SavedSearchParams(saved, time.Now()); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    if saved.WebhookURL != "" {
+        if err := notify.ValidateWebhookURL(c.Request.Context(), saved.WebhookURL); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid 'webhook_url': " + err.Error()})
+            return
+        }
+    }
+
+    saved, err := db.CreateSavedSearch(saved)
+    if errors.Is(err, db.ErrSavedSearchExists) {
+        c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+        return
+    }
+    if err != nil {
+        log.Printf("Error saving search: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save search"})
+        return
+    }
+
+    if err := search.IndexSavedSearch(saved); err != nil {
+        log.Printf("Warning: Failed to index saved search %d: %v", saved.ID, err)
+        // The next reindex picks it up
+    }
+
+    c.JSON(http.StatusCreated, saved)
+}
+
+func DeleteSavedSearch(c *gin.Context) {
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid saved search ID"})
+        return
+    }
+
+    err = db.DeleteSavedSearch(id)
+    if errors.Is(err, db.ErrSavedSearchNotFound) {
+        c.JSON(http.StatusNotFound, gin.H{"error": "Saved search not found"})
+        return
+    }
+    if err != nil {
+        log.Printf("Error deleting saved search %d: %v", id, err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete saved search"})
+        return
+    }
+
+    if err := search.DeleteSavedSearch(id); err != nil {
+        log.Printf("Warning: Failed to remove saved search %d from the index: %v", id, err)
+    }
+
+    c.JSON(http.StatusOK, gin.H{"message": "Saved search deleted"})
+}
+
+// RunSavedSearch responds like /search with the current results of a saved
+// search.
+func RunSavedSearch(c *gin.Context) {
+    saved := savedSearchFromParam(c)
+    if saved == nil {
+        return
+    }
+
+    params, err := search.SavedSearchParams(saved, time.Now())
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    params.RelevanceOnly = c.Query("ranking") == "relevance"
+    runSearch(c, params)
+}
+
+// ListNotifications returns the feed of uploads that matched saved searches
+// with notifications on, newest first. Pass the highest ID already seen as
+// after to fetch only new entries, and saved_search to filter by search.
+func ListNotifications(c *gin.Context) {
+    limit, ok := queryInt(c, "limit", defaultNotificationsPerPage, maxNotificationsPerPage)
+    if !ok {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+        return
+    }
+
+    var afterID, savedSearchID int64
+    for _, param := range []struct {
+        name  string
+        value *int64
+    }{
+        {"after", &afterID},
+        {"saved_search", &savedSearchID},
+    } {
+        value := c.Query(param.name)
+        if value == "" {
+            continue
+        }
+        n, err := strconv.ParseInt(value, 10, 64)
+        if err != nil || n < 0 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param.name})
+            return
+        }
+        *param.value = n
+    }
+
+    notifications, err := db.GetNotifications(savedSearchID, afterID, limit)
+    if err != nil {
+        log.Printf("Error fetching notifications: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch notifications"})
+        return
+    }
+
+    c.JSON(http.StatusOK, notifications)
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+    "errors"
+    "log"
+    "net/http"
+    "github.com/gin-gonic/gin"
+    "github.com/grrywlsn/imagerr/src/db"
+    "github.com/grrywlsn/imagerr/src/features"
+    "github.com/grrywlsn/imagerr/src/search"
+    "github.com/grrywlsn/imagerr/src/storage"
+)
+
+const (
+    defaultSimilarImages = 12
+    maxSimilarImages     = 100
+    // maxSearchImageSize bounds the request body of a search by image
+    maxSearchImageSize = 20 << 20 // 20 MB
+)
+
+// SimilarImages returns the images that look most like the given one.
+func SimilarImages(c *gin.Context) {
+    limit, ok := queryInt(c, "limit", defaultSimilarImages, maxSimilarImages)
+    if !ok {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+        return
+    }
+    image := imageFromParam(c)
+    if image == nil {
+        return
+    }
+    if !features.Valid(image.Embedding) {
+        c.JSON(http.StatusConflict, gin.H{"error": "Image has no visual features yet, compute them with POST /api/admin/embeddings"})
+        return
+    }
+
+    similarImages(c, image.Embedding, image.ID, limit)
+}
+
+// SearchByImage returns the images that look most like an uploaded one,
+// which is not stored.
+func SearchByImage(c *gin.Context) {
+    limit, ok := queryInt(c, "limit", defaultSimilarImages, maxSimilarImages)
+    if !ok {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+        return
+    }
+    c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSearchImageSize)
+    file, _, err := c.Request.FormFile("image")
+    var tooLarge *http.MaxBytesError
+    if errors.As(err, &tooLarge) {
+        c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Image file is too large"})
+        return
+    }
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "No file uploaded"})
+        return
+    }
+    defer file.Close()
+
+    vector, err := features.Decode(file)
+    if errors.Is(err, features.ErrTooLarge) {
+        c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Image dimensions are too large"})
+        return
+    }
+    if err != nil || !features.Valid(vector) {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported or empty image, use JPEG, PNG or GIF"})
+        return
+    }
+
+    similarImages(c, vector, 0, limit)
+}
+
+func similarImages(c *gin.Context, vector []float32, excludeID int64, limit int) {
+    similar, err := search.SimilarImages(vector, excludeID, limit)
+    if err != nil {
+        log.Printf("Error searching similar images: %v", err)
+        searchError(c, err, "Failed to search similar images")
+        return
+    }
+    c.JSON(http.StatusOK, searchResultImages(similar))
+}
+
+// ComputeEmbeddings computes the visual features of images uploaded before
+// they were computed at upload time, reindexing each one.
+func ComputeEmbeddings(c *gin.Context) {
+    images, err := db.GetAllImages()
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch images"})
+        return
+    }
+
+    computed, failed := 0, []int64{}
+    for i := range images {
+        image := &images[i]
+        if image.Embedding != nil {
+            continue
+        }
+        if err := computeEmbedding(image); err != nil {
+            log.Printf("Error computing embedding for image %d: %v", image.ID, err)
+            failed = append(failed, image.ID)
+            continue
+        }
+        if err := search.IndexImage(image); err != nil {
+            log.Printf("Warning: Failed to reindex image %d: %v", image.ID, err)
+        }
+        computed++
+    }
+
+    c.JSON(http.StatusOK, gin.H{"computed": computed, "failed": failed})
+}
+
+func computeEmbedding(image *db.Image) error {
+    file, err := storage.DownloadFile(image.StoragePath)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    embedding, err := features.Decode(file)
+    if err != nil {
+        return err
+    }
+    if err := db.SetImageEmbedding(image.ID, embedding); err != nil {
+        return err
+    }
+    image.Embedding = embedding
+    return nil
+}
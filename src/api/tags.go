@@ -0,0 +1,319 @@
+package api
+
+import (
+    "errors"
+    "log"
+    "math"
+    "net/http"
+    "sort"
+    "strconv"
+    "github.com/gin-gonic/gin"
+    "github.com/grrywlsn/imagerr/src/db"
+    "github.com/grrywlsn/imagerr/src/search"
+)
+
+type renameTagRequest struct {
+    From string `json:"from" binding:"required"`
+    To   string `json:"to" binding:"required"`
+}
+
+type mergeTagsRequest struct {
+    Sources []string `json:"sources" binding:"required"`
+    Target  string   `json:"target" binding:"required"`
+}
+
+// reindexImages pushes the current database state of the given images to the
+// search backend. Failures are logged and counted rather than returned, as
+// the database change they follow has already been committed.
+func reindexImages(ids []int64) int {
+    if len(ids) == 0 {
+        return 0
+    }
+
+    images, err := db.GetImagesByIDs(ids)
+    if err != nil {
+        log.Printf("Error fetching images %v for reindexing: %v", ids, err)
+        return len(ids)
+    }
+
+    failed := 0
+    for i := range images {
+        if err := search.IndexImage(&images[i]); err != nil {
+            log.Printf("Warning: Failed to reindex image %d: %v", images[i].ID, err)
+            failed++
+        }
+    }
+    return failed
+}
+
+func tagChangeResponse(c *gin.Context, ids []int64, err error, message string, tags ...string) {
+    switch {
+    case errors.Is(err, db.ErrTagNotFound):
+        c.JSON(http.StatusNotFound, gin.H{"error": "Tag not found"})
+    case errors.Is(err, db.ErrTagExists):
+        c.JSON(http.StatusConflict, gin.H{"error": "Target tag already exists, merge the tags instead"})
+    case err != nil:
+        log.Printf("Error updating tags: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+    default:
+        if err := search.SyncTags(db.NormalizeTags(tags)); err != nil {
+            log.Printf("Warning: Failed to update tag suggestions: %v", err)
+        }
+        // The cached nested tags may have been renamed or removed
+        reloadSynonyms()
+        c.JSON(http.StatusOK, gin.H{
+            "updated_images":   len(ids),
+            "reindex_failures": reindexImages(ids),
+        })
+    }
+}
+
+func RenameTag(c *gin.Context) {
+    var req renameTagRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Both 'from' and 'to' are required"})
+        return
+    }
+
+    ids, err := db.RenameTag(req.From, req.To)
+    tagChangeResponse(c, ids, err, "Failed to rename tag", req.From, req.To)
+}
+
+func MergeTags(c *gin.Context) {
+    var req mergeTagsRequest
+    if err := c.ShouldBindJSON(&req); err != nil || len(req.Sources) == 0 {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Both 'sources' and 'target' are required"})
+        return
+    }
+
+    ids, err := db.MergeTags(req.Sources, req.Target)
+    tagChangeResponse(c, ids, err, "Failed to merge tags", append(req.Sources, req.Target)...)
+}
+
+func DeleteTag(c *gin.Context) {
+    name := c.Query("name")
+    if name == "" {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Query parameter 'name' is required"})
+        return
+    }
+
+    ids, err := db.DeleteTag(name)
+    tagChangeResponse(c, ids, err, "Failed to delete tag", name)
+}
+
+type tagSynonymRequest struct {
+    Alias string `json:"alias" binding:"required"`
+    Tag   string `json:"tag" binding:"required"`
+}
+
+func reloadSynonyms() {
+    if err := search.ReloadSynonyms(); err != nil {
+        log.Printf("Warning: Failed to reload tag synonyms: %v", err)
+    }
+}
+
+func ListTagSynonyms(c *gin.Context) {
+    synonyms, err := db.GetTagSynonyms()
+    if err != nil {
+        log.Printf("Error fetching tag synonyms: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tag synonyms"})
+        return
+    }
+
+    c.JSON(http.StatusOK, synonyms)
+}
+
+func SetTagSynonym(c *gin.Context) {
+    var req tagSynonymRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Both 'alias' and 'tag' are required"})
+        return
+    }
+
+    synonym, err := db.SetTagSynonym(req.Alias, req.Tag)
+    if errors.Is(err, db.ErrInvalidSynonym) {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    if err != nil {
+        log.Printf("Error saving tag synonym: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save tag synonym"})
+        return
+    }
+
+    reloadSynonyms()
+    c.JSON(http.StatusOK, synonym)
+}
+
+func DeleteTagSynonym(c *gin.Context) {
+    alias := c.Query("alias")
+    if alias == "" {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Query parameter 'alias' is required"})
+        return
+    }
+
+    err := db.DeleteTagSynonym(alias)
+    if errors.Is(err, db.ErrTagNotFound) {
+        c.JSON(http.StatusNotFound, gin.H{"error": "Synonym not found"})
+        return
+    }
+    if err != nil {
+        log.Printf("Error deleting tag synonym: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete tag synonym"})
+        return
+    }
+
+    reloadSynonyms()
+    c.JSON(http.StatusOK, gin.H{"message": "Synonym deleted"})
+}
+
+const (
+    defaultTagsPerPage = 50
+    maxTagsPerPage     = 500
+    defaultCloudSize   = 50
+    maxCloudWeight     = 10
+)
+
+// queryInt reads a positive integer query parameter, falling back to def
+// when it is missing and capping it at max.
+func queryInt(c *gin.Context, name string, def, max int) (int, bool) {
+    value := c.Query(name)
+    if value == "" {
+        return def, true
+    }
+    n, err := strconv.Atoi(value)
+    if err != nil || n < 1 {
+        return 0, false
+    }
+    if n > max {
+        n = max
+    }
+    return n, true
+}
+
+func ListTags(c *gin.Context) {
+    sortBy := c.DefaultQuery("sort", "count")
+    if !db.ValidTagStatSort(sortBy) {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sort, use count, name, first_used or last_used"})
+        return
+    }
+
+    // Counts and dates read best largest and newest first, names A to Z
+    descending := sortBy != "name"
+    switch c.Query("order") {
+    case "":
+    case "asc":
+        descending = false
+    case "desc":
+        descending = true
+    default:
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order, use asc or desc"})
+        return
+    }
+
+    page, ok := queryInt(c, "page", 1, math.MaxInt32)
+    if !ok {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page"})
+        return
+    }
+    perPage, ok := queryInt(c, "per_page", defaultTagsPerPage, maxTagsPerPage)
+    if !ok {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid per_page"})
+        return
+    }
+
+    tags, total, err := db.GetTagStats(sortBy, descending, perPage, (page-1)*perPage)
+    if err != nil {
+        log.Printf("Error fetching tag statistics: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tags"})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{
+        "tags":     tags,
+        "total":    total,
+        "page":     page,
+        "per_page": perPage,
+    })
+}
+
+type tagCloudEntry struct {
+    Name       string `json:"name"`
+    ImageCount int    `json:"image_count"`
+    Weight     int    `json:"weight"`
+}
+
+// TagCloud returns the most used tags in alphabetical order, each weighted
+// from 1 to maxCloudWeight on a log scale of its image count.
+func TagCloud(c *gin.Context) {
+    limit, ok := queryInt(c, "limit", defaultCloudSize, maxTagsPerPage)
+    if !ok {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+        return
+    }
+
+    tags, _, err := db.GetTagStats("count", true, limit, 0)
+    if err != nil {
+        log.Printf("Error fetching tag statistics: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tag cloud"})
+        return
+    }
+
+    c.JSON(http.StatusOK, tagCloud(tags))
+}
+
+// tagCloud weights each tag between the least and most used of them and
+// sorts the result by name. When every tag is used equally they all get
+// the full weight.
+func tagCloud(tags []db.TagStat) []tagCloudEntry {
+    cloud := []tagCloudEntry{}
+    if len(tags) == 0 {
+        return cloud
+    }
+
+    minLog, maxLog := math.Inf(1), math.Inf(-1)
+    for _, tag := range tags {
+        minLog = min(minLog, countLog(tag.ImageCount))
+        maxLog = max(maxLog, countLog(tag.ImageCount))
+    }
+    for _, tag := range tags {
+        weight := maxCloudWeight
+        if maxLog > minLog {
+            scaled := (countLog(tag.ImageCount) - minLog) / (maxLog - minLog)
+            weight = 1 + int(math.Round(scaled*float64(maxCloudWeight-1)))
+        }
+        cloud = append(cloud, tagCloudEntry{Name: tag.Name, ImageCount: tag.ImageCount, Weight: weight})
+    }
+    sort.Slice(cloud, func(i, j int) bool { return cloud[i].Name < cloud[j].Name })
+    return cloud
+}
+
+// countLog treats unused tags as used once so they sit at the bottom of
+// the scale instead of at negative infinity.
+func countLog(count int) float64 {
+    return math.Log(float64(max(count, 1)))
+}
+
+const defaultRelatedTags = 10
+
+func RelatedTags(c *gin.Context) {
+    tags := db.ParseTags(c.Query("tags"))
+    if len(tags) == 0 {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Query parameter 'tags' is required"})
+        return
+    }
+    limit, ok := queryInt(c, "limit", defaultRelatedTags, maxTagsPerPage)
+    if !ok {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+        return
+    }
+
+    related, err := search.RelatedTags(tags, limit)
+    if err != nil {
+        log.Printf("Error fetching related tags: %v", err)
+        searchError(c, err, "Failed to fetch related tags")
+        return
+    }
+
+    c.JSON(http.StatusOK, related)
+}
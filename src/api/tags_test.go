@@ -0,0 +1,46 @@
+package api
+
+import (
+    "reflect"
+    "testing"
+    "github.com/grrywlsn/imagerr/src/db"
+)
+
+func TestTagCloud(t *testing.T) {
+    for _, test := range []struct {
+        name string
+        tags []db.TagStat
+        want []tagCloudEntry
+    }{
+        {"no tags", nil, []tagCloudEntry{}},
+        {
+            "single tag",
+            []db.TagStat{{Name: "cat", ImageCount: 3}},
+            []tagCloudEntry{{"cat", 3, 10}},
+        },
+        {
+            "equally used",
+            []db.TagStat{{Name: "dog", ImageCount: 5}, {Name: "cat", ImageCount: 5}},
+            []tagCloudEntry{{"cat", 5, 10}, {"dog", 5, 10}},
+        },
+        {
+            "log scale",
+            []db.TagStat{{Name: "sky", ImageCount: 1000}, {Name: "cat", ImageCount: 10}, {Name: "ant", ImageCount: 1}},
+            []tagCloudEntry{{"ant", 1, 1}, {"cat", 10, 4}, {"sky", 1000, 10}},
+        },
+        {
+            "unordered counts",
+            []db.TagStat{{Name: "ant", ImageCount: 1}, {Name: "sky", ImageCount: 1000}, {Name: "cat", ImageCount: 10}},
+            []tagCloudEntry{{"ant", 1, 1}, {"cat", 10, 4}, {"sky", 1000, 10}},
+        },
+        {
+            "unused tag",
+            []db.TagStat{{Name: "sky", ImageCount: 100}, {Name: "cat", ImageCount: 1}, {Name: "ant", ImageCount: 0}},
+            []tagCloudEntry{{"ant", 0, 1}, {"cat", 1, 1}, {"sky", 100, 10}},
+        },
+    } {
+        if got := tagCloud(test.tags); !reflect.DeepEqual(got, test.want) {
+            t.Errorf("%s: tagCloud = %+v, want %+v", test.name, got, test.want)
+        }
+    }
+}
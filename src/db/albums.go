@@ -0,0 +1,398 @@
+package db
+
+import (
+    "database/sql"
+    "errors"
+    "time"
+    "github.com/lib/pq"
+)
+
+var (
+    ErrAlbumNotFound      = errors.New("album not found")
+    ErrAlbumExists        = errors.New("an album with that name already exists")
+    ErrImageNotInAlbum    = errors.New("image is not in the album")
+    ErrImageNotFound      = errors.New("image not found")
+    ErrAlbumOrderMismatch = errors.New("the new order must list every image in the album exactly once")
+)
+
+// Album is a named, ordered collection of images. CoverImageID is the
+// chosen cover, or the first image when none has been chosen.
+type Album struct {
+    ID           int64     `json:"id"`
+    Name         string    `json:"name"`
+    Description  string    `json:"description"`
+    CoverImageID *int64    `json:"cover_image_id,omitempty"`
+    ImageCount   int       `json:"image_count"`
+    CreatedAt    time.Time `json:"created_at"`
+    UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// AlbumUpdate holds the album fields to change; nil fields are left alone.
+// A CoverImageID of 0 clears the chosen cover.
+type AlbumUpdate struct {
+    Name         *string
+    Description  *string
+    CoverImageID *int64
+}
+
+const albumColumns = `a.id, a.name, a.description,
+        COALESCE(a.cover_image_id, (SELECT image_id FROM album_images WHERE album_id = a.id ORDER BY position LIMIT 1)),
+        (SELECT COUNT(*) FROM album_images WHERE album_id = a.id),
+        a.created_at, a.updated_at`
+
+func scanAlbum(row rowScanner) (*Album, error) {
+    var album Album
+    var cover sql.NullInt64
+    err := row.Scan(&album.ID, &album.Name, &album.Description, &cover, &album.ImageCount, &album.CreatedAt, &album.UpdatedAt)
+    if err != nil {
+        return nil, err
+    }
+    if cover.Valid {
+        album.CoverImageID = &cover.Int64
+    }
+    return &album, nil
+}
+
+func CreateAlbum(name, description string) (*Album, error) {
+    var id int64
+    err := DB.QueryRow(`INSERT INTO albums (name, description) VALUES ($1, $2) RETURNING id`, name, description).Scan(&id)
+    if isUniqueViolation(err) {
+        return nil, ErrAlbumExists
+    }
+    if err != nil {
+        return nil, err
+    }
+    return GetAlbum(id)
+}
+
+func GetAlbums() ([]Album, error) {
+    rows, err := DB.Query(`SELECT ` + albumColumns + ` FROM albums a ORDER BY a.name`)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    albums := []Album{}
+    for rows.Next() {
+        album, err := scanAlbum(rows)
+        if err != nil {
+            return nil, err
+        }
+        albums = append(albums, *album)
+    }
+    if err = rows.Err(); err != nil {
+        return nil, err
+    }
+    return albums, nil
+}
+
+func GetAlbum(id int64) (*Album, error) {
+    album, err := scanAlbum(DB.QueryRow(`SELECT `+albumColumns+` FROM albums a WHERE a.id = $1`, id))
+    if err == sql.ErrNoRows {
+        return nil, ErrAlbumNotFound
+    }
+    return album, err
+}
+
+// UpdateAlbum renames an album or changes its description or cover. The
+// cover must be one of the album's images.
+func UpdateAlbum(id int64, update AlbumUpdate) (*Album, error) {
+    tx, err := DB.Begin()
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback()
+
+    if err := lockAlbum(tx, id); err != nil {
+        return nil, err
+    }
+
+    if update.Name != nil {
+        _, err := tx.Exec(`UPDATE albums SET name = $2 WHERE id = $1`, id, *update.Name)
+        if isUniqueViolation(err) {
+            return nil, ErrAlbumExists
+        }
+        if err != nil {
+            return nil, err
+        }
+    }
+    if update.Description != nil {
+        if _, err := tx.Exec(`UPDATE albums SET description = $2 WHERE id = $1`, id, *update.Description); err != nil {
+            return nil, err
+        }
+    }
+    if update.CoverImageID != nil {
+        var cover interface{}
+        if *update.CoverImageID != 0 {
+            var member bool
+            err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM album_images WHERE album_id = $1 AND image_id = $2)`,
+                id, *update.CoverImageID).Scan(&member)
+            if err != nil {
+                return nil, err
+            }
+            if !member {
+                return nil, ErrImageNotInAlbum
+            }
+            cover = *update.CoverImageID
+        }
+        if _, err := tx.Exec(`UPDATE albums SET cover_image_id = $2 WHERE id = $1`, id, cover); err != nil {
+            return nil, err
+        }
+    }
+
+    if _, err := tx.Exec(`UPDATE albums SET updated_at = CURRENT_TIMESTAMP WHERE id = $1`, id); err != nil {
+        return nil, err
+    }
+    if err := tx.Commit(); err != nil {
+        return nil, err
+    }
+    return GetAlbum(id)
+}
+
+// DeleteAlbum deletes an album, leaving its images in place, and returns
+// the IDs of the images that were in it.
+func DeleteAlbum(id int64) ([]int64, error) {
+    tx, err := DB.Begin()
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback()
+
+    ids, err := albumImageIDs(tx, id)
+    if err != nil {
+        return nil, err
+    }
+    result, err := tx.Exec(`DELETE FROM albums WHERE id = $1`, id)
+    if err != nil {
+        return nil, err
+    }
+    if n, _ := result.RowsAffected(); n == 0 {
+        return nil, ErrAlbumNotFound
+    }
+    return ids, tx.Commit()
+}
+
+// lockAlbum locks an album's row so concurrent changes to the album and its
+// images apply one at a time.
+func lockAlbum(tx *sql.Tx, id int64) error {
+    err := tx.QueryRow(`SELECT id FROM albums WHERE id = $1 FOR UPDATE`, id).Scan(&id)
+    if err == sql.ErrNoRows {
+        return ErrAlbumNotFound
+    }
+    return err
+}
+
+// albumImageIDs locks an album and returns its images in order.
+func albumImageIDs(tx *sql.Tx, albumID int64) ([]int64, error) {
+    if err := lockAlbum(tx, albumID); err != nil {
+        return nil, err
+    }
+
+    rows, err := tx.Query(`SELECT image_id FROM album_images WHERE album_id = $1 ORDER BY position`, albumID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var ids []int64
+    for rows.Next() {
+        var id int64
+        if err := rows.Scan(&id); err != nil {
+            return nil, err
+        }
+        ids = append(ids, id)
+    }
+    return ids, rows.Err()
+}
+
+// AddAlbumImages appends images to the end of an album in the order given,
+// skipping any already in it, and returns the IDs of the images added.
+// Unknown image IDs return ErrImageNotFound.
+func AddAlbumImages(albumID int64, imageIDs []int64) ([]int64, error) {
+    tx, err := DB.Begin()
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback()
+
+    existing, err := albumImageIDs(tx, albumID)
+    if err != nil {
+        return nil, err
+    }
+
+    added := imagesToAdd(existing, imageIDs)
+    for i, imageID := range added {
+        _, err := tx.Exec(`
+            INSERT INTO album_images (album_id, image_id, position)
+            VALUES ($1, $2, $3)`,
+            albumID, imageID, len(existing)+i,
+        )
+        var pqErr *pq.Error
+        if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+            return nil, ErrImageNotFound
+        }
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    if _, err := tx.Exec(`UPDATE albums SET updated_at = CURRENT_TIMESTAMP WHERE id = $1`, albumID); err != nil {
+        return nil, err
+    }
+    return added, tx.Commit()
+}
+
+// RemoveAlbumImage takes an image out of an album, closing the gap in the
+// order and clearing the cover if it was the cover.
+func RemoveAlbumImage(albumID, imageID int64) error {
+    tx, err := DB.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    existing, err := albumImageIDs(tx, albumID)
+    if err != nil {
+        return err
+    }
+    remaining, found := withoutImage(existing, imageID)
+    if !found {
+        return ErrImageNotInAlbum
+    }
+
+    if _, err := tx.Exec(`DELETE FROM album_images WHERE album_id = $1 AND image_id = $2`, albumID, imageID); err != nil {
+        return err
+    }
+    if err := setAlbumPositions(tx, albumID, remaining); err != nil {
+        return err
+    }
+    _, err = tx.Exec(`
+        UPDATE albums
+        SET cover_image_id = NULLIF(cover_image_id, $2), updated_at = CURRENT_TIMESTAMP
+        WHERE id = $1`,
+        albumID, imageID,
+    )
+    if err != nil {
+        return err
+    }
+    return tx.Commit()
+}
+
+// ReorderAlbumImages puts an album's images in the given order, which must
+// list each of them exactly once.
+func ReorderAlbumImages(albumID int64, imageIDs []int64) error {
+    tx, err := DB.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    existing, err := albumImageIDs(tx, albumID)
+    if err != nil {
+        return err
+    }
+    if err := checkAlbumOrder(existing, imageIDs); err != nil {
+        return err
+    }
+
+    if err := setAlbumPositions(tx, albumID, imageIDs); err != nil {
+        return err
+    }
+    if _, err := tx.Exec(`UPDATE albums SET updated_at = CURRENT_TIMESTAMP WHERE id = $1`, albumID); err != nil {
+        return err
+    }
+    return tx.Commit()
+}
+
+// imagesToAdd returns the images to append to an album holding existing, in
+// the order given, leaving out those already in it and repeats.
+func imagesToAdd(existing, imageIDs []int64) []int64 {
+    inAlbum := make(map[int64]bool)
+    for _, id := range existing {
+        inAlbum[id] = true
+    }
+    var added []int64
+    for _, id := range imageIDs {
+        if !inAlbum[id] {
+            inAlbum[id] = true
+            added = append(added, id)
+        }
+    }
+    return added
+}
+
+// withoutImage returns the album's images without imageID, and whether it
+// was in the album.
+func withoutImage(existing []int64, imageID int64) ([]int64, bool) {
+    remaining := []int64{}
+    for _, id := range existing {
+        if id != imageID {
+            remaining = append(remaining, id)
+        }
+    }
+    return remaining, len(remaining) != len(existing)
+}
+
+// checkAlbumOrder returns ErrAlbumOrderMismatch unless order lists each of
+// the existing images exactly once.
+func checkAlbumOrder(existing, order []int64) error {
+    if len(order) != len(existing) {
+        return ErrAlbumOrderMismatch
+    }
+    inAlbum := make(map[int64]bool)
+    for _, id := range existing {
+        inAlbum[id] = true
+    }
+    for _, id := range order {
+        if !inAlbum[id] {
+            return ErrAlbumOrderMismatch
+        }
+        // Each image may only be listed once
+        delete(inAlbum, id)
+    }
+    return nil
+}
+
+// setAlbumPositions numbers the album's images from 0 in the given order.
+func setAlbumPositions(tx *sql.Tx, albumID int64, imageIDs []int64) error {
+    _, err := tx.Exec(`
+        UPDATE album_images SET position = ordered.position - 1
+        FROM unnest($2::bigint[]) WITH ORDINALITY AS ordered (image_id, position)
+        WHERE album_images.album_id = $1 AND album_images.image_id = ordered.image_id`,
+        albumID, pq.Array(imageIDs),
+    )
+    return err
+}
+
+// GetAlbumImages returns a page of an album's images in album order, along
+// with the number of images in the album.
+func GetAlbumImages(albumID int64, offset, limit int) ([]Image, int, error) {
+    var total int
+    err := DB.QueryRow(`SELECT COUNT(*) FROM album_images WHERE album_id = $1`, albumID).Scan(&total)
+    if err != nil {
+        return nil, 0, err
+    }
+
+    rows, err := DB.Query(`
+        SELECT `+imageColumns+`
+        FROM images
+        JOIN album_images ON album_images.image_id = images.id
+        WHERE album_images.album_id = $1
+        ORDER BY album_images.position
+        OFFSET $2
+        LIMIT $3
+    `, albumID, offset, limit)
+    if err != nil {
+        return nil, 0, err
+    }
+    defer rows.Close()
+
+    images, err := scanImages(rows)
+    if err != nil {
+        return nil, 0, err
+    }
+    if images == nil {
+        images = []Image{}
+    }
+    return images, total, nil
+}
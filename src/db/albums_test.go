@@ -0,0 +1,67 @@
+package db
+
+import (
+    "errors"
+    "reflect"
+    "testing"
+)
+
+func TestImagesToAdd(t *testing.T) {
+    for _, test := range []struct {
+        existing, imageIDs, want []int64
+    }{
+        {nil, nil, nil},
+        {nil, []int64{3, 1, 2}, []int64{3, 1, 2}},
+        {[]int64{1, 2}, []int64{2, 3, 1, 4}, []int64{3, 4}},
+        {[]int64{1}, []int64{5, 5, 6, 5}, []int64{5, 6}},
+        {[]int64{1, 2}, []int64{2, 1}, nil},
+    } {
+        if got := imagesToAdd(test.existing, test.imageIDs); !reflect.DeepEqual(got, test.want) {
+            t.Errorf("imagesToAdd(%v, %v) = %v, want %v", test.existing, test.imageIDs, got, test.want)
+        }
+    }
+}
+
+func TestWithoutImage(t *testing.T) {
+    for _, test := range []struct {
+        existing  []int64
+        imageID   int64
+        remaining []int64
+        found     bool
+    }{
+        {[]int64{4, 2, 9}, 2, []int64{4, 9}, true},
+        {[]int64{4, 2, 9}, 4, []int64{2, 9}, true},
+        {[]int64{4}, 4, []int64{}, true},
+        {[]int64{4, 2, 9}, 7, []int64{4, 2, 9}, false},
+        {nil, 7, []int64{}, false},
+    } {
+        remaining, found := withoutImage(test.existing, test.imageID)
+        if found != test.found || !reflect.DeepEqual(remaining, test.remaining) {
+            t.Errorf("withoutImage(%v, %d) = %v, %v, want %v, %v",
+                test.existing, test.imageID, remaining, found, test.remaining, test.found)
+        }
+    }
+}
+
+func TestCheckAlbumOrder(t *testing.T) {
+    existing := []int64{1, 2, 3}
+    for _, test := range []struct {
+        order []int64
+        want  error
+    }{
+        {[]int64{3, 1, 2}, nil},
+        {[]int64{1, 2, 3}, nil},
+        {[]int64{1, 2}, ErrAlbumOrderMismatch},
+        {[]int64{1, 2, 3, 4}, ErrAlbumOrderMismatch},
+        {[]int64{1, 2, 4}, ErrAlbumOrderMismatch},
+        {[]int64{1, 1, 2}, ErrAlbumOrderMismatch},
+        {nil, ErrAlbumOrderMismatch},
+    } {
+        if got := checkAlbumOrder(existing, test.order); !errors.Is(got, test.want) {
+            t.Errorf("checkAlbumOrder(%v, %v) = %v, want %v", existing, test.order, got, test.want)
+        }
+    }
+    if err := checkAlbumOrder(nil, nil); err != nil {
+        t.Errorf("checkAlbumOrder of an empty album = %v, want nil", err)
+    }
+}
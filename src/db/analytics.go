@@ -0,0 +1,163 @@
+package db
+
+import (
+    "errors"
+    "sort"
+    "time"
+    "github.com/lib/pq"
+)
+
+var ErrSearchLogNotFound = errors.New("search or image not found")
+
+// SearchLog records one search and how it went.
+type SearchLog struct {
+    Query       string
+    Tags        []string
+    ResultCount int
+    Latency     time.Duration
+}
+
+// QueryStat summarises the searches for one query and tag combination.
+// Queries are grouped case-insensitively.
+type QueryStat struct {
+    Query            string    `json:"query"`
+    Tags             []string  `json:"tags"`
+    Searches         int       `json:"searches"`
+    AvgResults       float64   `json:"avg_results"`
+    ClickedSearches  int       `json:"clicked_searches"`
+    ClickThroughRate float64   `json:"click_through_rate"`
+    LastSearchedAt   time.Time `json:"last_searched_at"`
+}
+
+// SearchReport summarises the searches made since a point in time.
+type SearchReport struct {
+    Since              time.Time   `json:"since"`
+    Searches           int         `json:"searches"`
+    ZeroResultSearches int         `json:"zero_result_searches"`
+    ClickedSearches    int         `json:"clicked_searches"`
+    ClickThroughRate   float64     `json:"click_through_rate"`
+    AvgLatencyMs       float64     `json:"avg_latency_ms"`
+    TopQueries         []QueryStat `json:"top_queries"`
+    ZeroResultQueries  []QueryStat `json:"zero_result_queries"`
+}
+
+// LogSearch stores a search and returns its ID, which clicks on its results
+// are recorded against. Tags are sorted so the same filter in any order
+// groups together in reports.
+func LogSearch(entry SearchLog) (int64, error) {
+    tags := append([]string{}, entry.Tags...)
+    sort.Strings(tags)
+
+    var id int64
+    err := DB.QueryRow(`
+        INSERT INTO search_logs (query, tags, result_count, latency_ms)
+        VALUES ($1, $2, $3, $4)
+        RETURNING id`,
+        entry.Query, pq.Array(tags), entry.ResultCount, float64(entry.Latency)/float64(time.Millisecond),
+    ).Scan(&id)
+    return id, err
+}
+
+// LogSearchClick records that the result at position (counting from 1) of
+// a logged search was opened.
+func LogSearchClick(searchID, imageID int64, position int) error {
+    _, err := DB.Exec(`
+        INSERT INTO search_clicks (search_id, image_id, position)
+        VALUES ($1, $2, $3)`,
+        searchID, imageID, position,
+    )
+    var pqErr *pq.Error
+    if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+        return ErrSearchLogNotFound
+    }
+    return err
+}
+
+// GetSearchReport returns totals for the searches made since the given
+// time, the limit most frequent queries and the limit most frequent queries
+// that found nothing. A search counts as clicked if any of its results
+// were opened.
+func GetSearchReport(since time.Time, limit int) (*SearchReport, error) {
+    report := &SearchReport{Since: since}
+    err := DB.QueryRow(`
+        SELECT COUNT(*),
+               COUNT(*) FILTER (WHERE l.result_count = 0),
+               COUNT(c.search_id),
+               COALESCE(AVG(l.latency_ms), 0)
+        FROM search_logs l
+        LEFT JOIN (SELECT DISTINCT search_id FROM search_clicks) c ON c.search_id = l.id
+        WHERE l.created_at >= $1`,
+        since,
+    ).Scan(&report.Searches, &report.ZeroResultSearches, &report.ClickedSearches, &report.AvgLatencyMs)
+    if err != nil {
+        return nil, err
+    }
+    report.ClickThroughRate = clickThroughRate(report.ClickedSearches, report.Searches)
+
+    if report.TopQueries, err = queryStats(since, limit, false); err != nil {
+        return nil, err
+    }
+    if report.ZeroResultQueries, err = queryStats(since, limit, true); err != nil {
+        return nil, err
+    }
+    return report, nil
+}
+
+func queryStats(since time.Time, limit int, zeroResults bool) ([]QueryStat, error) {
+    rows, err := DB.Query(`
+        SELECT lower(l.query), l.tags, COUNT(*), AVG(l.result_count), COUNT(c.search_id), MAX(l.created_at)
+        FROM search_logs l
+        LEFT JOIN (SELECT DISTINCT search_id FROM search_clicks) c ON c.search_id = l.id
+        WHERE l.created_at >= $1 AND (NOT $2 OR l.result_count = 0)
+        GROUP BY lower(l.query), l.tags
+        ORDER BY COUNT(*) DESC, MAX(l.created_at) DESC
+        LIMIT $3`,
+        since, zeroResults, limit,
+    )
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    stats := []QueryStat{}
+    for rows.Next() {
+        var stat QueryStat
+        err := rows.Scan(
+            &stat.Query,
+            pq.Array(&stat.Tags),
+            &stat.Searches,
+            &stat.AvgResults,
+            &stat.ClickedSearches,
+            &stat.LastSearchedAt,
+        )
+        if err != nil {
+            return nil, err
+        }
+        if stat.Tags == nil {
+            stat.Tags = []string{}
+        }
+        stat.ClickThroughRate = clickThroughRate(stat.ClickedSearches, stat.Searches)
+        stats = append(stats, stat)
+    }
+    if err = rows.Err(); err != nil {
+        return nil, err
+    }
+    return stats, nil
+}
+
+func clickThroughRate(clicked, searches int) float64 {
+    if searches == 0 {
+        return 0
+    }
+    return float64(clicked) / float64(searches)
+}
+
+// DeleteSearchLogsBefore removes searches made before the given time, along
+// with their clicks, and returns how many searches were removed.
+func DeleteSearchLogsBefore(before time.Time) (int64, error) {
+    result, err := DB.Exec(`DELETE FROM search_logs WHERE created_at < $1`, before)
+    if err != nil {
+        return 0, err
+    }
+    return result.RowsAffected()
+}
@@ -0,0 +1,119 @@
+package db
+
+import (
+    "fmt"
+    "math"
+)
+
+// earthRadius is the mean radius of the Earth in metres, as Elasticsearch
+// uses for distances.
+const earthRadius = 6371008.8
+
+// GeoPoint is a position in decimal degrees.
+type GeoPoint struct {
+    Latitude  float64
+    Longitude float64
+}
+
+// BoundingBox is the area between two latitudes and two longitudes. A box
+// whose West is greater than its East crosses the antimeridian.
+type BoundingBox struct {
+    West  float64
+    South float64
+    East  float64
+    North float64
+}
+
+// GeoFilter restricts a search to located images inside Box and within
+// Radius metres of Center. Either may be nil.
+type GeoFilter struct {
+    Box    *BoundingBox
+    Center *GeoPoint
+    Radius float64
+}
+
+// IsZero reports whether the filter has no bounds.
+func (f GeoFilter) IsZero() bool {
+    return f.Box == nil && f.Center == nil
+}
+
+// Contains reports whether a position passes the filter. Images without a
+// position only pass an empty filter.
+func (f GeoFilter) Contains(latitude, longitude *float64) bool {
+    if f.IsZero() {
+        return true
+    }
+    if latitude == nil || longitude == nil {
+        return false
+    }
+    if box := f.Box; box != nil {
+        if *latitude < box.South || *latitude > box.North {
+            return false
+        }
+        if box.West <= box.East && (*longitude < box.West || *longitude > box.East) {
+            return false
+        }
+        if box.West > box.East && *longitude < box.West && *longitude > box.East {
+            return false
+        }
+    }
+    if f.Center != nil && Distance(*f.Center, GeoPoint{*latitude, *longitude}) > f.Radius {
+        return false
+    }
+    return true
+}
+
+// Distance returns the great-circle distance between two points in metres.
+func Distance(a, b GeoPoint) float64 {
+    lat1, lat2 := a.Latitude*math.Pi/180, b.Latitude*math.Pi/180
+    dLat := lat2 - lat1
+    dLon := (b.Longitude - a.Longitude) * math.Pi / 180
+    h := math.Pow(math.Sin(dLat/2), 2) + math.Cos(lat1)*math.Cos(lat2)*math.Pow(math.Sin(dLon/2), 2)
+    return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// geoArgs returns the box edges and the center and radius of the filter as
+// query arguments, nil where the filter has no bound.
+func (f GeoFilter) geoArgs() []interface{} {
+    args := make([]interface{}, 7)
+    if box := f.Box; box != nil {
+        args[0], args[1], args[2], args[3] = box.West, box.South, box.East, box.North
+    }
+    if f.Center != nil {
+        args[4], args[5], args[6] = f.Center.Latitude, f.Center.Longitude, f.Radius
+    }
+    return args
+}
+
+// geoCondition is the SQL for a GeoFilter whose geoArgs start at
+// parameter $n.
+func geoCondition(n int) string {
+    return fmt.Sprintf(`($%[1]d::float8 IS NULL OR (latitude BETWEEN $%[2]d AND $%[4]d AND
+                CASE WHEN $%[1]d <= $%[3]d
+                    THEN longitude BETWEEN $%[1]d AND $%[3]d
+                    ELSE longitude >= $%[1]d OR longitude <= $%[3]d END))
+          AND ($%[5]d::float8 IS NULL OR (latitude IS NOT NULL AND
+                2 * %[8].1f * asin(LEAST(1, sqrt(power(sin(radians(latitude - $%[5]d) / 2), 2) +
+                    cos(radians($%[5]d)) * cos(radians(latitude)) * power(sin(radians(longitude - $%[6]d) / 2), 2))))
+                <= $%[7]d))`, n, n+1, n+2, n+3, n+4, n+5, n+6, earthRadius)
+}
+
+// GetImageLocations returns up to limit located images, newest first,
+// restricted to box when it is not nil.
+func GetImageLocations(box *BoundingBox, limit int) ([]Image, error) {
+    args := append(GeoFilter{Box: box}.geoArgs(), limit)
+    rows, err := DB.Query(`
+        SELECT `+imageColumns+`
+        FROM images
+        WHERE latitude IS NOT NULL AND longitude IS NOT NULL
+          AND `+geoCondition(1)+`
+        ORDER BY id DESC
+        LIMIT $8
+    `, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    return scanImages(rows)
+}
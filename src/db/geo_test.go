@@ -0,0 +1,67 @@
+package db
+
+import (
+    "math"
+    "testing"
+)
+
+func TestDistance(t *testing.T) {
+    berlin := GeoPoint{Latitude: 52.5200, Longitude: 13.4050}
+    paris := GeoPoint{Latitude: 48.8566, Longitude: 2.3522}
+    for _, test := range []struct {
+        name string
+        a, b GeoPoint
+        want float64
+    }{
+        {"same point", berlin, berlin, 0},
+        {"berlin to paris", berlin, paris, 877_500},
+        {"paris to berlin", paris, berlin, 877_500},
+        {"one degree of latitude", GeoPoint{0, 0}, GeoPoint{1, 0}, 111_195},
+        {"across the antimeridian", GeoPoint{0, 179.5}, GeoPoint{0, -179.5}, 111_195},
+        {"pole to pole", GeoPoint{90, 0}, GeoPoint{-90, 0}, math.Pi * earthRadius},
+    } {
+        if got := Distance(test.a, test.b); math.Abs(got-test.want) > test.want*0.001+1 {
+            t.Errorf("%s: Distance = %.0f, want %.0f", test.name, got, test.want)
+        }
+    }
+}
+
+func TestGeoFilterContains(t *testing.T) {
+    box := &BoundingBox{West: 13, South: 52, East: 14, North: 53}
+    antimeridian := &BoundingBox{West: 170, South: -20, East: -170, North: 10}
+    berlin := &GeoPoint{Latitude: 52.52, Longitude: 13.405}
+
+    for _, test := range []struct {
+        name                string
+        filter              GeoFilter
+        latitude, longitude *float64
+        want                bool
+    }{
+        {"empty filter without position", GeoFilter{}, nil, nil, true},
+        {"box without position", GeoFilter{Box: box}, nil, nil, false},
+        {"inside box", GeoFilter{Box: box}, ptr(52.5), ptr(13.4), true},
+        {"on box edge", GeoFilter{Box: box}, ptr(52.0), ptr(14.0), true},
+        {"south of box", GeoFilter{Box: box}, ptr(51.9), ptr(13.4), false},
+        {"east of box", GeoFilter{Box: box}, ptr(52.5), ptr(14.1), false},
+        {"antimeridian box west side", GeoFilter{Box: antimeridian}, ptr(0.0), ptr(175.0), true},
+        {"antimeridian box east side", GeoFilter{Box: antimeridian}, ptr(0.0), ptr(-175.0), true},
+        {"antimeridian box on the meridian", GeoFilter{Box: antimeridian}, ptr(0.0), ptr(180.0), true},
+        {"antimeridian box outside", GeoFilter{Box: antimeridian}, ptr(0.0), ptr(0.0), false},
+        {"antimeridian box just outside", GeoFilter{Box: antimeridian}, ptr(0.0), ptr(-169.0), false},
+        {"antimeridian box too far north", GeoFilter{Box: antimeridian}, ptr(11.0), ptr(175.0), false},
+        {"within radius", GeoFilter{Center: berlin, Radius: 5000}, ptr(52.5), ptr(13.4), true},
+        {"outside radius", GeoFilter{Center: berlin, Radius: 5000}, ptr(52.6), ptr(13.6), false},
+        {"radius without position", GeoFilter{Center: berlin, Radius: 5000}, nil, nil, false},
+        {"in box and radius", GeoFilter{Box: box, Center: berlin, Radius: 5000}, ptr(52.5), ptr(13.4), true},
+        {"in box outside radius", GeoFilter{Box: box, Center: berlin, Radius: 5000}, ptr(52.9), ptr(13.9), false},
+        {"in radius outside box", GeoFilter{Box: box, Center: berlin, Radius: 200000}, ptr(51.5), ptr(13.4), false},
+    } {
+        if got := test.filter.Contains(test.latitude, test.longitude); got != test.want {
+            t.Errorf("%s: Contains = %v, want %v", test.name, got, test.want)
+        }
+    }
+}
+
+func ptr[T any](v T) *T {
+    return &v
+}
@@ -0,0 +1,45 @@
+package db
+
+import (
+    "fmt"
+    "strings"
+)
+
+// Languages lists the description languages imagerr can stem. Each name is
+// both a Postgres text search configuration and an Elasticsearch stemmer
+// and stop word list, and the search_vector column maps each to its
+// configuration, so adding one needs a migration.
+var Languages = []string{
+    "danish", "dutch", "english", "finnish", "french", "german", "hungarian", "italian",
+    "norwegian", "portuguese", "romanian", "russian", "spanish", "swedish", "turkish",
+}
+
+// DefaultLanguage is used for images stored without a language.
+const DefaultLanguage = "english"
+
+// StemmableLanguage reports whether language is one of Languages. Searches
+// only stem the subset enabled by SEARCH_LANGUAGES; see search.ValidLanguage.
+func StemmableLanguage(language string) bool {
+    for _, l := range Languages {
+        if l == language {
+            return true
+        }
+    }
+    return false
+}
+
+// textSearchQuery returns a tsquery matching the text parameter when stemmed
+// in any of the languages, so a search finds descriptions in each of them.
+func textSearchQuery(param string, languages []string) string {
+    var queries []string
+    for _, language := range languages {
+        // Only known languages are interpolated into the query
+        if StemmableLanguage(language) {
+            queries = append(queries, fmt.Sprintf("websearch_to_tsquery('%s', %s)", language, param))
+        }
+    }
+    if len(queries) == 0 {
+        queries = []string{fmt.Sprintf("websearch_to_tsquery('%s', %s)", DefaultLanguage, param)}
+    }
+    return "(" + strings.Join(queries, " || ") + ")"
+}
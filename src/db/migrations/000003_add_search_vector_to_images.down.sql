@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_images_search_vector;
+ALTER TABLE images DROP COLUMN search_vector;
\ No newline at end of file
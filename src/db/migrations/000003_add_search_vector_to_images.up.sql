@@ -0,0 +1,3 @@
+ALTER TABLE images ADD COLUMN search_vector tsvector
+    GENERATED ALWAYS AS (to_tsvector('english', coalesce(description, ''))) STORED;
+CREATE INDEX idx_images_search_vector ON images USING GIN (search_vector);
\ No newline at end of file
@@ -0,0 +1,2 @@
+-- Normalising tags is not reversible
+SELECT 1;
\ No newline at end of file
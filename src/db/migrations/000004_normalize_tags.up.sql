@@ -0,0 +1,4 @@
+-- Existing tags are normalised by db.normalizeStoredTags before this version
+-- is applied, so they follow NormalizeTags exactly. Reindex afterwards to
+-- update the tags in the search index.
+SELECT 1;
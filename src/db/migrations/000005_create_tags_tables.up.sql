@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS tags (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS image_tags (
+    image_id INTEGER NOT NULL REFERENCES images (id) ON DELETE CASCADE,
+    tag_id INTEGER NOT NULL REFERENCES tags (id) ON DELETE CASCADE,
+    PRIMARY KEY (image_id, tag_id)
+);
+
+CREATE INDEX idx_image_tags_tag_id ON image_tags (tag_id);
+
+INSERT INTO tags (name)
+SELECT DISTINCT tag FROM images CROSS JOIN LATERAL unnest(images.tags) AS tag
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO image_tags (image_id, tag_id)
+SELECT images.id, tags.id
+FROM images
+CROSS JOIN LATERAL unnest(images.tags) AS tag
+JOIN tags ON tags.name = tag
+ON CONFLICT DO NOTHING;
\ No newline at end of file
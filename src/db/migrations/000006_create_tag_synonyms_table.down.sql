@@ -0,0 +1 @@
+DROP TABLE IF EXISTS tag_synonyms;
\ No newline at end of file
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS tag_synonyms (
+    alias TEXT PRIMARY KEY,
+    tag TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_tag_synonyms_tag ON tag_synonyms (tag);
\ No newline at end of file
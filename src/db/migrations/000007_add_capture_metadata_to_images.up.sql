@@ -0,0 +1,3 @@
+ALTER TABLE images ADD COLUMN taken_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE images ADD COLUMN camera_make TEXT NOT NULL DEFAULT '';
+ALTER TABLE images ADD COLUMN camera_model TEXT NOT NULL DEFAULT '';
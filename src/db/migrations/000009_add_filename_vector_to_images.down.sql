@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_images_filename_vector;
+ALTER TABLE images DROP COLUMN filename_vector;
@@ -0,0 +1,3 @@
+ALTER TABLE images ADD COLUMN filename_vector tsvector
+    GENERATED ALWAYS AS (to_tsvector('simple', regexp_replace(coalesce(original_filename, ''), '[^[:alnum:]]+', ' ', 'g'))) STORED;
+CREATE INDEX idx_images_filename_vector ON images USING GIN (filename_vector);
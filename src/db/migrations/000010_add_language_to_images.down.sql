@@ -0,0 +1,5 @@
+ALTER TABLE images DROP COLUMN search_vector;
+ALTER TABLE images ADD COLUMN search_vector tsvector
+    GENERATED ALWAYS AS (to_tsvector('english', coalesce(description, ''))) STORED;
+CREATE INDEX idx_images_search_vector ON images USING GIN (search_vector);
+ALTER TABLE images DROP COLUMN language;
@@ -0,0 +1,26 @@
+ALTER TABLE images ADD COLUMN language TEXT NOT NULL DEFAULT '';
+
+-- Stem each description in its own language. Images stored without one,
+-- including every image uploaded before this migration, are English.
+ALTER TABLE images DROP COLUMN search_vector;
+ALTER TABLE images ADD COLUMN search_vector tsvector
+    GENERATED ALWAYS AS (to_tsvector(
+        CASE language
+            WHEN 'danish' THEN 'danish'::regconfig
+            WHEN 'dutch' THEN 'dutch'::regconfig
+            WHEN 'finnish' THEN 'finnish'::regconfig
+            WHEN 'french' THEN 'french'::regconfig
+            WHEN 'german' THEN 'german'::regconfig
+            WHEN 'hungarian' THEN 'hungarian'::regconfig
+            WHEN 'italian' THEN 'italian'::regconfig
+            WHEN 'norwegian' THEN 'norwegian'::regconfig
+            WHEN 'portuguese' THEN 'portuguese'::regconfig
+            WHEN 'romanian' THEN 'romanian'::regconfig
+            WHEN 'russian' THEN 'russian'::regconfig
+            WHEN 'spanish' THEN 'spanish'::regconfig
+            WHEN 'swedish' THEN 'swedish'::regconfig
+            WHEN 'turkish' THEN 'turkish'::regconfig
+            ELSE 'english'::regconfig
+        END,
+        coalesce(description, ''))) STORED;
+CREATE INDEX idx_images_search_vector ON images USING GIN (search_vector);
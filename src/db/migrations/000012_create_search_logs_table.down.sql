@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS search_clicks;
+DROP TABLE IF EXISTS search_logs;
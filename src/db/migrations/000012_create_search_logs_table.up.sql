@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS search_logs (
+    id SERIAL PRIMARY KEY,
+    query TEXT NOT NULL DEFAULT '',
+    tags TEXT[] NOT NULL DEFAULT '{}',
+    result_count INTEGER NOT NULL,
+    latency_ms REAL NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_search_logs_created_at ON search_logs (created_at);
+
+CREATE TABLE IF NOT EXISTS search_clicks (
+    id SERIAL PRIMARY KEY,
+    search_id INTEGER NOT NULL REFERENCES search_logs (id) ON DELETE CASCADE,
+    image_id INTEGER NOT NULL REFERENCES images (id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_search_clicks_search_id ON search_clicks (search_id);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_images_location;
+ALTER TABLE images DROP COLUMN longitude;
+ALTER TABLE images DROP COLUMN latitude;
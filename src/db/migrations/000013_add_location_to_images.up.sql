@@ -0,0 +1,4 @@
+ALTER TABLE images ADD COLUMN latitude DOUBLE PRECISION;
+ALTER TABLE images ADD COLUMN longitude DOUBLE PRECISION;
+
+CREATE INDEX idx_images_location ON images (latitude, longitude) WHERE latitude IS NOT NULL;
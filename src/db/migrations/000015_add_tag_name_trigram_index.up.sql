@@ -0,0 +1,4 @@
+-- Tag suggestions without Elasticsearch find infix and misspelt matches
+-- through trigrams
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE INDEX IF NOT EXISTS idx_tags_name_trgm ON tags USING GIN (name gin_trgm_ops);
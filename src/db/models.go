@@ -5,13 +5,21 @@ import (
 )
 
 type Image struct {
-    ID               int64     `json:"id"`
-    OriginalFilename string    `json:"original_filename"`
-    UUIDFilename     string    `json:"uuid_filename"`
-    Description      string    `json:"description"`
-    URL              string    `json:"url"`
-    Tags             []string  `json:"tags" gorm:"type:text[]"`
-    StoragePath      string    `json:"storage_path"`
-    CreatedAt        time.Time `json:"created_at"`
-    ViewCount        int       `json:"view_count" gorm:"default:0"`
+    ID               int64      `json:"id"`
+    OriginalFilename string     `json:"original_filename"`
+    UUIDFilename     string     `json:"uuid_filename"`
+    Description      string     `json:"description"`
+    URL              string     `json:"url"`
+    Tags             []string   `json:"tags" gorm:"type:text[]"`
+    StoragePath      string     `json:"storage_path"`
+    CreatedAt        time.Time  `json:"created_at"`
+    ViewCount        int        `json:"view_count" gorm:"default:0"`
+    TakenAt          *time.Time `json:"taken_at,omitempty"`
+    CameraMake       string     `json:"camera_make,omitempty"`
+    CameraModel      string     `json:"camera_model,omitempty"`
+    Embedding        []float32  `json:"-"`
+    Language         string     `json:"language,omitempty"`
+    Latitude         *float64   `json:"latitude,omitempty"`
+    Longitude        *float64   `json:"longitude,omitempty"`
+    Albums           []int64    `json:"albums,omitempty"`
 }
\ No newline at end of file
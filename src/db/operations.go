@@ -2,17 +2,39 @@ package db
 
 import (
     "database/sql"
+    "errors"
     "log"
+    "strings"
+    "time"
     "github.com/lib/pq"
 )
 
-func CreateImage(originalFilename, uuidFilename, description, storagePath string, tags []string) (*Image, error) {
+// imageColumns lists the images columns in the order scanImage reads them,
+// followed by the IDs of the albums each image is in. The album IDs come from
+// a subquery on images.id, so queries using it must read FROM images under
+// that name, without an alias, and may only join tables that share none of
+// its column names, as GetAlbumImages joins album_images.
+const imageColumns = `id, original_filename, uuid_filename, description, tags, storage_path, created_at,
+        view_count, taken_at, camera_make, camera_model, embedding, language, latitude, longitude,
+        ARRAY(SELECT album_id FROM album_images WHERE album_images.image_id = images.id ORDER BY album_id)`
+
+type rowScanner interface {
+    Scan(dest ...interface{}) error
+}
+
+// isUniqueViolation reports whether err is a Postgres unique constraint
+// violation.
+func isUniqueViolation(err error) bool {
+    var pqErr *pq.Error
+    return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+func scanImage(row rowScanner) (*Image, error) {
     var img Image
-    err := DB.QueryRow(`
-        INSERT INTO images (original_filename, uuid_filename, description, tags, storage_path)
-        VALUES ($1, $2, $3, $4::text[], $5)
-        RETURNING id, original_filename, uuid_filename, description, tags, storage_path, created_at
-    `, originalFilename, uuidFilename, description, pq.Array(tags), storagePath).Scan(
+    var viewCount sql.NullInt64
+    var takenAt sql.NullTime
+    var latitude, longitude sql.NullFloat64
+    err := row.Scan(
         &img.ID,
         &img.OriginalFilename,
         &img.UUIDFilename,
@@ -20,29 +42,81 @@ func CreateImage(originalFilename, uuidFilename, description, storagePath string
         pq.Array(&img.Tags),
         &img.StoragePath,
         &img.CreatedAt,
+        &viewCount,
+        &takenAt,
+        &img.CameraMake,
+        &img.CameraModel,
+        (*pq.Float32Array)(&img.Embedding),
+        &img.Language,
+        &latitude,
+        &longitude,
+        pq.Array(&img.Albums),
     )
     if err != nil {
-        log.Printf("Error creating image record: %v\nParams: filename=%s, uuid=%s, path=%s, tags=%v", 
-            err, originalFilename, uuidFilename, storagePath, tags)
         return nil, err
     }
+    img.ViewCount = int(viewCount.Int64)
+    if takenAt.Valid {
+        img.TakenAt = &takenAt.Time
+    }
+    if latitude.Valid && longitude.Valid {
+        img.Latitude, img.Longitude = &latitude.Float64, &longitude.Float64
+    }
     return &img, nil
 }
 
+func scanImages(rows *sql.Rows) ([]Image, error) {
+    var images []Image
+    for rows.Next() {
+        img, err := scanImage(rows)
+        if err != nil {
+            return nil, err
+        }
+        images = append(images, *img)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+    return images, nil
+}
+
+// CreateImage inserts image along with its tags and returns the stored row.
+func CreateImage(image *Image) (*Image, error) {
+    tags := NormalizeTags(image.Tags)
+
+    tx, err := DB.Begin()
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback()
+
+    img, err := scanImage(tx.QueryRow(`
+        INSERT INTO images (original_filename, uuid_filename, description, tags, storage_path,
+            taken_at, camera_make, camera_model, embedding, language, latitude, longitude)
+        VALUES ($1, $2, $3, $4::text[], $5, $6, $7, $8, $9, $10, $11, $12)
+        RETURNING ` + imageColumns,
+        image.OriginalFilename, image.UUIDFilename, image.Description, pq.Array(tags), image.StoragePath,
+        image.TakenAt, image.CameraMake, image.CameraModel, embeddingValue(image.Embedding), image.Language,
+        image.Latitude, image.Longitude))
+    if err == nil {
+        err = setImageTags(tx, img.ID, tags)
+    }
+    if err == nil {
+        err = tx.Commit()
+    }
+    if err != nil {
+        log.Printf("Error creating image record: %v\nParams: filename=%s, uuid=%s, path=%s, tags=%v", 
+            err, image.OriginalFilename, image.UUIDFilename, image.StoragePath, tags)
+        return nil, err
+    }
+    return img, nil
+}
+
 func GetImageByID(id int64) (*Image, error) {
-    var img Image
-    err := DB.QueryRow(`
-        SELECT id, original_filename, uuid_filename, description, tags, storage_path, created_at
+    img, err := scanImage(DB.QueryRow(`
+        SELECT ` + imageColumns + `
         FROM images WHERE id = $1
-    `, id).Scan(
-        &img.ID,
-        &img.OriginalFilename,
-        &img.UUIDFilename,
-        &img.Description,
-        pq.Array(&img.Tags),
-        &img.StoragePath,
-        &img.CreatedAt,
-    )
+    `, id))
     if err == sql.ErrNoRows {
         log.Printf("No image found with ID: %d", id)
         return nil, nil
@@ -51,12 +125,12 @@ func GetImageByID(id int64) (*Image, error) {
         log.Printf("Error retrieving image with ID %d: %v", id, err)
         return nil, err
     }
-    return &img, nil
+    return img, nil
 }
 
 func GetRecentImages(limit int) ([]Image, error) {
     rows, err := DB.Query(`
-        SELECT id, original_filename, uuid_filename, description, tags, storage_path, created_at
+        SELECT ` + imageColumns + `
         FROM images
         ORDER BY created_at DESC
         LIMIT $1
@@ -66,73 +140,218 @@ func GetRecentImages(limit int) ([]Image, error) {
     }
     defer rows.Close()
 
-    var images []Image
+    return scanImages(rows)
+}
+
+// SearchRanking weights text matches by field and blends popularity and
+// recency into search relevance. Zero popularity and recency weights rank
+// on relevance alone.
+type SearchRanking struct {
+    Fields FieldBoosts
+    // PopularityWeight scales log(1 + view_count)
+    PopularityWeight float64
+    // RecencyWeight scales a decay that halves every RecencyScale of age
+    RecencyWeight float64
+    RecencyScale  time.Duration
+}
+
+// FieldBoosts weights text matches on each searched field.
+type FieldBoosts struct {
+    Description float64
+    Filename    float64
+    Tags        float64
+}
+
+// DateFilter restricts a search to images uploaded, or taken when Taken is
+// set, at or after From and before To. Either bound may be nil.
+type DateFilter struct {
+    Taken bool
+    From  *time.Time
+    To    *time.Time
+}
+
+// IsZero reports whether the filter has no bounds.
+func (f DateFilter) IsZero() bool {
+    return f.From == nil && f.To == nil
+}
+
+// SearchQuery describes a search of the images table.
+type SearchQuery struct {
+    Text  string
+    Tags  []string
+    Dates DateFilter
+    Geo   GeoFilter
+    Album int64
+    Limit int
+    // Languages the text is stemmed in to match descriptions
+    Languages []string
+    Ranking   SearchRanking
+}
+
+// SearchImages mirrors the Elasticsearch query: an image matches when it
+// carries any of the tags, or the text query matches its description,
+// original filename or one of its tags, with each kind of match weighted by
+// its field boost. Every image passing the date, location and album filters
+// matches, newest first, when there is neither. Relevance is then
+// multiplied by one plus the popularity and recency boosts.
+func SearchImages(search SearchQuery) ([]Image, error) {
+    ranking := search.Ranking
+    scale := ranking.RecencyScale.Seconds()
+    if scale <= 0 {
+        scale = 1
+    }
+
+    // The whole query and each word of it may be a tag name
+    queryTags := NormalizeTags(append([]string{search.Text}, strings.Fields(search.Text)...))
+    tsquery := textSearchQuery("$1", search.Languages)
+
+    rows, err := DB.Query(`
+        SELECT ` + imageColumns + `
+        FROM images
+        WHERE ((cardinality($2::text[]) > 0 AND tags && $2::text[])
+            OR ($1 <> '' AND search_vector @@ ` + tsquery + `)
+            OR ($1 <> '' AND filename_vector @@ plainto_tsquery('simple', regexp_replace($1, '[^[:alnum:]]+', ' ', 'g')))
+            OR (cardinality($10::text[]) > 0 AND tags && $10::text[])
+            OR ($1 = '' AND cardinality($2::text[]) = 0))
+          AND ($7::timestamptz IS NULL OR (CASE WHEN $9 THEN taken_at ELSE created_at END) >= $7)
+          AND ($8::timestamptz IS NULL OR (CASE WHEN $9 THEN taken_at ELSE created_at END) < $8)
+          AND ` + geoCondition(14) + `
+          AND ($21::bigint = 0 OR EXISTS (SELECT 1 FROM album_images WHERE album_id = $21 AND image_id = images.id))
+        ORDER BY
+            ((CASE WHEN tags && $2::text[] THEN 2 ELSE 0 END) +
+             (CASE WHEN $1 <> '' THEN $11 * ts_rank(search_vector, ` + tsquery + `) ELSE 0 END) +
+             (CASE WHEN $1 <> '' THEN $12 * ts_rank(filename_vector, plainto_tsquery('simple', regexp_replace($1, '[^[:alnum:]]+', ' ', 'g'))) ELSE 0 END) +
+             (CASE WHEN tags && $10::text[] THEN $13 ELSE 0 END)) *
+            (1 + $4 * ln(1 + GREATEST(COALESCE(view_count, 0), 0)) +
+             $5 * power(0.5, GREATEST(EXTRACT(EPOCH FROM now() - created_at), 0) / $6)) DESC,
+            id DESC
+        LIMIT $3
+    `, append([]interface{}{search.Text, pq.Array(search.Tags), search.Limit, ranking.PopularityWeight,
+        ranking.RecencyWeight, scale, search.Dates.From, search.Dates.To, search.Dates.Taken, pq.Array(queryTags),
+        ranking.Fields.Description, ranking.Fields.Filename, ranking.Fields.Tags},
+        append(search.Geo.geoArgs(), search.Album)...)...)
+    if err != nil {
+        log.Printf("Search query error: %v", err)
+        return nil, err
+    }
+    defer rows.Close()
+
+    return scanImages(rows)
+}
+
+// RelatedImages returns other images sharing tags with an image, those
+// sharing the most first.
+func RelatedImages(id int64, tags []string, limit int) ([]Image, error) {
+    rows, err := DB.Query(`
+        SELECT ` + imageColumns + `
+        FROM images
+        WHERE id <> $1 AND tags && $2::text[]
+        ORDER BY cardinality(ARRAY(SELECT unnest(tags) INTERSECT SELECT unnest($2::text[]))) DESC, id DESC
+        LIMIT $3
+    `, id, pq.Array(tags), limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    return scanImages(rows)
+}
+
+// embeddingValue stores a missing embedding as NULL rather than an empty
+// array.
+func embeddingValue(embedding []float32) interface{} {
+    if embedding == nil {
+        return nil
+    }
+    return pq.Float32Array(embedding)
+}
+
+// SetImageEmbedding stores the feature vector computed for an image.
+func SetImageEmbedding(id int64, embedding []float32) error {
+    _, err := DB.Exec(`UPDATE images SET embedding = $2 WHERE id = $1`, id, embeddingValue(embedding))
+    return err
+}
+
+// GetImageEmbeddings returns the feature vectors of every image that has
+// one, for searching by similarity without an index.
+func GetImageEmbeddings() (map[int64][]float32, error) {
+    rows, err := DB.Query(`SELECT id, embedding FROM images WHERE embedding IS NOT NULL`)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    embeddings := make(map[int64][]float32)
     for rows.Next() {
-        var img Image
-        err := rows.Scan(
-            &img.ID,
-            &img.OriginalFilename,
-            &img.UUIDFilename,
-            &img.Description,
-            pq.Array(&img.Tags),
-            &img.StoragePath,
-            &img.CreatedAt,
-        )
-        if err != nil {
+        var id int64
+        var embedding pq.Float32Array
+        if err := rows.Scan(&id, &embedding); err != nil {
             return nil, err
         }
-        images = append(images, img)
+        embeddings[id] = embedding
     }
     if err = rows.Err(); err != nil {
         return nil, err
     }
-    return images, nil
+    return embeddings, nil
+}
+
+// IncrementViewCount records a view of the image and returns it with the
+// new count, or nil if there is no such image.
+func IncrementViewCount(id int64) (*Image, error) {
+    img, err := scanImage(DB.QueryRow(`
+        UPDATE images SET view_count = COALESCE(view_count, 0) + 1
+        WHERE id = $1
+        RETURNING ` + imageColumns, id))
+    if err == sql.ErrNoRows {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    return img, nil
 }
 
-func SearchImages(query string) ([]Image, error) {
-    // Add logging to debug the search query
-    log.Printf("Searching for: %s", query)
-    
+// SearchTerms counts the words used in image descriptions and tags, for
+// suggesting corrections to misspelled searches.
+func SearchTerms() (map[string]int, error) {
     rows, err := DB.Query(`
-        SELECT id, original_filename, uuid_filename, description, tags, storage_path, created_at
-        FROM images
-        WHERE description ILIKE $1 OR EXISTS (
-            SELECT 1 FROM unnest(tags) tag WHERE tag ILIKE $1
-        )
-    `, "%"+query+"%")
-    
+        SELECT word, COUNT(*)
+        FROM (
+            SELECT regexp_split_to_table(lower(description), '[^[:alnum:]]+') AS word FROM images
+            UNION ALL
+            SELECT regexp_split_to_table(tag, '[^[:alnum:]]+') FROM images, unnest(tags) AS tag
+        ) words
+        WHERE word <> ''
+        GROUP BY word
+    `)
     if err != nil {
-        log.Printf("Search query error: %v", err)
         return nil, err
     }
     defer rows.Close()
 
-    var images []Image
+    terms := make(map[string]int)
     for rows.Next() {
-        var img Image
-        err := rows.Scan(
-            &img.ID,
-            &img.OriginalFilename,
-            &img.UUIDFilename,
-            &img.Description,
-            pq.Array(&img.Tags),
-            &img.StoragePath,
-            &img.CreatedAt,
-        )
-        if err != nil {
+        var word string
+        var count int
+        if err := rows.Scan(&word, &count); err != nil {
             return nil, err
         }
-        images = append(images, img)
+        terms[word] = count
     }
     if err = rows.Err(); err != nil {
         return nil, err
     }
-    return images, nil
+    return terms, nil
+}
+
+func escapeLike(s string) string {
+    return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
 }
 
 func GetAllImages() ([]Image, error) {
     rows, err := DB.Query(`
-        SELECT id, original_filename, uuid_filename, description, tags, storage_path, created_at
+        SELECT ` + imageColumns + `
         FROM images
         ORDER BY id ASC
     `)
@@ -141,25 +360,47 @@ func GetAllImages() ([]Image, error) {
     }
     defer rows.Close()
 
-    var images []Image
+    return scanImages(rows)
+}
+
+func GetAllStoragePaths() (map[string]bool, error) {
+    rows, err := DB.Query(`SELECT storage_path FROM images`)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    paths := make(map[string]bool)
     for rows.Next() {
-        var img Image
-        err := rows.Scan(
-            &img.ID,
-            &img.OriginalFilename,
-            &img.UUIDFilename,
-            &img.Description,
-            pq.Array(&img.Tags),
-            &img.StoragePath,
-            &img.CreatedAt,
-        )
-        if err != nil {
+        var path string
+        if err := rows.Scan(&path); err != nil {
             return nil, err
         }
-        images = append(images, img)
+        paths[path] = true
     }
     if err = rows.Err(); err != nil {
         return nil, err
     }
-    return images, nil
-}
\ No newline at end of file
+    return paths, nil
+}
+
+func CountImages() (int64, error) {
+    var count int64
+    err := DB.QueryRow(`SELECT COUNT(*) FROM images`).Scan(&count)
+    return count, err
+}
+
+func GetImagesByIDs(ids []int64) ([]Image, error) {
+    rows, err := DB.Query(`
+        SELECT ` + imageColumns + `
+        FROM images
+        WHERE id = ANY($1::bigint[])
+        ORDER BY id ASC
+    `, pq.Array(ids))
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    return scanImages(rows)
+}
@@ -5,7 +5,8 @@ import (
     "fmt"
     "log"
     "os"
-    _ "github.com/lib/pq"
+    "slices"
+    "github.com/lib/pq"
     "github.com/golang-migrate/migrate/v4"
     "github.com/golang-migrate/migrate/v4/database/postgres"
     _ "github.com/golang-migrate/migrate/v4/source/file"
@@ -55,6 +56,22 @@ func runMigrations(db *sql.DB) error {
         return fmt.Errorf("could not create migrate instance: %v", err)
     }
 
+    // Tags written before they were normalised are cleaned up in Go just
+    // before the migration recording it, so they get exactly the rules
+    // NormalizeTags applies rather than an SQL approximation
+    version, _, err := m.Version()
+    if err != nil && err != migrate.ErrNilVersion {
+        return fmt.Errorf("could not read migration version: %v", err)
+    }
+    if version < normalizeTagsVersion {
+        if err := m.Migrate(normalizeTagsVersion - 1); err != nil && err != migrate.ErrNoChange {
+            return fmt.Errorf("could not run migrations: %v", err)
+        }
+        if err := normalizeStoredTags(db); err != nil {
+            return fmt.Errorf("could not normalise stored tags: %v", err)
+        }
+    }
+
     if err := m.Up(); err != nil && err != migrate.ErrNoChange {
         return fmt.Errorf("could not run migrations: %v", err)
     }
@@ -62,6 +79,55 @@ func runMigrations(db *sql.DB) error {
     return nil
 }
 
+// normalizeTagsVersion is the migration that records that stored tags have
+// been normalised.
+const normalizeTagsVersion = 4
+
+// normalizeStoredTags saves every image's tags through NormalizeTags. It is
+// safe to repeat, so an interrupted run is simply redone. The search index
+// still holds the old tags until it is rebuilt with /reindex.
+func normalizeStoredTags(db *sql.DB) error {
+    tx, err := db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    rows, err := tx.Query(`SELECT id, tags FROM images WHERE tags IS NOT NULL ORDER BY id`)
+    if err != nil {
+        return err
+    }
+    changed := make(map[int64][]string)
+    for rows.Next() {
+        var id int64
+        var tags []string
+        if err := rows.Scan(&id, pq.Array(&tags)); err != nil {
+            rows.Close()
+            return err
+        }
+        if normalized := NormalizeTags(tags); !slices.Equal(normalized, tags) {
+            changed[id] = normalized
+        }
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return err
+    }
+
+    for id, tags := range changed {
+        if _, err := tx.Exec(`UPDATE images SET tags = $2::text[] WHERE id = $1`, id, pq.Array(tags)); err != nil {
+            return err
+        }
+    }
+    if err := tx.Commit(); err != nil {
+        return err
+    }
+    if len(changed) > 0 {
+        log.Printf("Normalised the tags of %d images, reindex to update the search index", len(changed))
+    }
+    return nil
+}
+
 func CloseDB() {
     if DB != nil {
         DB.Close()
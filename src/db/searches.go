@@ -0,0 +1,171 @@
+package db
+
+import (
+    "database/sql"
+    "errors"
+    "time"
+    "github.com/lib/pq"
+)
+
+var (
+    ErrSavedSearchNotFound = errors.New("saved search not found")
+    ErrSavedSearchExists   = errors.New("a saved search with that name already exists")
+)
+
+// SavedSearch is a named search that can be run again later. Date bounds
+// are kept as they were entered, so a relative bound such as 7d always
+// means the last seven days. Notify adds matching uploads to the
+// notification feed and WebhookURL, when set, is posted each match.
+type SavedSearch struct {
+    ID         int64     `json:"id"`
+    Name       string    `json:"name"`
+    Query      string    `json:"q"`
+    Tags       []string  `json:"tags"`
+    Date       string    `json:"date,omitempty"`
+    From       string    `json:"from,omitempty"`
+    To         string    `json:"to,omitempty"`
+    Notify     bool      `json:"notify"`
+    WebhookURL string    `json:"webhook_url,omitempty"`
+    CreatedAt  time.Time `json:"created_at"`
+}
+
+// Notification records an upload that matched a saved search.
+type Notification struct {
+    ID              int64     `json:"id"`
+    SavedSearchID   int64     `json:"saved_search_id"`
+    SavedSearchName string    `json:"saved_search_name"`
+    ImageID         int64     `json:"image_id"`
+    CreatedAt       time.Time `json:"created_at"`
+}
+
+const savedSearchColumns = `id, name, query, tags, date_field, date_from, date_to, notify, webhook_url, created_at`
+
+func scanSavedSearch(row rowScanner) (*SavedSearch, error) {
+    var saved SavedSearch
+    err := row.Scan(
+        &saved.ID,
+        &saved.Name,
+        &saved.Query,
+        pq.Array(&saved.Tags),
+        &saved.Date,
+        &saved.From,
+        &saved.To,
+        &saved.Notify,
+        &saved.WebhookURL,
+        &saved.CreatedAt,
+    )
+    if err != nil {
+        return nil, err
+    }
+    if saved.Tags == nil {
+        saved.Tags = []string{}
+    }
+    return &saved, nil
+}
+
+func CreateSavedSearch(saved *SavedSearch) (*SavedSearch, error) {
+    tags := NormalizeTags(saved.Tags)
+    if tags == nil {
+        tags = []string{}
+    }
+
+    row := DB.QueryRow(`
+        INSERT INTO saved_searches (name, query, tags, date_field, date_from, date_to, notify, webhook_url)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+        RETURNING `+savedSearchColumns,
+        saved.Name, saved.Query, pq.Array(tags), saved.Date, saved.From, saved.To, saved.Notify, saved.WebhookURL,
+    )
+    created, err := scanSavedSearch(row)
+    if isUniqueViolation(err) {
+        return nil, ErrSavedSearchExists
+    }
+    return created, err
+}
+
+func GetSavedSearches() ([]SavedSearch, error) {
+    rows, err := DB.Query(`SELECT ` + savedSearchColumns + ` FROM saved_searches ORDER BY name`)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    searches := []SavedSearch{}
+    for rows.Next() {
+        saved, err := scanSavedSearch(rows)
+        if err != nil {
+            return nil, err
+        }
+        searches = append(searches, *saved)
+    }
+    if err = rows.Err(); err != nil {
+        return nil, err
+    }
+    return searches, nil
+}
+
+func GetSavedSearch(id int64) (*SavedSearch, error) {
+    saved, err := scanSavedSearch(DB.QueryRow(`SELECT `+savedSearchColumns+` FROM saved_searches WHERE id = $1`, id))
+    if err == sql.ErrNoRows {
+        return nil, ErrSavedSearchNotFound
+    }
+    return saved, err
+}
+
+func DeleteSavedSearch(id int64) error {
+    result, err := DB.Exec(`DELETE FROM saved_searches WHERE id = $1`, id)
+    if err != nil {
+        return err
+    }
+    if n, _ := result.RowsAffected(); n == 0 {
+        return ErrSavedSearchNotFound
+    }
+    return nil
+}
+
+// CreateNotification adds an image to the notification feed of a saved
+// search. It reports false if the image was already in the feed.
+func CreateNotification(savedSearchID, imageID int64) (bool, error) {
+    result, err := DB.Exec(`
+        INSERT INTO saved_search_notifications (saved_search_id, image_id)
+        VALUES ($1, $2)
+        ON CONFLICT (saved_search_id, image_id) DO NOTHING`,
+        savedSearchID, imageID,
+    )
+    if err != nil {
+        return false, err
+    }
+    n, _ := result.RowsAffected()
+    return n > 0, nil
+}
+
+// GetNotifications returns up to limit notifications newer than afterID,
+// newest first. Clients poll with the highest ID they have seen. A
+// savedSearchID of 0 includes every saved search.
+func GetNotifications(savedSearchID, afterID int64, limit int) ([]Notification, error) {
+    rows, err := DB.Query(`
+        SELECT n.id, n.saved_search_id, s.name, n.image_id, n.created_at
+        FROM saved_search_notifications n
+        JOIN saved_searches s ON s.id = n.saved_search_id
+        WHERE n.id > $1 AND ($2 = 0 OR n.saved_search_id = $2)
+        ORDER BY n.id DESC
+        LIMIT $3`,
+        afterID, savedSearchID, limit,
+    )
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    notifications := []Notification{}
+    for rows.Next() {
+        var n Notification
+        if err := rows.Scan(&n.ID, &n.SavedSearchID, &n.SavedSearchName, &n.ImageID, &n.CreatedAt); err != nil {
+            return nil, err
+        }
+        notifications = append(notifications, n)
+    }
+    if err = rows.Err(); err != nil {
+        return nil, err
+    }
+    return notifications, nil
+}
@@ -0,0 +1,589 @@
+package db
+
+import (
+    "database/sql"
+    "errors"
+    "fmt"
+    "strings"
+    "time"
+    "unicode"
+    "github.com/lib/pq"
+    "golang.org/x/text/unicode/norm"
+)
+
+const (
+    MaxTagLength    = 64
+    MaxTagsPerImage = 50
+)
+
+// tagPunctuation lists the non-alphanumeric characters a tag may contain.
+// Anything else is stripped by NormalizeTag.
+const tagPunctuation = "-_.'&"
+
+// Tags may be namespaced and hierarchical, as in "location:berlin/mitte":
+// the text before the first TagNamespaceSeparator names the namespace and
+// TagPathSeparator separates a parent from its children.
+const (
+    TagNamespaceSeparator = ":"
+    TagPathSeparator      = "/"
+)
+
+// NormalizeTag returns the canonical form of a tag: NFKC normalised, lower
+// case, limited to letters, digits, tagPunctuation and the namespace and
+// path separators, with runs of whitespace collapsed, empty path segments
+// removed and at most MaxTagLength characters. It returns an empty string
+// when nothing usable is left.
+func NormalizeTag(tag string) string {
+    tag = strings.ToLower(norm.NFKC.String(tag))
+
+    var b strings.Builder
+    for _, r := range tag {
+        switch {
+        case unicode.IsSpace(r):
+            b.WriteRune(' ')
+        case unicode.IsLetter(r), unicode.IsDigit(r), unicode.IsMark(r), strings.ContainsRune(tagPunctuation, r),
+            strings.ContainsRune(TagNamespaceSeparator+TagPathSeparator, r):
+            b.WriteRune(r)
+        }
+    }
+    tag = joinTag(SplitTag(b.String()))
+
+    if runes := []rune(tag); len(runes) > MaxTagLength {
+        tag = joinTag(SplitTag(string(runes[:MaxTagLength])))
+    }
+    return tag
+}
+
+// SplitTag separates a tag into its namespace, which may be empty, and its
+// path segments.
+func SplitTag(tag string) (string, []string) {
+    namespace, path := "", tag
+    if i := strings.Index(tag, TagNamespaceSeparator); i >= 0 {
+        namespace = strings.ReplaceAll(tag[:i], TagPathSeparator, "")
+        path = strings.ReplaceAll(tag[i+1:], TagNamespaceSeparator, "")
+    }
+    namespace = strings.Join(strings.Fields(namespace), " ")
+
+    var segments []string
+    for _, segment := range strings.Split(path, TagPathSeparator) {
+        if segment = strings.Join(strings.Fields(segment), " "); segment != "" {
+            segments = append(segments, segment)
+        }
+    }
+    return namespace, segments
+}
+
+func joinTag(namespace string, segments []string) string {
+    path := strings.Join(segments, TagPathSeparator)
+    switch {
+    case namespace == "":
+        return path
+    case path == "":
+        return namespace
+    default:
+        return namespace + TagNamespaceSeparator + path
+    }
+}
+
+// NormalizeTags normalises every tag, dropping empty results and duplicates
+// while keeping the original order, and caps the list at MaxTagsPerImage.
+func NormalizeTags(tags []string) []string {
+    seen := make(map[string]bool)
+    normalized := []string{}
+    for _, tag := range tags {
+        tag = NormalizeTag(tag)
+        if tag == "" || seen[tag] {
+            continue
+        }
+        seen[tag] = true
+        normalized = append(normalized, tag)
+        if len(normalized) == MaxTagsPerImage {
+            break
+        }
+    }
+    return normalized
+}
+
+// ParseTags splits a comma separated tag list and normalises it.
+func ParseTags(tags string) []string {
+    return NormalizeTags(strings.Split(tags, ","))
+}
+
+// GetNestedTags returns the names of every tag with a namespace or a
+// parent, the only tags TagDescendants can find below another.
+func GetNestedTags() ([]string, error) {
+    rows, err := DB.Query(`SELECT name FROM tags WHERE name LIKE ANY($1::text[]) ORDER BY name`,
+        pq.Array([]string{"%" + TagNamespaceSeparator + "%", "%" + TagPathSeparator + "%"}))
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    names := []string{}
+    for rows.Next() {
+        var name string
+        if err := rows.Scan(&name); err != nil {
+            return nil, err
+        }
+        names = append(names, name)
+    }
+    if err = rows.Err(); err != nil {
+        return nil, err
+    }
+    return names, nil
+}
+
+// TagDescendants returns the given tags followed by every tag in nested
+// that sits below one of them, so a filter on a parent tag or namespace
+// also matches its children.
+func TagDescendants(tags, nested []string) []string {
+    descendants := append([]string{}, tags...)
+    for _, name := range nested {
+        for _, tag := range tags {
+            if strings.HasPrefix(name, tag+TagPathSeparator) || strings.HasPrefix(name, tag+TagNamespaceSeparator) {
+                descendants = append(descendants, name)
+                break
+            }
+        }
+    }
+    return descendants
+}
+
+var (
+    ErrTagNotFound = errors.New("tag not found")
+    ErrTagExists   = errors.New("tag already exists")
+)
+
+// setImageTags points the image_tags rows for an image at exactly the given
+// tags, creating any tags that do not exist yet. images.tags keeps the
+// ordered copy that is read everywhere else.
+func setImageTags(tx *sql.Tx, imageID int64, tags []string) error {
+    if _, err := tx.Exec(`
+        INSERT INTO tags (name) SELECT unnest($1::text[])
+        ON CONFLICT (name) DO NOTHING
+    `, pq.Array(tags)); err != nil {
+        return err
+    }
+
+    if _, err := tx.Exec(`DELETE FROM image_tags WHERE image_id = $1`, imageID); err != nil {
+        return err
+    }
+
+    _, err := tx.Exec(`
+        INSERT INTO image_tags (image_id, tag_id)
+        SELECT $1, id FROM tags WHERE name = ANY($2::text[])
+    `, imageID, pq.Array(tags))
+    return err
+}
+
+// rewriteImageTags applies rewrite to the tags of every image carrying any
+// of the given tags and returns the IDs of the images it changed.
+func rewriteImageTags(tx *sql.Tx, tags []string, rewrite func([]string) []string) ([]int64, error) {
+    rows, err := tx.Query(`
+        SELECT id, tags FROM images WHERE tags && $1::text[] ORDER BY id FOR UPDATE
+    `, pq.Array(tags))
+    if err != nil {
+        return nil, err
+    }
+
+    type imageTags struct {
+        id   int64
+        tags []string
+    }
+    var images []imageTags
+    for rows.Next() {
+        var img imageTags
+        if err := rows.Scan(&img.id, pq.Array(&img.tags)); err != nil {
+            rows.Close()
+            return nil, err
+        }
+        images = append(images, img)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    var ids []int64
+    for _, img := range images {
+        newTags := NormalizeTags(rewrite(img.tags))
+        if _, err := tx.Exec(`UPDATE images SET tags = $2::text[] WHERE id = $1`, img.id, pq.Array(newTags)); err != nil {
+            return nil, err
+        }
+        if err := setImageTags(tx, img.id, newTags); err != nil {
+            return nil, err
+        }
+        ids = append(ids, img.id)
+    }
+    return ids, nil
+}
+
+func tagExists(tx *sql.Tx, name string) (bool, error) {
+    var exists bool
+    err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM tags WHERE name = $1)`, name).Scan(&exists)
+    return exists, err
+}
+
+func replaceTags(tags []string, from map[string]bool, to string) []string {
+    replaced := make([]string, 0, len(tags))
+    for _, tag := range tags {
+        if from[tag] {
+            tag = to
+        }
+        if tag != "" {
+            replaced = append(replaced, tag)
+        }
+    }
+    return replaced
+}
+
+// RenameTag renames a tag on every image that carries it. Renaming onto an
+// existing tag returns ErrTagExists; use MergeTags for that instead.
+func RenameTag(from, to string) ([]int64, error) {
+    from, to = NormalizeTag(from), NormalizeTag(to)
+    if from == "" || to == "" {
+        return nil, ErrTagNotFound
+    }
+
+    tx, err := DB.Begin()
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback()
+
+    if exists, err := tagExists(tx, to); err != nil {
+        return nil, err
+    } else if exists {
+        return nil, ErrTagExists
+    }
+
+    result, err := tx.Exec(`UPDATE tags SET name = $2 WHERE name = $1`, from, to)
+    if err != nil {
+        return nil, err
+    }
+    if n, _ := result.RowsAffected(); n == 0 {
+        return nil, ErrTagNotFound
+    }
+
+    ids, err := rewriteImageTags(tx, []string{from}, func(tags []string) []string {
+        return replaceTags(tags, map[string]bool{from: true}, to)
+    })
+    if err != nil {
+        return nil, err
+    }
+    return ids, tx.Commit()
+}
+
+// MergeTags replaces every source tag with target, which is created if it
+// does not exist, and deletes the source tags.
+func MergeTags(sources []string, target string) ([]int64, error) {
+    target = NormalizeTag(target)
+    sourceSet := make(map[string]bool)
+    for _, source := range NormalizeTags(sources) {
+        if source != target {
+            sourceSet[source] = true
+        }
+    }
+    if target == "" || len(sourceSet) == 0 {
+        return nil, ErrTagNotFound
+    }
+    var sourceList []string
+    for source := range sourceSet {
+        sourceList = append(sourceList, source)
+    }
+
+    tx, err := DB.Begin()
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback()
+
+    var found int
+    if err := tx.QueryRow(`SELECT count(*) FROM tags WHERE name = ANY($1::text[])`, pq.Array(sourceList)).Scan(&found); err != nil {
+        return nil, err
+    }
+    if found == 0 {
+        return nil, ErrTagNotFound
+    }
+
+    ids, err := rewriteImageTags(tx, sourceList, func(tags []string) []string {
+        return replaceTags(tags, sourceSet, target)
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    if _, err := tx.Exec(`DELETE FROM tags WHERE name = ANY($1::text[])`, pq.Array(sourceList)); err != nil {
+        return nil, err
+    }
+    return ids, tx.Commit()
+}
+
+// DeleteTag removes a tag from every image and deletes it.
+func DeleteTag(name string) ([]int64, error) {
+    name = NormalizeTag(name)
+
+    tx, err := DB.Begin()
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback()
+
+    ids, err := rewriteImageTags(tx, []string{name}, func(tags []string) []string {
+        return replaceTags(tags, map[string]bool{name: true}, "")
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    result, err := tx.Exec(`DELETE FROM tags WHERE name = $1`, name)
+    if err != nil {
+        return nil, err
+    }
+    if n, _ := result.RowsAffected(); n == 0 && len(ids) == 0 {
+        return nil, ErrTagNotFound
+    }
+    return ids, tx.Commit()
+}
+
+var ErrInvalidSynonym = errors.New("alias and tag must be different, non-empty tags")
+
+type TagSynonym struct {
+    Alias     string    `json:"alias"`
+    Tag       string    `json:"tag"`
+    CreatedAt time.Time `json:"created_at"`
+}
+
+func GetTagSynonyms() ([]TagSynonym, error) {
+    rows, err := DB.Query(`SELECT alias, tag, created_at FROM tag_synonyms ORDER BY tag, alias`)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    synonyms := []TagSynonym{}
+    for rows.Next() {
+        var synonym TagSynonym
+        if err := rows.Scan(&synonym.Alias, &synonym.Tag, &synonym.CreatedAt); err != nil {
+            return nil, err
+        }
+        synonyms = append(synonyms, synonym)
+    }
+    if err = rows.Err(); err != nil {
+        return nil, err
+    }
+    return synonyms, nil
+}
+
+// SetTagSynonym makes alias an alternative name for tag. If tag is itself
+// an alias it is resolved first, so aliases always point at a canonical tag.
+func SetTagSynonym(alias, tag string) (*TagSynonym, error) {
+    alias, tag = NormalizeTag(alias), NormalizeTag(tag)
+    if alias == "" || tag == "" || alias == tag {
+        return nil, ErrInvalidSynonym
+    }
+
+    tx, err := DB.Begin()
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback()
+
+    var canonical string
+    err = tx.QueryRow(`SELECT tag FROM tag_synonyms WHERE alias = $1`, tag).Scan(&canonical)
+    if err == nil {
+        tag = canonical
+    } else if err != sql.ErrNoRows {
+        return nil, err
+    }
+    if alias == tag {
+        return nil, ErrInvalidSynonym
+    }
+
+    var synonym TagSynonym
+    err = tx.QueryRow(`
+        INSERT INTO tag_synonyms (alias, tag) VALUES ($1, $2)
+        ON CONFLICT (alias) DO UPDATE SET tag = EXCLUDED.tag
+        RETURNING alias, tag, created_at
+    `, alias, tag).Scan(&synonym.Alias, &synonym.Tag, &synonym.CreatedAt)
+    if err != nil {
+        return nil, err
+    }
+
+    // Anything that pointed at the alias now points at its canonical tag
+    if _, err := tx.Exec(`UPDATE tag_synonyms SET tag = $2 WHERE tag = $1`, alias, tag); err != nil {
+        return nil, err
+    }
+    return &synonym, tx.Commit()
+}
+
+func DeleteTagSynonym(alias string) error {
+    result, err := DB.Exec(`DELETE FROM tag_synonyms WHERE alias = $1`, NormalizeTag(alias))
+    if err != nil {
+        return err
+    }
+    if n, _ := result.RowsAffected(); n == 0 {
+        return ErrTagNotFound
+    }
+    return nil
+}
+
+type TagStat struct {
+    Name       string    `json:"name"`
+    ImageCount int       `json:"image_count"`
+    FirstUsed  time.Time `json:"first_used"`
+    LastUsed   time.Time `json:"last_used"`
+}
+
+// tagStatSorts maps the sort names accepted by GetTagStats to columns.
+var tagStatSorts = map[string]string{
+    "count":      "image_count",
+    "name":       "tags.name",
+    "first_used": "first_used",
+    "last_used":  "last_used",
+}
+
+func ValidTagStatSort(sort string) bool {
+    _, ok := tagStatSorts[sort]
+    return ok
+}
+
+// GetTagStats returns a page of tags that are in use along with the total
+// number of tags in use. sort must be one accepted by ValidTagStatSort.
+func GetTagStats(sort string, descending bool, limit, offset int) ([]TagStat, int, error) {
+    column, ok := tagStatSorts[sort]
+    if !ok {
+        return nil, 0, fmt.Errorf("invalid tag sort %q", sort)
+    }
+    direction := "ASC"
+    if descending {
+        direction = "DESC"
+    }
+
+    var total int
+    if err := DB.QueryRow(`SELECT count(DISTINCT tag_id) FROM image_tags`).Scan(&total); err != nil {
+        return nil, 0, err
+    }
+
+    rows, err := DB.Query(`
+        SELECT tags.name, count(*) AS image_count,
+               min(images.created_at) AS first_used, max(images.created_at) AS last_used
+        FROM tags
+        JOIN image_tags ON image_tags.tag_id = tags.id
+        JOIN images ON images.id = image_tags.image_id
+        GROUP BY tags.id, tags.name
+        ORDER BY `+column+` `+direction+`, tags.name ASC
+        LIMIT $1 OFFSET $2
+    `, limit, offset)
+    if err != nil {
+        return nil, 0, err
+    }
+    defer rows.Close()
+
+    stats := []TagStat{}
+    for rows.Next() {
+        var stat TagStat
+        if err := rows.Scan(&stat.Name, &stat.ImageCount, &stat.FirstUsed, &stat.LastUsed); err != nil {
+            return nil, 0, err
+        }
+        stats = append(stats, stat)
+    }
+    if err = rows.Err(); err != nil {
+        return nil, 0, err
+    }
+    return stats, total, nil
+}
+
+// CountTagImages returns the number of images carrying each of the given
+// tags, or every tag in use when names is nil. Tags on no images are left
+// out of the result.
+func CountTagImages(names []string) (map[string]int, error) {
+    rows, err := DB.Query(`
+        SELECT tags.name, count(*)
+        FROM tags
+        JOIN image_tags ON image_tags.tag_id = tags.id
+        WHERE $1::text[] IS NULL OR tags.name = ANY($1::text[])
+        GROUP BY tags.name
+    `, pq.Array(names))
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    counts := make(map[string]int)
+    for rows.Next() {
+        var name string
+        var count int
+        if err := rows.Scan(&name, &count); err != nil {
+            return nil, err
+        }
+        counts[name] = count
+    }
+    if err = rows.Err(); err != nil {
+        return nil, err
+    }
+    return counts, nil
+}
+
+// CountMatchingTagImages counts the images carrying each tag whose name
+// contains query or is similar to it by trigrams, as a misspelling would be.
+// Only the limit most used tags are returned.
+func CountMatchingTagImages(query string, limit int) (map[string]int, error) {
+    rows, err := DB.Query(`
+        SELECT tags.name, count(*)
+        FROM tags
+        JOIN image_tags ON image_tags.tag_id = tags.id
+        WHERE tags.name LIKE '%' || $1 || '%' OR $2 <% tags.name
+        GROUP BY tags.name
+        ORDER BY count(*) DESC, tags.name ASC
+        LIMIT $3
+    `, escapeLike(query), query, limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    counts := make(map[string]int)
+    for rows.Next() {
+        var name string
+        var count int
+        if err := rows.Scan(&name, &count); err != nil {
+            return nil, err
+        }
+        counts[name] = count
+    }
+    if err = rows.Err(); err != nil {
+        return nil, err
+    }
+    return counts, nil
+}
+
+// RelatedTags returns the tags that appear most often on images carrying all
+// of the given tags, excluding the given tags themselves.
+func RelatedTags(tags []string, limit int) ([]string, error) {
+    rows, err := DB.Query(`
+        SELECT tag
+        FROM images, unnest(images.tags) AS tag
+        WHERE images.tags @> $1::text[] AND NOT tag = ANY($1::text[])
+        GROUP BY tag
+        ORDER BY count(*) DESC, tag ASC
+        LIMIT $2
+    `, pq.Array(tags), limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    related := []string{}
+    for rows.Next() {
+        var tag string
+        if err := rows.Scan(&tag); err != nil {
+            return nil, err
+        }
+        related = append(related, tag)
+    }
+    if err = rows.Err(); err != nil {
+        return nil, err
+    }
+    return related, nil
+}
@@ -0,0 +1,150 @@
+package db
+
+import (
+    "fmt"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+func TestNormalizeTag(t *testing.T) {
+    for _, test := range []struct {
+        tag, want string
+    }{
+        {"sunset", "sunset"},
+        {"  Sunset  ", "sunset"},
+        {"New \t  York", "new york"},
+        {"rock & roll!", "rock & roll"},
+        {"C++", "c"},
+        {"don't-stop_me.now", "don't-stop_me.now"},
+        {"!!!", ""},
+        {"", ""},
+        // NFKC folds compatibility forms
+        {"Ｂｅｒｌｉｎ", "berlin"},
+        {"ﬁsh", "fish"},
+        {"cafe\u0301", "café"},
+        // Combining marks are kept, unlike the [:alnum:] class in SQL
+        {"हिन्दी", "हिन्दी"},
+        {"x\u0316", "x\u0316"},
+        // Simple Unicode case mapping, whatever the database's locale
+        {"İstanbul", "istanbul"},
+        {"Straße", "straße"},
+        // Namespaces and paths
+        {"Location : Berlin / Mitte", "location:berlin/mitte"},
+        {"animals//cats/", "animals/cats"},
+        {"a:b:c", "a:bc"},
+        {"a/b:c", "ab:c"},
+        {":cats", "cats"},
+        {"place:", "place"},
+        {"/", ""},
+        // Length is counted in runes, and the cut is trimmed again
+        {strings.Repeat("a", 70), strings.Repeat("a", MaxTagLength)},
+        {strings.Repeat("ä", 70), strings.Repeat("ä", MaxTagLength)},
+        {strings.Repeat("a", 63) + " b", strings.Repeat("a", 63)},
+        {strings.Repeat("a", 63) + "/b", strings.Repeat("a", 63)},
+    } {
+        if got := NormalizeTag(test.tag); got != test.want {
+            t.Errorf("NormalizeTag(%q) = %q, want %q", test.tag, got, test.want)
+        }
+    }
+}
+
+func TestNormalizeTagIsIdempotent(t *testing.T) {
+    for _, tag := range []string{"Location : Berlin / Mitte", "cafe\u0301", "İstanbul", "a:b:c", strings.Repeat("ab ", 30)} {
+        once := NormalizeTag(tag)
+        if twice := NormalizeTag(once); twice != once {
+            t.Errorf("NormalizeTag(%q) = %q, but normalising that gives %q", tag, once, twice)
+        }
+    }
+}
+
+func TestParseTags(t *testing.T) {
+    for _, test := range []struct {
+        tags string
+        want []string
+    }{
+        {"", []string{}},
+        {" , ,", []string{}},
+        {"sunset", []string{"sunset"}},
+        {"Sunset, beach ,, SUNSET,  ", []string{"sunset", "beach"}},
+        {"beach,Sunset,sunset!", []string{"beach", "sunset"}},
+        {"location:berlin, Location:Berlin/", []string{"location:berlin"}},
+    } {
+        if got := ParseTags(test.tags); !reflect.DeepEqual(got, test.want) {
+            t.Errorf("ParseTags(%q) = %q, want %q", test.tags, got, test.want)
+        }
+    }
+}
+
+func TestNormalizeTagsCapsCount(t *testing.T) {
+    var tags []string
+    for i := 0; i < MaxTagsPerImage+10; i++ {
+        // Duplicates don't count towards the cap
+        tags = append(tags, fmt.Sprintf("tag%d", i), fmt.Sprintf("TAG%d", i))
+    }
+
+    got := NormalizeTags(tags)
+    if len(got) != MaxTagsPerImage {
+        t.Fatalf("NormalizeTags kept %d tags, want %d", len(got), MaxTagsPerImage)
+    }
+    if got[0] != "tag0" || got[len(got)-1] != fmt.Sprintf("tag%d", MaxTagsPerImage-1) {
+        t.Errorf("NormalizeTags kept %q to %q, want the first %d", got[0], got[len(got)-1], MaxTagsPerImage)
+    }
+}
+
+func TestSplitTag(t *testing.T) {
+    for _, test := range []struct {
+        tag       string
+        namespace string
+        segments  []string
+    }{
+        {"", "", nil},
+        {"sunset", "", []string{"sunset"}},
+        {"location:berlin", "location", []string{"berlin"}},
+        {"location:berlin/mitte", "location", []string{"berlin", "mitte"}},
+        {"animals/cats/kittens", "", []string{"animals", "cats", "kittens"}},
+        {"location:", "location", nil},
+        {":berlin", "", []string{"berlin"}},
+        // Separators out of place are dropped
+        {"a/b:c/d", "ab", []string{"c", "d"}},
+        {"a:b:c", "a", []string{"bc"}},
+        // Empty segments and extra whitespace
+        {"/animals//cats/", "", []string{"animals", "cats"}},
+        {" new  york : upper   east / side ", "new york", []string{"upper east", "side"}},
+    } {
+        namespace, segments := SplitTag(test.tag)
+        if namespace != test.namespace || !reflect.DeepEqual(segments, test.segments) {
+            t.Errorf("SplitTag(%q) = %q, %q, want %q, %q", test.tag, namespace, segments, test.namespace, test.segments)
+        }
+    }
+}
+
+func TestTagDescendants(t *testing.T) {
+    nested := []string{
+        "animals/cats",
+        "animals/cats/kittens",
+        "animals/dogs",
+        "animalsx/cats",
+        "location:berlin",
+        "location:berlin/mitte",
+        "location:paris",
+        "locations:rome",
+    }
+    for _, test := range []struct {
+        tags []string
+        want []string
+    }{
+        {nil, []string{}},
+        {[]string{"sunset"}, []string{"sunset"}},
+        {[]string{"animals"}, []string{"animals", "animals/cats", "animals/cats/kittens", "animals/dogs"}},
+        {[]string{"animals/cats"}, []string{"animals/cats", "animals/cats/kittens"}},
+        {[]string{"location"}, []string{"location", "location:berlin", "location:berlin/mitte", "location:paris"}},
+        {[]string{"location:berlin"}, []string{"location:berlin", "location:berlin/mitte"}},
+        // A given tag below another is listed again, as expandQuery removes duplicates
+        {[]string{"animals", "animals/cats"}, []string{"animals", "animals/cats", "animals/cats", "animals/cats/kittens", "animals/dogs"}},
+    } {
+        if got := TagDescendants(test.tags, nested); !reflect.DeepEqual(got, test.want) {
+            t.Errorf("TagDescendants(%q) = %q, want %q", test.tags, got, test.want)
+        }
+    }
+}
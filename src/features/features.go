@@ -0,0 +1,199 @@
+// Package features computes a compact visual description of an image for
+// similarity search. Everything is plain CPU work on the decoded pixels so
+// it needs no GPU or external service.
+package features
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+    "image"
+    _ "image/gif"
+    _ "image/jpeg"
+    _ "image/png"
+    "io"
+    "math"
+)
+
+// The vector is a colour histogram followed by edge orientation and edge
+// strength histograms. Each part is normalised to sum to one and scaled by
+// its weight, then the whole vector is scaled to unit length so cosine
+// similarity compares images regardless of size.
+const (
+    colourLevels      = 4 // per channel, giving colourLevels^3 bins
+    orientationBins   = 8
+    magnitudeBins     = 8
+    maxSampleSize     = 128
+    colourWeight      = 1.0
+    orientationWeight = 0.6
+    magnitudeWeight   = 0.4
+
+    // MaxPixels is the largest image Decode will decode. A small file can
+    // declare huge dimensions, and decoding allocates memory for all of them.
+    MaxPixels = 50_000_000
+
+    // Dims is the length of every vector Compute returns.
+    Dims = colourLevels*colourLevels*colourLevels + orientationBins + magnitudeBins
+)
+
+// ErrTooLarge is returned by Decode for images with more than MaxPixels.
+var ErrTooLarge = errors.New("image is too large")
+
+// Decode reads an image in any format registered with the image package
+// and computes its feature vector. The dimensions in the header are checked
+// against MaxPixels before the pixels are decoded.
+func Decode(r io.Reader) ([]float32, error) {
+    var header bytes.Buffer
+    config, _, err := image.DecodeConfig(io.TeeReader(r, &header))
+    if err != nil {
+        return nil, err
+    }
+    if config.Height > 0 && config.Width > MaxPixels/config.Height {
+        return nil, fmt.Errorf("%w: %dx%d", ErrTooLarge, config.Width, config.Height)
+    }
+
+    img, _, err := image.Decode(io.MultiReader(&header, r))
+    if err != nil {
+        return nil, err
+    }
+    return Compute(img), nil
+}
+
+// Compute returns the feature vector of img.
+func Compute(img image.Image) []float32 {
+    gray, width, height := sample(img)
+    vector := make([]float64, Dims)
+
+    colours := vector[:colourLevels*colourLevels*colourLevels]
+    bounds := img.Bounds()
+    for y := 0; y < height; y++ {
+        for x := 0; x < width; x++ {
+            r, g, b, _ := img.At(bounds.Min.X+x*bounds.Dx()/width, bounds.Min.Y+y*bounds.Dy()/height).RGBA()
+            index := level(r)*colourLevels*colourLevels + level(g)*colourLevels + level(b)
+            colours[index]++
+        }
+    }
+
+    orientations := vector[len(colours) : len(colours)+orientationBins]
+    magnitudes := vector[len(colours)+orientationBins:]
+    for y := 1; y < height-1; y++ {
+        for x := 1; x < width-1; x++ {
+            gx, gy := sobel(gray, width, x, y)
+            magnitude := math.Hypot(gx, gy)
+            magnitudes[magnitudeBin(magnitude)]++
+            if magnitude < 1 {
+                continue
+            }
+            // Edges are undirected, so fold angles into [0, pi)
+            angle := math.Atan2(gy, gx)
+            if angle < 0 {
+                angle += math.Pi
+            }
+            orientations[min(int(angle/math.Pi*orientationBins), orientationBins-1)] += magnitude
+        }
+    }
+
+    normalise(colours, colourWeight)
+    normalise(orientations, orientationWeight)
+    normalise(magnitudes, magnitudeWeight)
+
+    length := 0.0
+    for _, v := range vector {
+        length += v * v
+    }
+    length = math.Sqrt(length)
+
+    result := make([]float32, Dims)
+    for i, v := range vector {
+        if length > 0 {
+            result[i] = float32(v / length)
+        }
+    }
+    return result
+}
+
+// sample scales img down to at most maxSampleSize on its longer side by
+// nearest-neighbour sampling, returning its luminance.
+func sample(img image.Image) ([]float64, int, int) {
+    bounds := img.Bounds()
+    width, height := bounds.Dx(), bounds.Dy()
+    if width == 0 || height == 0 {
+        return nil, 0, 0
+    }
+    if longest := max(width, height); longest > maxSampleSize {
+        width = max(width*maxSampleSize/longest, 1)
+        height = max(height*maxSampleSize/longest, 1)
+    }
+
+    gray := make([]float64, width*height)
+    for y := 0; y < height; y++ {
+        for x := 0; x < width; x++ {
+            r, g, b, _ := img.At(bounds.Min.X+x*bounds.Dx()/width, bounds.Min.Y+y*bounds.Dy()/height).RGBA()
+            gray[y*width+x] = (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 257
+        }
+    }
+    return gray, width, height
+}
+
+func level(channel uint32) int {
+    return min(int(channel)*colourLevels/0x10000, colourLevels-1)
+}
+
+func sobel(gray []float64, width, x, y int) (float64, float64) {
+    at := func(dx, dy int) float64 { return gray[(y+dy)*width+x+dx] }
+    gx := at(1, -1) + 2*at(1, 0) + at(1, 1) - at(-1, -1) - 2*at(-1, 0) - at(-1, 1)
+    gy := at(-1, 1) + 2*at(0, 1) + at(1, 1) - at(-1, -1) - 2*at(0, -1) - at(1, -1)
+    return gx, gy
+}
+
+// magnitudeBin buckets gradient strength on a log scale, from flat areas
+// to the strongest edges a Sobel filter can produce on 8-bit luminance.
+func magnitudeBin(magnitude float64) int {
+    const maxMagnitude = 4 * 255 * math.Sqrt2
+    bin := int(math.Log1p(magnitude) / math.Log1p(maxMagnitude) * magnitudeBins)
+    return min(max(bin, 0), magnitudeBins-1)
+}
+
+func normalise(histogram []float64, weight float64) {
+    total := 0.0
+    for _, v := range histogram {
+        total += v
+    }
+    if total == 0 {
+        return
+    }
+    for i := range histogram {
+        histogram[i] = histogram[i] / total * weight
+    }
+}
+
+// Valid reports whether vector came from Compute and can be compared. An
+// empty image gives an all-zero vector, which has no direction.
+func Valid(vector []float32) bool {
+    if len(vector) != Dims {
+        return false
+    }
+    for _, v := range vector {
+        if v != 0 {
+            return true
+        }
+    }
+    return false
+}
+
+// Similarity returns the cosine similarity of two vectors from Compute.
+func Similarity(a, b []float32) float64 {
+    if len(a) != len(b) {
+        return 0
+    }
+    var dot, normA, normB float64
+    for i := range a {
+        dot += float64(a[i]) * float64(b[i])
+        normA += float64(a[i]) * float64(a[i])
+        normB += float64(b[i]) * float64(b[i])
+    }
+    if normA == 0 || normB == 0 {
+        return 0
+    }
+    return dot / math.Sqrt(normA*normB)
+}
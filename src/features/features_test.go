@@ -0,0 +1,168 @@
+package features
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "hash/crc32"
+    "image"
+    "image/color"
+    "image/png"
+    "math"
+    "testing"
+)
+
+func solid(width, height int, c color.Color) image.Image {
+    img := image.NewRGBA(image.Rect(0, 0, width, height))
+    for y := 0; y < height; y++ {
+        for x := 0; x < width; x++ {
+            img.Set(x, y, c)
+        }
+    }
+    return img
+}
+
+// stripes draws vertical black and white stripes, giving strong
+// horizontal gradients.
+func stripes(width, height, period int) image.Image {
+    img := image.NewRGBA(image.Rect(0, 0, width, height))
+    for y := 0; y < height; y++ {
+        for x := 0; x < width; x++ {
+            if x/period%2 == 0 {
+                img.Set(x, y, color.White)
+            } else {
+                img.Set(x, y, color.Black)
+            }
+        }
+    }
+    return img
+}
+
+func TestComputeIsUnitLength(t *testing.T) {
+    for name, img := range map[string]image.Image{
+        "solid":   solid(40, 30, color.RGBA{200, 40, 40, 255}),
+        "stripes": stripes(300, 200, 4),
+        "offset":  stripes(300, 200, 4).(*image.RGBA).SubImage(image.Rect(10, 10, 200, 150)),
+    } {
+        vector := Compute(img)
+        if len(vector) != Dims {
+            t.Fatalf("%s: got %d dims, want %d", name, len(vector), Dims)
+        }
+        length := 0.0
+        for _, v := range vector {
+            length += float64(v) * float64(v)
+        }
+        if math.Abs(math.Sqrt(length)-1) > 1e-5 {
+            t.Errorf("%s: length %f, want 1", name, math.Sqrt(length))
+        }
+        if !Valid(vector) {
+            t.Errorf("%s: vector is not valid", name)
+        }
+    }
+}
+
+func TestComputeEmptyImage(t *testing.T) {
+    vector := Compute(image.NewRGBA(image.Rect(0, 0, 0, 0)))
+    if Valid(vector) {
+        t.Errorf("vector of an empty image is valid: %v", vector)
+    }
+}
+
+func TestComputeIgnoresScale(t *testing.T) {
+    small := Compute(stripes(200, 100, 8))
+    large := Compute(stripes(800, 400, 32))
+    if similarity := Similarity(small, large); similarity < 0.99 {
+        t.Errorf("similarity of the same image at two sizes is %f, want at least 0.99", similarity)
+    }
+}
+
+func TestSimilarity(t *testing.T) {
+    red := Compute(solid(50, 50, color.RGBA{220, 20, 20, 255}))
+    darkRed := Compute(solid(50, 50, color.RGBA{200, 30, 30, 255}))
+    blue := Compute(solid(50, 50, color.RGBA{20, 20, 220, 255}))
+    lines := Compute(stripes(50, 50, 2))
+
+    if got := Similarity(red, red); math.Abs(got-1) > 1e-6 {
+        t.Errorf("Similarity(red, red) = %f, want 1", got)
+    }
+    if Similarity(red, darkRed) <= Similarity(red, blue) {
+        t.Errorf("red is closer to blue (%f) than to dark red (%f)", Similarity(red, blue), Similarity(red, darkRed))
+    }
+    if Similarity(red, lines) >= Similarity(red, darkRed) {
+        t.Errorf("red is closer to stripes (%f) than to dark red (%f)", Similarity(red, lines), Similarity(red, darkRed))
+    }
+    if got := Similarity(red, blue); got != Similarity(blue, red) {
+        t.Errorf("Similarity is not symmetric")
+    }
+    if got := Similarity(red, red[:Dims-1]); got != 0 {
+        t.Errorf("Similarity of different lengths = %f, want 0", got)
+    }
+    if got := Similarity(red, make([]float32, Dims)); got != 0 {
+        t.Errorf("Similarity with a zero vector = %f, want 0", got)
+    }
+}
+
+func TestValid(t *testing.T) {
+    one := make([]float32, Dims)
+    one[3] = 1
+    for _, test := range []struct {
+        name   string
+        vector []float32
+        want   bool
+    }{
+        {"nil", nil, false},
+        {"short", []float32{1, 0, 0}, false},
+        {"zero", make([]float32, Dims), false},
+        {"long", append(one, 0), false},
+        {"non-zero", one, true},
+    } {
+        if got := Valid(test.vector); got != test.want {
+            t.Errorf("Valid(%s) = %v, want %v", test.name, got, test.want)
+        }
+    }
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+    t.Helper()
+    var buf bytes.Buffer
+    if err := png.Encode(&buf, img); err != nil {
+        t.Fatalf("encoding PNG: %v", err)
+    }
+    return buf.Bytes()
+}
+
+func TestDecode(t *testing.T) {
+    img := stripes(64, 48, 4)
+    vector, err := Decode(bytes.NewReader(encodePNG(t, img)))
+    if err != nil {
+        t.Fatalf("Decode: %v", err)
+    }
+    if got := Similarity(vector, Compute(img)); math.Abs(got-1) > 1e-6 {
+        t.Errorf("decoded vector differs from computed, similarity %f", got)
+    }
+}
+
+func TestDecodeRejectsHugeDimensions(t *testing.T) {
+    // Rewrite the IHDR of a tiny PNG to declare 60000x60000 pixels. Decode
+    // must refuse it from the header alone.
+    data := encodePNG(t, solid(1, 1, color.White))
+    ihdr := bytes.Index(data, []byte("IHDR"))
+    if ihdr < 0 {
+        t.Fatal("no IHDR chunk")
+    }
+    for _, offset := range []int{ihdr + 4, ihdr + 8} {
+        binary.BigEndian.PutUint32(data[offset:], 60000)
+    }
+    binary.BigEndian.PutUint32(data[ihdr+17:], crc32.ChecksumIEEE(data[ihdr:ihdr+17]))
+
+    _, err := Decode(bytes.NewReader(data))
+    if !errors.Is(err, ErrTooLarge) {
+        t.Errorf("Decode of a 60000x60000 PNG: got %v, want ErrTooLarge", err)
+    }
+}
+
+func TestDecodeRejectsGarbage(t *testing.T) {
+    if _, err := Decode(bytes.NewReader([]byte("not an image"))); err == nil {
+        t.Error("Decode of garbage succeeded")
+    }
+}
@@ -44,7 +44,7 @@ document.addEventListener('DOMContentLoaded', function() {
             const url = '/search' + (query ? `?q=${encodeURIComponent(query)}` : '') + 
                         (tagsParam ? `${query ? '&' : '?'}tags=${encodeURIComponent(tagsParam)}` : '');
             const response = await fetch(url);
-            const images = await response.json();
+            const images = (await response.json()) || [];
             
             gridContainer.innerHTML = images.map(image => `
                 <div class="grid-item">
@@ -1,3 +1,25 @@
+const RECENT_TAGS_KEY = 'imagerr.recentTags';
+const MAX_RECENT_TAGS = 20;
+
+// Recently used tags are kept in the browser and sent with suggestion
+// requests so they can be ranked higher.
+function getRecentTags() {
+    try {
+        return JSON.parse(localStorage.getItem(RECENT_TAGS_KEY)) || [];
+    } catch (error) {
+        return [];
+    }
+}
+
+function rememberTags(tags) {
+    const recent = [...tags, ...getRecentTags().filter(tag => !tags.includes(tag))];
+    try {
+        localStorage.setItem(RECENT_TAGS_KEY, JSON.stringify(recent.slice(0, MAX_RECENT_TAGS)));
+    } catch (error) {
+        console.warn('Unable to store recent tags:', error);
+    }
+}
+
 class TagSearchManager {
     constructor(searchContainer) {
         if (!searchContainer) {
@@ -46,7 +68,8 @@ class TagSearchManager {
         }
 
         try {
-            const response = await fetch(`/api/tags/suggest?q=${encodeURIComponent(query)}`);
+            const recent = getRecentTags().join(',');
+            const response = await fetch(`/api/tags/suggest?q=${encodeURIComponent(query)}&recent=${encodeURIComponent(recent)}`);
             const tags = await response.json();
             this.showAutocomplete(tags, query);
         } catch (error) {
@@ -123,6 +146,7 @@ class TagSearchManager {
         tagElement.appendChild(removeButton);
         this.tagContainer.appendChild(tagElement);
         this.selectedTags.add(tag);
+        rememberTags([tag]);
         this.searchInput.value = '';
         this.hideAutocomplete();
         this.triggerSearch();
@@ -171,6 +195,137 @@ let updateImageGrid;
 let handleTagClick;
 let showImageModal;
 
+// Renders tag links, grouping namespaced tags such as "location:berlin"
+// under their namespace. Un-namespaced tags come first.
+function renderTags(tags, activeTags = []) {
+    const groups = new Map();
+    tags.forEach(tag => {
+        const separator = tag.indexOf(':');
+        const namespace = separator === -1 ? '' : tag.slice(0, separator);
+        if (!groups.has(namespace)) {
+            groups.set(namespace, []);
+        }
+        groups.get(namespace).push(tag);
+    });
+
+    return Array.from(groups.keys()).sort().map(namespace => {
+        const links = groups.get(namespace).map(tag => {
+            const label = namespace ? tag.slice(namespace.length + 1) : tag;
+            const quoted = tag.replace(/\\/g, '\\\\').replace(/'/g, "\\'");
+            return `<a href="#" class="tag-link ${activeTags.includes(tag) ? 'active' : ''}" title="${tag}" onclick="event.preventDefault(); handleTagClick('${quoted}');">${label}</a>`;
+        }).join(' ');
+        if (!namespace) {
+            return links;
+        }
+        return `<span class="tag-group"><span class="tag-namespace">${namespace}</span> ${links}</span>`;
+    }).join(' ');
+}
+
+async function loadRelatedImages(imageId) {
+    const strip = document.getElementById('modalRelated');
+    strip.innerHTML = '';
+    try {
+        const response = await fetch(`/api/images/${imageId}/related`);
+        if (!response.ok) {
+            return;
+        }
+        const related = await response.json();
+        strip.innerHTML = related.map(image => `
+            <div class="related-item image-link" title="${image.original_filename}" onclick="showImageModal('${image.id}')">
+                <img src="${image.URL || '/static/placeholder.svg'}" alt="${image.description}" onerror="this.src='/static/placeholder.svg';">
+            </div>
+        `).join('');
+    } catch (error) {
+        console.error('Error fetching related images:', error);
+    }
+}
+
+async function incrementViewCount(imageId) {
+    try {
+        const response = await fetch(`/api/images/${imageId}/view`, { method: 'POST' });
+        if (response.ok) {
+            const result = await response.json();
+            document.getElementById('modalViews').textContent = result.view_count;
+        }
+    } catch (error) {
+        console.error('Error recording view:', error);
+    }
+}
+
+// The search the grid is showing, so opening a result can be recorded
+// against it for search analytics
+let currentSearch = null;
+
+// searchDetails reads the logged search ID and any spelling suggestion from
+// the headers of a /search response.
+function searchDetails(response) {
+    const id = Number(response.headers.get('X-Search-ID')) || null;
+    const suggestion = response.headers.get('X-Did-You-Mean');
+    return { id, didYouMean: suggestion ? decodeURIComponent(suggestion) : '' };
+}
+
+function setCurrentSearch(searchId, images) {
+    currentSearch = searchId ? { id: searchId, imageIds: images.map(image => String(image.id)) } : null;
+}
+
+async function recordSearchClick(imageId) {
+    if (!currentSearch) {
+        return;
+    }
+    const position = currentSearch.imageIds.indexOf(String(imageId)) + 1;
+    if (position === 0) {
+        return;
+    }
+    try {
+        await fetch('/api/search/clicks', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ search_id: currentSearch.id, image_id: Number(imageId), position })
+        });
+    } catch (error) {
+        console.error('Error recording search click:', error);
+    }
+}
+
+function openSearchResult(imageId) {
+    recordSearchClick(imageId);
+    showImageModal(imageId);
+}
+
+function renderGridItems(images, activeTags = []) {
+    return images.map(image => `
+        <div class="grid-item">
+            <div class="image-link" onclick="openSearchResult('${image.id}')">
+                <img src="${image.URL || '/static/placeholder.svg'}" alt="${image.description}" class="thumbnail" onerror="this.src='/static/placeholder.svg'; console.error('Failed to load image:', image.URL);">
+            </div>
+            <div class="filename"><span class="image-link" onclick="openSearchResult('${image.id}')">${image.original_filename}</span></div>
+            <div class="description">${image.description}</div>
+            <div class="tags">${renderTags(image.tags, activeTags)}</div>
+            <div class="upload-date">${new Date(image.created_at).toLocaleDateString()}</div>
+        </div>
+    `).join('');
+}
+
+function renderDidYouMean(suggestion, onSelect) {
+    const container = document.getElementById('did-you-mean');
+    if (!container) {
+        return;
+    }
+    container.textContent = '';
+    if (!suggestion) {
+        return;
+    }
+
+    const link = document.createElement('a');
+    link.href = '#';
+    link.textContent = suggestion;
+    link.addEventListener('click', event => {
+        event.preventDefault();
+        onSelect(suggestion);
+    });
+    container.append('Did you mean ', link, '?');
+}
+
 document.addEventListener('DOMContentLoaded', function() {
     const uploadForm = document.getElementById('upload-form');
     const gridContainer = document.querySelector('.grid-container');
@@ -184,21 +339,15 @@ document.addEventListener('DOMContentLoaded', function() {
             const url = '/search' + (query ? `?q=${encodeURIComponent(query)}` : '') + 
                         (tagsParam ? `${query ? '&' : '?'}tags=${encodeURIComponent(tagsParam)}` : '');
             const response = await fetch(url);
-            const images = await response.json();
+            const images = (await response.json()) || [];
+            const search = searchDetails(response);
+            setCurrentSearch(search.id, images);
+            renderDidYouMean(search.didYouMean, suggestion => {
+                document.getElementById('tag-search').value = suggestion;
+                updateImageGrid(suggestion);
+            });
             
-            gridContainer.innerHTML = images.map(image => `
-                <div class="grid-item">
-                    <div class="image-link" onclick="showImageModal('${image.id}')">
-                        <img src="${image.URL || '/static/placeholder.svg'}" alt="${image.description}" class="thumbnail" onerror="this.src='/static/placeholder.svg'; console.error('Failed to load image:', image.URL);">
-                    </div>
-                    <div class="filename"><span class="image-link" onclick="showImageModal('${image.id}')">${image.original_filename}</span></div>
-                    <div class="description">${image.description}</div>
-                    <div class="tags">${image.tags.map(tag => 
-                        `<a href="#" class="tag-link ${activeTags.includes(tag) ? 'active' : ''}" onclick="event.preventDefault(); handleTagClick('${tag}');">${tag}</a>`
-                    ).join(' ')}</div>
-                    <div class="upload-date">${new Date(image.created_at).toLocaleDateString()}</div>
-                </div>
-            `).join('');
+            gridContainer.innerHTML = renderGridItems(images, activeTags);
         } catch (error) {
             console.error('Error fetching images:', error);
         }
@@ -222,19 +371,42 @@ document.addEventListener('DOMContentLoaded', function() {
             document.getElementById('modalImage').src = image.URL;
             document.getElementById('modalDescription').textContent = image.description;
             document.getElementById('modalFilename').textContent = image.original_filename;
-            document.getElementById('modalTags').innerHTML = image.tags.map(tag => 
-                `<a href="#" class="tag-link" onclick="event.preventDefault(); handleTagClick('${tag}');">${tag}</a>`
-            ).join(' ');
+            document.getElementById('modalTags').innerHTML = renderTags(image.tags);
             document.getElementById('modalUploadDate').textContent = new Date(image.created_at).toLocaleString();
             document.getElementById('modalViews').textContent = image.view_count;
             
             modal.style.display = 'block';
             incrementViewCount(imageId);
+            loadRelatedImages(imageId);
         } catch (error) {
             console.error('Error fetching image details:', error);
         }
     };
 
+    const searchImageInput = document.getElementById('search-image');
+    searchImageInput.addEventListener('change', async function() {
+        if (searchImageInput.files.length === 0) {
+            return;
+        }
+        const formData = new FormData();
+        formData.append('image', searchImageInput.files[0]);
+
+        try {
+            const response = await fetch('/api/search/image', { method: 'POST', body: formData });
+            const result = await response.json();
+            if (!response.ok) {
+                throw new Error(result.error || 'Search failed');
+            }
+            renderDidYouMean('');
+            setCurrentSearch(null, result);
+            gridContainer.innerHTML = renderGridItems(result);
+        } catch (error) {
+            alert('Error searching by image: ' + error.message);
+        } finally {
+            searchImageInput.value = '';
+        }
+    });
+
     closeBtn.onclick = function() {
         modal.style.display = 'none';
     }
@@ -251,21 +423,15 @@ document.addEventListener('DOMContentLoaded', function() {
             const url = '/search' + (query ? `?q=${encodeURIComponent(query)}` : '') + 
                         (tagsParam ? `${query ? '&' : '?'}tags=${encodeURIComponent(tagsParam)}` : '');
             const response = await fetch(url);
-            const images = await response.json();
+            const images = (await response.json()) || [];
+            const search = searchDetails(response);
+            setCurrentSearch(search.id, images);
+            renderDidYouMean(search.didYouMean, suggestion => {
+                document.getElementById('tag-search').value = suggestion;
+                updateImageGrid(suggestion);
+            });
             
-            gridContainer.innerHTML = images.map(image => `
-                <div class="grid-item">
-                    <div class="image-link" onclick="showImageModal('${image.id}')">
-                        <img src="${image.URL || '/static/placeholder.svg'}" alt="${image.description}" class="thumbnail" onerror="this.src='/static/placeholder.svg'; console.error('Failed to load image:', image.URL);">
-                    </div>
-                    <div class="filename"><span class="image-link" onclick="showImageModal('${image.id}')">${image.original_filename}</span></div>
-                    <div class="description">${image.description}</div>
-                    <div class="tags">${image.tags.map(tag => 
-                        `<a href="#" class="tag-link ${activeTags.includes(tag) ? 'active' : ''}" onclick="event.preventDefault(); handleTagClick('${tag}');">${tag}</a>`
-                    ).join(' ')}</div>
-                    <div class="upload-date">${new Date(image.created_at).toLocaleDateString()}</div>
-                </div>
-            `).join('');
+            gridContainer.innerHTML = renderGridItems(images, activeTags);
         } catch (error) {
             console.error('Error fetching images:', error);
         }
@@ -284,6 +450,61 @@ document.addEventListener('DOMContentLoaded', function() {
     window.handleTagClick = handleTagClick;
     window.showImageModal = showImageModal;
 
+    // Offer a language choice only when more than one is configured
+    const uploadLanguage = document.getElementById('upload-language');
+    fetch('/api/languages')
+        .then(response => response.json())
+        .then(result => {
+            uploadLanguage.innerHTML = result.languages.map(language =>
+                `<option value="${language}" ${language === result.default ? 'selected' : ''}>${language}</option>`
+            ).join('');
+            uploadLanguage.hidden = result.languages.length < 2;
+        })
+        .catch(error => console.error('Error fetching languages:', error));
+
+    // Suggest tags that often appear alongside the ones already entered
+    const uploadTagsInput = uploadForm.querySelector('input[type="text"]');
+    const uploadTagSuggestions = document.getElementById('upload-tag-suggestions');
+    let relatedTagsTimeout;
+
+    function enteredUploadTags() {
+        return uploadTagsInput.value.split(',').map(tag => tag.trim().toLowerCase()).filter(tag => tag);
+    }
+
+    async function updateRelatedTags() {
+        const tags = enteredUploadTags();
+        if (tags.length === 0) {
+            uploadTagSuggestions.innerHTML = '';
+            return;
+        }
+        try {
+            const response = await fetch(`/api/tags/related?tags=${encodeURIComponent(tags.join(','))}`);
+            if (!response.ok) {
+                throw new Error('Failed to fetch related tags');
+            }
+            const related = await response.json();
+            uploadTagSuggestions.innerHTML = related.length === 0 ? '' :
+                'Suggested: ' + related.map(tag => `<a href="#" class="tag-link" data-tag="${tag}">${tag}</a>`).join(' ');
+        } catch (error) {
+            console.error('Error fetching related tags:', error);
+        }
+    }
+
+    uploadTagsInput.addEventListener('input', function() {
+        clearTimeout(relatedTagsTimeout);
+        relatedTagsTimeout = setTimeout(updateRelatedTags, 300);
+    });
+
+    uploadTagSuggestions.addEventListener('click', function(event) {
+        const link = event.target.closest('[data-tag]');
+        if (!link) {
+            return;
+        }
+        event.preventDefault();
+        uploadTagsInput.value = [...enteredUploadTags(), link.dataset.tag].join(', ');
+        updateRelatedTags();
+    });
+
     uploadForm.addEventListener('submit', async function(e) {
         e.preventDefault();
 
@@ -291,6 +512,16 @@ document.addEventListener('DOMContentLoaded', function() {
         formData.append('image', uploadForm.querySelector('input[type="file"]').files[0]);
         formData.append('description', uploadForm.querySelector('textarea').value);
         formData.append('tags', uploadForm.querySelector('input[type="text"]').value);
+        if (uploadLanguage.value) {
+            formData.append('language', uploadLanguage.value);
+        }
+        // Without a location the camera's GPS position is used, if any
+        const latitude = document.getElementById('upload-latitude').value;
+        const longitude = document.getElementById('upload-longitude').value;
+        if (latitude !== '' || longitude !== '') {
+            formData.append('latitude', latitude);
+            formData.append('longitude', longitude);
+        }
 
         try {
             const response = await fetch('/upload', {
@@ -303,8 +534,12 @@ document.addEventListener('DOMContentLoaded', function() {
             }
 
             const result = await response.json();
-            alert('Image uploaded successfully!');
+            rememberTags(result.tags || []);
+            const suggested = (result.suggested_tags || []).map(suggestion => suggestion.tag);
+            alert('Image uploaded successfully!' +
+                (suggested.length > 0 ? '\nSuggested tags: ' + suggested.join(', ') : ''));
             uploadForm.reset();
+            uploadTagSuggestions.innerHTML = '';
             updateImageGrid();
         } catch (error) {
             alert('Error uploading image: ' + error.message);
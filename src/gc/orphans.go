@@ -0,0 +1,150 @@
+package gc
+
+import (
+    "fmt"
+    "log"
+    "os"
+    "strconv"
+    "time"
+    "github.com/grrywlsn/imagerr/src/db"
+    "github.com/grrywlsn/imagerr/src/storage"
+)
+
+const defaultGracePeriod = 24 * time.Hour
+
+// MinGracePeriod is the shortest grace period CollectOrphans accepts. An
+// upload stores its object before inserting the image row, so anything
+// shorter risks deleting objects whose row is still being written.
+const MinGracePeriod = 10 * time.Minute
+
+type Orphan struct {
+    StoragePath  string    `json:"storage_path"`
+    Size         int64     `json:"size"`
+    LastModified time.Time `json:"last_modified"`
+    Deleted      bool      `json:"deleted"`
+}
+
+type Report struct {
+    DryRun      bool      `json:"dry_run"`
+    GracePeriod string    `json:"grace_period"`
+    Scanned     int       `json:"scanned"`
+    Orphans     []Orphan  `json:"orphans"`
+    Deleted     int       `json:"deleted"`
+    Errors      []string  `json:"errors,omitempty"`
+    StartedAt   time.Time `json:"started_at"`
+}
+
+// CollectOrphans deletes objects under images/ that have no matching
+// storage_path in the database. Objects younger than the grace period are
+// skipped so uploads that have not reached the database yet are left alone.
+// Grace periods below MinGracePeriod are raised to it.
+func CollectOrphans(gracePeriod time.Duration, dryRun bool) (*Report, error) {
+    if gracePeriod < MinGracePeriod {
+        gracePeriod = MinGracePeriod
+    }
+    report := &Report{
+        DryRun:      dryRun,
+        GracePeriod: gracePeriod.String(),
+        Orphans:     []Orphan{},
+        StartedAt:   time.Now(),
+    }
+
+    objects, err := storage.ListFiles("images/")
+    if err != nil {
+        return nil, err
+    }
+
+    // Load paths after listing so anything uploaded in between is known
+    knownPaths, err := db.GetAllStoragePaths()
+    if err != nil {
+        return nil, fmt.Errorf("failed to fetch storage paths: %v", err)
+    }
+
+    report.Scanned = len(objects)
+    for _, object := range findOrphans(objects, knownPaths, report.StartedAt.Add(-gracePeriod)) {
+        orphan := Orphan{
+            StoragePath:  object.Key,
+            Size:         object.Size,
+            LastModified: object.LastModified,
+        }
+        if !dryRun {
+            if err := storage.DeleteFile(object.Key); err != nil {
+                report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", object.Key, err))
+            } else {
+                orphan.Deleted = true
+                report.Deleted++
+            }
+        }
+        report.Orphans = append(report.Orphans, orphan)
+    }
+
+    return report, nil
+}
+
+// findOrphans returns the objects that have no known storage path and were
+// last modified at or before the cutoff.
+func findOrphans(objects []storage.Object, knownPaths map[string]bool, cutoff time.Time) []storage.Object {
+    var orphans []storage.Object
+    for _, object := range objects {
+        if knownPaths[object.Key] || object.LastModified.After(cutoff) {
+            continue
+        }
+        orphans = append(orphans, object)
+    }
+    return orphans
+}
+
+func GracePeriod() time.Duration {
+    value := os.Getenv("GC_GRACE_PERIOD")
+    if value == "" {
+        return defaultGracePeriod
+    }
+    grace, err := time.ParseDuration(value)
+    if err != nil {
+        log.Printf("Invalid GC_GRACE_PERIOD %q, using %s", value, defaultGracePeriod)
+        return defaultGracePeriod
+    }
+    if grace < MinGracePeriod {
+        log.Printf("GC_GRACE_PERIOD %q is below the minimum, using %s", value, MinGracePeriod)
+        return MinGracePeriod
+    }
+    return grace
+}
+
+// StartScheduler runs CollectOrphans every GC_INTERVAL. It does nothing when
+// GC_INTERVAL is unset, and only reports unless GC_DRY_RUN is set to false.
+func StartScheduler() {
+    value := os.Getenv("GC_INTERVAL")
+    if value == "" {
+        return
+    }
+    interval, err := time.ParseDuration(value)
+    if err != nil || interval <= 0 {
+        log.Printf("Invalid GC_INTERVAL %q, orphan collection disabled", value)
+        return
+    }
+
+    dryRun := true
+    if value := os.Getenv("GC_DRY_RUN"); value != "" {
+        if parsed, err := strconv.ParseBool(value); err == nil {
+            dryRun = parsed
+        }
+    }
+
+    go func() {
+        ticker := time.NewTicker(interval)
+        defer ticker.Stop()
+        for range ticker.C {
+            report, err := CollectOrphans(GracePeriod(), dryRun)
+            if err != nil {
+                log.Printf("Error collecting orphaned objects: %v", err)
+                continue
+            }
+            log.Printf("Orphan collection: scanned=%d orphans=%d deleted=%d dry_run=%v",
+                report.Scanned, len(report.Orphans), report.Deleted, report.DryRun)
+            for _, e := range report.Errors {
+                log.Printf("Orphan collection error: %s", e)
+            }
+        }
+    }()
+}
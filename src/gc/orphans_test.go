@@ -0,0 +1,57 @@
+package gc
+
+import (
+    "reflect"
+    "testing"
+    "time"
+    "github.com/grrywlsn/imagerr/src/storage"
+)
+
+func TestFindOrphans(t *testing.T) {
+    cutoff := time.Date(2024, time.March, 12, 9, 0, 0, 0, time.UTC)
+    objects := []storage.Object{
+        {Key: "images/known.jpg", LastModified: cutoff.Add(-time.Hour)},
+        {Key: "images/old.jpg", Size: 10, LastModified: cutoff.Add(-time.Hour)},
+        {Key: "images/at-cutoff.jpg", LastModified: cutoff},
+        {Key: "images/recent.jpg", LastModified: cutoff.Add(time.Second)},
+        {Key: "images/known-recent.jpg", LastModified: cutoff.Add(time.Hour)},
+    }
+    known := map[string]bool{
+        "images/known.jpg":        true,
+        "images/known-recent.jpg": true,
+        "images/missing.jpg":      true,
+    }
+
+    got := findOrphans(objects, known, cutoff)
+    want := []storage.Object{objects[1], objects[2]}
+    if !reflect.DeepEqual(got, want) {
+        t.Errorf("findOrphans = %+v, want %+v", got, want)
+    }
+
+    if got := findOrphans(nil, known, cutoff); len(got) != 0 {
+        t.Errorf("findOrphans(nil) = %+v, want none", got)
+    }
+    if got := findOrphans(objects, nil, cutoff.Add(2*time.Hour)); len(got) != len(objects) {
+        t.Errorf("findOrphans with no known paths = %d orphans, want %d", len(got), len(objects))
+    }
+}
+
+func TestGracePeriod(t *testing.T) {
+    for _, test := range []struct {
+        value string
+        want  time.Duration
+    }{
+        {"", defaultGracePeriod},
+        {"48h", 48 * time.Hour},
+        {"10m", MinGracePeriod},
+        {"0s", MinGracePeriod},
+        {"-1h", MinGracePeriod},
+        {"1m", MinGracePeriod},
+        {"soon", defaultGracePeriod},
+    } {
+        t.Setenv("GC_GRACE_PERIOD", test.value)
+        if got := GracePeriod(); got != test.want {
+            t.Errorf("GracePeriod() with %q = %s, want %s", test.value, got, test.want)
+        }
+    }
+}
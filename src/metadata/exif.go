@@ -0,0 +1,304 @@
+package metadata
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "io"
+    "strings"
+    "time"
+)
+
+// EXIF holds the capture details imagerr uses from an image's EXIF data.
+type EXIF struct {
+    Make      string
+    Model     string
+    TakenAt   *time.Time
+    Latitude  *float64
+    Longitude *float64
+}
+
+const (
+    tagMake             = 0x010f
+    tagModel            = 0x0110
+    tagDateTime         = 0x0132
+    tagExifIFD          = 0x8769
+    tagGPSIFD           = 0x8825
+    tagDateTimeOriginal = 0x9003
+    tagOffsetTimeOrig   = 0x9011
+
+    // Tags within the GPS IFD
+    tagGPSLatitudeRef  = 0x0001
+    tagGPSLatitude     = 0x0002
+    tagGPSLongitudeRef = 0x0003
+    tagGPSLongitude    = 0x0004
+
+    typeASCII    = 2
+    typeShort    = 3
+    typeLong     = 4
+    typeRational = 5
+
+    exifDateFormat = "2006:01:02 15:04:05"
+)
+
+var errInvalidEXIF = errors.New("invalid EXIF data")
+
+// ReadEXIF reads the EXIF block of a JPEG. It returns nil without an error
+// when the image is not a JPEG or carries no EXIF data.
+func ReadEXIF(r io.Reader) (*EXIF, error) {
+    segment, err := findEXIFSegment(bufio.NewReader(r))
+    if err != nil || segment == nil {
+        return nil, err
+    }
+    return parseTIFF(segment)
+}
+
+// findEXIFSegment walks the JPEG markers up to the start of the image data
+// and returns the TIFF payload of the APP1 Exif segment, if there is one.
+func findEXIFSegment(r *bufio.Reader) ([]byte, error) {
+    var soi [2]byte
+    if _, err := io.ReadFull(r, soi[:]); err != nil || soi != [2]byte{0xff, 0xd8} {
+        return nil, nil
+    }
+
+    for {
+        marker, err := r.ReadByte()
+        if err != nil {
+            return nil, nil
+        }
+        if marker != 0xff {
+            return nil, errInvalidEXIF
+        }
+        kind, err := r.ReadByte()
+        if err != nil {
+            return nil, nil
+        }
+        switch {
+        case kind == 0xff:
+            // Fill bytes may pad markers
+            r.UnreadByte()
+            continue
+        case kind == 0xd8 || (kind >= 0xd0 && kind <= 0xd7):
+            continue
+        case kind == 0xda || kind == 0xd9:
+            // Start of scan or end of image: no metadata follows
+            return nil, nil
+        }
+
+        var length uint16
+        if err := binary.Read(r, binary.BigEndian, &length); err != nil || length < 2 {
+            return nil, errInvalidEXIF
+        }
+        data := make([]byte, length-2)
+        if _, err := io.ReadFull(r, data); err != nil {
+            return nil, errInvalidEXIF
+        }
+        if kind == 0xe1 && bytes.HasPrefix(data, []byte("Exif\x00\x00")) {
+            return data[6:], nil
+        }
+    }
+}
+
+type ifdEntry struct {
+    tag   uint16
+    kind  uint16
+    count uint32
+    value []byte
+}
+
+func parseTIFF(data []byte) (*EXIF, error) {
+    if len(data) < 8 {
+        return nil, errInvalidEXIF
+    }
+    var order binary.ByteOrder
+    switch string(data[:2]) {
+    case "II":
+        order = binary.LittleEndian
+    case "MM":
+        order = binary.BigEndian
+    default:
+        return nil, errInvalidEXIF
+    }
+    if order.Uint16(data[2:4]) != 42 {
+        return nil, errInvalidEXIF
+    }
+
+    ifd0, err := readIFD(data, order, order.Uint32(data[4:8]))
+    if err != nil {
+        return nil, err
+    }
+
+    exif := &EXIF{}
+    var dateTime, dateTimeOriginal, offset string
+    for _, entry := range ifd0 {
+        switch entry.tag {
+        case tagMake:
+            exif.Make = asciiValue(entry)
+        case tagModel:
+            exif.Model = asciiValue(entry)
+        case tagDateTime:
+            dateTime = asciiValue(entry)
+        case tagExifIFD:
+            // A bad pointer loses the capture date, not the camera
+            sub, _ := readIFD(data, order, uintValue(entry, order))
+            for _, subEntry := range sub {
+                switch subEntry.tag {
+                case tagDateTimeOriginal:
+                    dateTimeOriginal = asciiValue(subEntry)
+                case tagOffsetTimeOrig:
+                    offset = asciiValue(subEntry)
+                }
+            }
+        case tagGPSIFD:
+            // A bad pointer means no location, not no metadata
+            if gps, err := readIFD(data, order, uintValue(entry, order)); err == nil {
+                exif.Latitude, exif.Longitude = parseGPS(gps, order)
+            }
+        }
+    }
+
+    if dateTimeOriginal == "" {
+        dateTimeOriginal = dateTime
+    }
+    exif.TakenAt = parseEXIFTime(dateTimeOriginal, offset)
+    return exif, nil
+}
+
+func readIFD(data []byte, order binary.ByteOrder, offset uint32) ([]ifdEntry, error) {
+    if uint64(offset)+2 > uint64(len(data)) {
+        return nil, errInvalidEXIF
+    }
+    count := int(order.Uint16(data[offset:]))
+    start := int(offset) + 2
+    if start+count*12 > len(data) {
+        return nil, errInvalidEXIF
+    }
+
+    entries := make([]ifdEntry, 0, count)
+    for i := 0; i < count; i++ {
+        raw := data[start+i*12 : start+(i+1)*12]
+        entry := ifdEntry{
+            tag:   order.Uint16(raw[0:2]),
+            kind:  order.Uint16(raw[2:4]),
+            count: order.Uint32(raw[4:8]),
+        }
+
+        size := uint64(entry.count) * uint64(typeSize(entry.kind))
+        if size <= 4 {
+            entry.value = raw[8 : 8+size]
+        } else {
+            valueOffset := uint64(order.Uint32(raw[8:12]))
+            if valueOffset+size > uint64(len(data)) {
+                // Skip entries pointing outside the segment rather than
+                // rejecting the rest of the metadata
+                continue
+            }
+            entry.value = data[valueOffset : valueOffset+size]
+        }
+        entries = append(entries, entry)
+    }
+    return entries, nil
+}
+
+func typeSize(kind uint16) int {
+    switch kind {
+    case 1, 2, 6, 7:
+        return 1
+    case 3, 8:
+        return 2
+    case 4, 9, 11:
+        return 4
+    case 5, 10, 12:
+        return 8
+    }
+    return 0
+}
+
+func asciiValue(entry ifdEntry) string {
+    if entry.kind != typeASCII {
+        return ""
+    }
+    return strings.TrimSpace(strings.TrimRight(string(entry.value), "\x00"))
+}
+
+func uintValue(entry ifdEntry, order binary.ByteOrder) uint32 {
+    switch {
+    case entry.kind == typeShort && len(entry.value) >= 2:
+        return uint32(order.Uint16(entry.value))
+    case entry.kind == typeLong && len(entry.value) >= 4:
+        return order.Uint32(entry.value)
+    }
+    return 0
+}
+
+// parseGPS reads the position from the GPS IFD entries. Coordinates are
+// stored as degrees, minutes and seconds with a hemisphere reference, and
+// are only returned when both are present and in range.
+func parseGPS(entries []ifdEntry, order binary.ByteOrder) (*float64, *float64) {
+    var latRef, lonRef string
+    var lat, lon []float64
+    for _, entry := range entries {
+        switch entry.tag {
+        case tagGPSLatitudeRef:
+            latRef = asciiValue(entry)
+        case tagGPSLatitude:
+            lat = rationalValues(entry, order)
+        case tagGPSLongitudeRef:
+            lonRef = asciiValue(entry)
+        case tagGPSLongitude:
+            lon = rationalValues(entry, order)
+        }
+    }
+    if len(lat) != 3 || len(lon) != 3 {
+        return nil, nil
+    }
+
+    latitude := lat[0] + lat[1]/60 + lat[2]/3600
+    if latRef == "S" {
+        latitude = -latitude
+    }
+    longitude := lon[0] + lon[1]/60 + lon[2]/3600
+    if lonRef == "W" {
+        longitude = -longitude
+    }
+    if latitude < -90 || latitude > 90 || longitude < -180 || longitude > 180 {
+        return nil, nil
+    }
+    return &latitude, &longitude
+}
+
+func rationalValues(entry ifdEntry, order binary.ByteOrder) []float64 {
+    if entry.kind != typeRational {
+        return nil
+    }
+    var values []float64
+    for i := 0; i+8 <= len(entry.value); i += 8 {
+        numerator := order.Uint32(entry.value[i:])
+        denominator := order.Uint32(entry.value[i+4:])
+        if denominator == 0 {
+            return nil
+        }
+        values = append(values, float64(numerator)/float64(denominator))
+    }
+    return values
+}
+
+// parseEXIFTime reads an EXIF date, which has no zone unless the matching
+// offset tag is present. Dates without one are treated as UTC.
+func parseEXIFTime(value, offset string) *time.Time {
+    if value == "" {
+        return nil
+    }
+    layout, input := exifDateFormat, value
+    if offset != "" {
+        layout, input = exifDateFormat+"-07:00", value+offset
+    }
+    t, err := time.Parse(layout, input)
+    if err != nil {
+        if t, err = time.Parse(exifDateFormat, value); err != nil {
+            return nil
+        }
+    }
+    return &t
+}
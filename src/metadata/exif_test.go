@@ -0,0 +1,384 @@
+package metadata
+
+import (
+    "bytes"
+    "encoding/binary"
+    "testing"
+    "time"
+)
+
+// tiffEntry describes an IFD entry for encodeTIFF. Values of more than
+// four bytes and sub IFDs are written after the IFD and pointed to, unless
+// offset overrides the pointer.
+type tiffEntry struct {
+    tag    uint16
+    kind   uint16
+    count  uint32
+    value  []byte
+    ifd    []tiffEntry
+    offset uint32
+}
+
+func asciiEntry(tag uint16, value string) tiffEntry {
+    return tiffEntry{tag: tag, kind: typeASCII, count: uint32(len(value) + 1), value: []byte(value + "\x00")}
+}
+
+func rationalEntry(order binary.ByteOrder, tag uint16, values ...[2]uint32) tiffEntry {
+    value := make([]byte, 8*len(values))
+    for i, v := range values {
+        order.PutUint32(value[i*8:], v[0])
+        order.PutUint32(value[i*8+4:], v[1])
+    }
+    return tiffEntry{tag: tag, kind: typeRational, count: uint32(len(values)), value: value}
+}
+
+func ifdEntryTo(tag uint16, entries ...tiffEntry) tiffEntry {
+    return tiffEntry{tag: tag, kind: typeLong, count: 1, ifd: entries}
+}
+
+func encodeTIFF(order binary.ByteOrder, entries ...tiffEntry) []byte {
+    data := []byte("MM\x00\x00\x00\x00\x00\x00")
+    if order == binary.LittleEndian {
+        copy(data, "II")
+    }
+    order.PutUint16(data[2:], 42)
+    order.PutUint32(data[4:], 8)
+    return appendIFD(data, order, entries)
+}
+
+func appendIFD(data []byte, order binary.ByteOrder, entries []tiffEntry) []byte {
+    start := len(data)
+    data = append(data, make([]byte, 2+12*len(entries)+4)...)
+    order.PutUint16(data[start:], uint16(len(entries)))
+    for i, entry := range entries {
+        raw := start + 2 + 12*i
+        order.PutUint16(data[raw:], entry.tag)
+        order.PutUint16(data[raw+2:], entry.kind)
+        order.PutUint32(data[raw+4:], entry.count)
+        switch {
+        case entry.ifd != nil:
+            order.PutUint32(data[raw+8:], uint32(len(data)))
+            data = appendIFD(data, order, entry.ifd)
+        case len(entry.value) <= 4:
+            copy(data[raw+8:raw+12], entry.value)
+        default:
+            order.PutUint32(data[raw+8:], uint32(len(data)))
+            data = append(data, entry.value...)
+        }
+        if entry.offset != 0 {
+            order.PutUint32(data[raw+8:], entry.offset)
+        }
+    }
+    return data
+}
+
+// encodeJPEG wraps a TIFF block in an APP1 Exif segment between a JPEG's
+// start of image and start of scan markers.
+func encodeJPEG(tiff []byte) []byte {
+    segment := append([]byte("Exif\x00\x00"), tiff...)
+    data := []byte{0xff, 0xd8, 0xff, 0xe0, 0x00, 0x04, 0x00, 0x00, 0xff, 0xe1}
+    data = binary.BigEndian.AppendUint16(data, uint16(len(segment)+2))
+    data = append(data, segment...)
+    return append(data, 0xff, 0xda, 0x00, 0x02)
+}
+
+func sampleTIFF(order binary.ByteOrder) []byte {
+    return encodeTIFF(order,
+        asciiEntry(tagMake, "FUJIFILM"),
+        asciiEntry(tagModel, "X-T4"),
+        asciiEntry(tagDateTime, "2024:03:14 18:30:05"),
+        ifdEntryTo(tagExifIFD,
+            asciiEntry(tagDateTimeOriginal, "2024:03:12 09:15:00"),
+            asciiEntry(tagOffsetTimeOrig, "+01:00"),
+        ),
+    )
+}
+
+func TestReadEXIFByteOrders(t *testing.T) {
+    want := time.Date(2024, time.March, 12, 8, 15, 0, 0, time.UTC)
+    for name, order := range map[string]binary.ByteOrder{"II": binary.LittleEndian, "MM": binary.BigEndian} {
+        exif, err := ReadEXIF(bytes.NewReader(encodeJPEG(sampleTIFF(order))))
+        if err != nil {
+            t.Fatalf("%s: ReadEXIF: %v", name, err)
+        }
+        if exif == nil {
+            t.Fatalf("%s: ReadEXIF found no EXIF data", name)
+        }
+        if exif.Make != "FUJIFILM" || exif.Model != "X-T4" {
+            t.Errorf("%s: got camera %q %q, want FUJIFILM X-T4", name, exif.Make, exif.Model)
+        }
+        if exif.TakenAt == nil || !exif.TakenAt.Equal(want) {
+            t.Errorf("%s: got taken at %v, want %v", name, exif.TakenAt, want)
+        }
+    }
+}
+
+func TestReadEXIFWithoutEXIF(t *testing.T) {
+    for name, data := range map[string][]byte{
+        "empty":        nil,
+        "png":          []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR"),
+        "soi only":     {0xff, 0xd8},
+        "no app1":      {0xff, 0xd8, 0xff, 0xe0, 0x00, 0x04, 0x00, 0x00, 0xff, 0xda},
+        "end of image": {0xff, 0xd8, 0xff, 0xd9},
+        "fill bytes":   {0xff, 0xd8, 0xff, 0xff, 0xff, 0xda},
+        "other app1":   {0xff, 0xd8, 0xff, 0xe1, 0x00, 0x06, 'h', 't', 't', 'p', 0xff, 0xda},
+    } {
+        exif, err := ReadEXIF(bytes.NewReader(data))
+        if exif != nil || err != nil {
+            t.Errorf("%s: got %+v, %v, want no EXIF data and no error", name, exif, err)
+        }
+    }
+}
+
+func TestReadEXIFTruncated(t *testing.T) {
+    full := encodeJPEG(sampleTIFF(binary.BigEndian))
+    for _, test := range []struct {
+        name string
+        data []byte
+    }{
+        {"segment length cut", full[:11]},
+        {"segment body cut", full[:30]},
+        {"segment length below 2", []byte{0xff, 0xd8, 0xff, 0xe1, 0x00, 0x01}},
+        {"not a marker", []byte{0xff, 0xd8, 0x12, 0x34}},
+        {"short TIFF header", encodeJPEG([]byte("MM\x00\x2a"))},
+        {"IFD count past end", encodeJPEG(sampleTIFF(binary.BigEndian)[:12])},
+    } {
+        if exif, err := ReadEXIF(bytes.NewReader(test.data)); err == nil {
+            t.Errorf("%s: got %+v, want an error", test.name, exif)
+        }
+    }
+}
+
+func TestParseTIFFHeader(t *testing.T) {
+    valid := sampleTIFF(binary.LittleEndian)
+    for name, mutate := range map[string]func([]byte){
+        "byte order":  func(data []byte) { copy(data, "XX") },
+        "mixed order": func(data []byte) { copy(data, "IM") },
+        "magic":       func(data []byte) { data[2] = 43 },
+        "magic in the other order": func(data []byte) {
+            copy(data, "MM")
+        },
+    } {
+        data := append([]byte(nil), valid...)
+        mutate(data)
+        if exif, err := parseTIFF(data); err == nil {
+            t.Errorf("%s: got %+v, want an error", name, exif)
+        }
+    }
+}
+
+func TestParseTIFFOffsets(t *testing.T) {
+    order := binary.BigEndian
+
+    t.Run("IFD0 past end", func(t *testing.T) {
+        data := sampleTIFF(order)
+        order.PutUint32(data[4:], uint32(len(data)))
+        if _, err := parseTIFF(data); err == nil {
+            t.Error("got no error")
+        }
+    })
+
+    t.Run("IFD0 offset overflows", func(t *testing.T) {
+        data := sampleTIFF(order)
+        order.PutUint32(data[4:], 0xffffffff)
+        if _, err := parseTIFF(data); err == nil {
+            t.Error("got no error")
+        }
+    })
+
+    t.Run("value past end skips the entry", func(t *testing.T) {
+        model := asciiEntry(tagModel, "X-T4 with a long name")
+        model.offset = 0xfffffff0
+        exif, err := parseTIFF(encodeTIFF(order, asciiEntry(tagMake, "FUJIFILM"), model))
+        if err != nil {
+            t.Fatalf("parseTIFF: %v", err)
+        }
+        if exif.Make != "FUJIFILM" || exif.Model != "" {
+            t.Errorf("got camera %q %q, want FUJIFILM and no model", exif.Make, exif.Model)
+        }
+    })
+
+    t.Run("Exif IFD past end keeps the camera", func(t *testing.T) {
+        sub := ifdEntryTo(tagExifIFD, asciiEntry(tagDateTimeOriginal, "2024:03:12 09:15:00"))
+        sub.offset = 0x7fffffff
+        exif, err := parseTIFF(encodeTIFF(order, asciiEntry(tagMake, "FUJIFILM"), sub))
+        if err != nil {
+            t.Fatalf("parseTIFF: %v", err)
+        }
+        if exif.Make != "FUJIFILM" || exif.TakenAt != nil {
+            t.Errorf("got make %q and taken at %v, want FUJIFILM and no date", exif.Make, exif.TakenAt)
+        }
+    })
+
+    t.Run("wrong types are ignored", func(t *testing.T) {
+        exif, err := parseTIFF(encodeTIFF(order,
+            tiffEntry{tag: tagMake, kind: typeShort, count: 1, value: []byte{0, 1}},
+            tiffEntry{tag: tagExifIFD, kind: typeASCII, count: 2, value: []byte("x\x00")},
+        ))
+        if err != nil {
+            t.Fatalf("parseTIFF: %v", err)
+        }
+        if exif.Make != "" || exif.TakenAt != nil {
+            t.Errorf("got %+v, want nothing read", exif)
+        }
+    })
+}
+
+func TestParseEXIFTime(t *testing.T) {
+    for _, test := range []struct {
+        value, offset string
+        want          *time.Time
+    }{
+        {"", "", nil},
+        {"2024:03:12 09:15:00", "", ptr(time.Date(2024, time.March, 12, 9, 15, 0, 0, time.UTC))},
+        {"2024:03:12 09:15:00", "-05:00", ptr(time.Date(2024, time.March, 12, 14, 15, 0, 0, time.UTC))},
+        // A bad offset falls back to UTC
+        {"2024:03:12 09:15:00", "bogus", ptr(time.Date(2024, time.March, 12, 9, 15, 0, 0, time.UTC))},
+        {"0000:00:00 00:00:00", "", nil},
+        {"2024-03-12T09:15:00", "", nil},
+    } {
+        got := parseEXIFTime(test.value, test.offset)
+        if (got == nil) != (test.want == nil) || (got != nil && !got.Equal(*test.want)) {
+            t.Errorf("parseEXIFTime(%q, %q) = %v, want %v", test.value, test.offset, got, test.want)
+        }
+    }
+}
+
+func ptr[T any](v T) *T {
+    return &v
+}
+
+func FuzzParseTIFF(f *testing.F) {
+    f.Add(sampleTIFF(binary.LittleEndian))
+    f.Add(sampleTIFF(binary.BigEndian))
+    f.Add([]byte("II\x2a\x00\x08\x00\x00\x00"))
+    f.Add([]byte("MM\x00\x2a\x00\x00\x00\x08\xff\xff"))
+    f.Fuzz(func(t *testing.T, data []byte) {
+        exif, err := parseTIFF(data)
+        if err != nil {
+            return
+        }
+        if exif == nil {
+            t.Fatal("parseTIFF returned neither EXIF data nor an error")
+        }
+        if (exif.Latitude == nil) != (exif.Longitude == nil) {
+            t.Fatalf("got only one coordinate: %v, %v", exif.Latitude, exif.Longitude)
+        }
+        if exif.Latitude != nil && (*exif.Latitude < -90 || *exif.Latitude > 90 ||
+            *exif.Longitude < -180 || *exif.Longitude > 180) {
+            t.Fatalf("coordinates out of range: %f, %f", *exif.Latitude, *exif.Longitude)
+        }
+    })
+}
+
+func gpsEntries(order binary.ByteOrder, latRef string, lat [3][2]uint32, lonRef string, lon [3][2]uint32) []ifdEntry {
+    entries, err := readIFD(encodeTIFF(order,
+        asciiEntry(tagGPSLatitudeRef, latRef),
+        rationalEntry(order, tagGPSLatitude, lat[0], lat[1], lat[2]),
+        asciiEntry(tagGPSLongitudeRef, lonRef),
+        rationalEntry(order, tagGPSLongitude, lon[0], lon[1], lon[2]),
+    ), order, 8)
+    if err != nil {
+        panic(err)
+    }
+    return entries
+}
+
+func TestParseGPS(t *testing.T) {
+    sydneyLat := [3][2]uint32{{33, 1}, {51, 1}, {2988, 100}}
+    sydneyLon := [3][2]uint32{{151, 1}, {12, 1}, {0, 1}}
+    big, little := binary.BigEndian, binary.LittleEndian
+    for _, test := range []struct {
+        name             string
+        order            binary.ByteOrder
+        latRef, lonRef   string
+        lat, lon         [3][2]uint32
+        wantLat, wantLon float64
+        wantNone         bool
+    }{
+        {"south east", big, "S", "E", sydneyLat, sydneyLon, -33.8583, 151.2, false},
+        {"north west", little, "N", "W", sydneyLat, sydneyLon, 33.8583, -151.2, false},
+        {"no references", big, "", "", sydneyLat, sydneyLon, 33.8583, 151.2, false},
+        {"latitude out of range", big, "N", "E", [3][2]uint32{{91, 1}, {0, 1}, {0, 1}}, sydneyLon, 0, 0, true},
+        {"longitude out of range", big, "N", "W", sydneyLat, [3][2]uint32{{180, 1}, {30, 1}, {0, 1}}, 0, 0, true},
+        {"zero denominator", little, "N", "E", [3][2]uint32{{33, 0}, {51, 1}, {0, 1}}, sydneyLon, 0, 0, true},
+    } {
+        lat, lon := parseGPS(gpsEntries(test.order, test.latRef, test.lat, test.lonRef, test.lon), test.order)
+        if test.wantNone {
+            if lat != nil || lon != nil {
+                t.Errorf("%s: got %v, %v, want no position", test.name, *lat, *lon)
+            }
+            continue
+        }
+        if lat == nil || lon == nil {
+            t.Errorf("%s: got no position", test.name)
+            continue
+        }
+        if diff := *lat - test.wantLat; diff > 1e-4 || diff < -1e-4 {
+            t.Errorf("%s: got latitude %f, want %f", test.name, *lat, test.wantLat)
+        }
+        if diff := *lon - test.wantLon; diff > 1e-4 || diff < -1e-4 {
+            t.Errorf("%s: got longitude %f, want %f", test.name, *lon, test.wantLon)
+        }
+    }
+
+    if lat, lon := parseGPS(nil, big); lat != nil || lon != nil {
+        t.Errorf("got a position from no entries")
+    }
+}
+
+func TestParseGPSNeedsThreeValues(t *testing.T) {
+    order := binary.BigEndian
+    entries, err := readIFD(encodeTIFF(order,
+        rationalEntry(order, tagGPSLatitude, [2]uint32{33, 1}, [2]uint32{51, 1}),
+        rationalEntry(order, tagGPSLongitude, [2]uint32{151, 1}, [2]uint32{12, 1}, [2]uint32{0, 1}),
+    ), order, 8)
+    if err != nil {
+        t.Fatalf("readIFD: %v", err)
+    }
+    if lat, lon := parseGPS(entries, order); lat != nil || lon != nil {
+        t.Errorf("got a position from two latitude values")
+    }
+}
+
+func TestParseTIFFBadGPSPointer(t *testing.T) {
+    order := binary.LittleEndian
+    gps := ifdEntryTo(tagGPSIFD, asciiEntry(tagGPSLatitudeRef, "N"))
+    gps.offset = 0x7fffffff
+    exif, err := parseTIFF(encodeTIFF(order,
+        asciiEntry(tagMake, "FUJIFILM"),
+        asciiEntry(tagDateTime, "2024:03:14 18:30:05"),
+        gps,
+    ))
+    if err != nil {
+        t.Fatalf("parseTIFF: %v", err)
+    }
+    if exif.Make != "FUJIFILM" || exif.TakenAt == nil {
+        t.Errorf("got %+v, want the make and date kept", exif)
+    }
+    if exif.Latitude != nil || exif.Longitude != nil {
+        t.Errorf("got a position from a bad GPS pointer")
+    }
+}
+
+func TestParseTIFFGPS(t *testing.T) {
+    order := binary.BigEndian
+    exif, err := parseTIFF(encodeTIFF(order,
+        asciiEntry(tagMake, "Apple"),
+        ifdEntryTo(tagGPSIFD,
+            asciiEntry(tagGPSLatitudeRef, "S"),
+            rationalEntry(order, tagGPSLatitude, [2]uint32{33, 1}, [2]uint32{51, 1}, [2]uint32{2988, 100}),
+            asciiEntry(tagGPSLongitudeRef, "E"),
+            rationalEntry(order, tagGPSLongitude, [2]uint32{151, 1}, [2]uint32{12, 1}, [2]uint32{0, 1}),
+        ),
+    ))
+    if err != nil {
+        t.Fatalf("parseTIFF: %v", err)
+    }
+    if exif.Latitude == nil || exif.Longitude == nil {
+        t.Fatal("got no position")
+    }
+    if *exif.Latitude > -33.858 || *exif.Latitude < -33.859 || *exif.Longitude != 151.2 {
+        t.Errorf("got %f, %f, want -33.8583, 151.2", *exif.Latitude, *exif.Longitude)
+    }
+}
@@ -0,0 +1,150 @@
+package metadata
+
+import (
+    "log"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "time"
+    "unicode"
+)
+
+// Where a suggested tag came from. AUTO_TAG_SOURCES lists the sources whose
+// suggestions are applied to uploads without asking.
+const (
+    SourceFilename = "filename"
+    SourceDate     = "date"
+    SourceCamera   = "camera"
+)
+
+const (
+    minFilenameToken = 3
+    // Four digit filename tokens in this range are taken to be years
+    minFilenameYear = 1900
+)
+
+// Words that camera, phone and screenshot tools put in filenames and which
+// say nothing about the picture.
+var filenameStopwords = map[string]bool{
+    "img": true, "image": true, "dsc": true, "dscn": true, "dscf": true, "pxl": true,
+    "photo": true, "pic": true, "picture": true, "screenshot": true, "screen": true,
+    "shot": true, "scan": true, "copy": true, "edit": true, "edited": true,
+    "final": true, "untitled": true, "export": true, "resized": true, "the": true,
+    "and": true, "with": true, "jpg": true, "jpeg": true, "png": true, "heic": true,
+}
+
+// Suggestion is a tag proposed for an upload.
+type Suggestion struct {
+    Tag    string `json:"tag"`
+    Source string `json:"source"`
+}
+
+// SuggestTags proposes tags from the words and year of the original
+// filename and, when exif is not nil, the capture year and camera. A year
+// in the filename that matches the capture year is suggested once, from
+// the date.
+func SuggestTags(filename string, exif *EXIF) []Suggestion {
+    var takenYear string
+    if exif != nil && exif.TakenAt != nil {
+        takenYear = yearTag(exif.TakenAt.Year())
+    }
+
+    var suggestions []Suggestion
+    for _, token := range filenameTokens(filename) {
+        if token != takenYear {
+            suggestions = append(suggestions, Suggestion{Tag: token, Source: SourceFilename})
+        }
+    }
+    if exif == nil {
+        return suggestions
+    }
+
+    if takenYear != "" {
+        suggestions = append(suggestions, Suggestion{Tag: takenYear, Source: SourceDate})
+    }
+    if camera := cameraName(exif.Make, exif.Model); camera != "" {
+        suggestions = append(suggestions, Suggestion{Tag: "camera:" + camera, Source: SourceCamera})
+    }
+    return suggestions
+}
+
+func yearTag(year int) string {
+    return "year:" + strconv.Itoa(year)
+}
+
+// filenameTokens returns the descriptive words of a filename and any year
+// in it as a year tag, dropping counters, dates and other tokens that mix
+// letters and digits.
+func filenameTokens(filename string) []string {
+    name := strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+    words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+        return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+    })
+
+    seen := make(map[string]bool)
+    var tokens []string
+    for _, word := range words {
+        if year, ok := filenameYear(word); ok {
+            word = yearTag(year)
+        } else if len([]rune(word)) < minFilenameToken || filenameStopwords[word] || hasDigit(word) {
+            continue
+        }
+        if !seen[word] {
+            seen[word] = true
+            tokens = append(tokens, word)
+        }
+    }
+    return tokens
+}
+
+// filenameYear reports whether a word is a four digit year between
+// minFilenameYear and next year.
+func filenameYear(word string) (int, bool) {
+    if len(word) != 4 {
+        return 0, false
+    }
+    year, err := strconv.Atoi(word)
+    if err != nil || year < minFilenameYear || year > time.Now().Year()+1 {
+        return 0, false
+    }
+    return year, true
+}
+
+func hasDigit(word string) bool {
+    return strings.IndexFunc(word, unicode.IsDigit) >= 0
+}
+
+// cameraName combines make and model, skipping the make when the model
+// already starts with it as most manufacturers' models do.
+func cameraName(maker, model string) string {
+    maker, model = strings.TrimSpace(maker), strings.TrimSpace(model)
+    if model == "" {
+        return maker
+    }
+    brand := strings.Fields(maker)
+    if len(brand) == 0 || strings.HasPrefix(strings.ToLower(model), strings.ToLower(brand[0])) {
+        return model
+    }
+    return brand[0] + " " + model
+}
+
+// AutoTagSources returns the suggestion sources set in AUTO_TAG_SOURCES,
+// a comma separated list of filename, date and camera, or "all".
+func AutoTagSources() map[string]bool {
+    sources := make(map[string]bool)
+    for _, source := range strings.Split(os.Getenv("AUTO_TAG_SOURCES"), ",") {
+        switch source = strings.TrimSpace(strings.ToLower(source)); source {
+        case "":
+        case "all":
+            sources[SourceFilename] = true
+            sources[SourceDate] = true
+            sources[SourceCamera] = true
+        case SourceFilename, SourceDate, SourceCamera:
+            sources[source] = true
+        default:
+            log.Printf("Ignoring unknown AUTO_TAG_SOURCES entry %q", source)
+        }
+    }
+    return sources
+}
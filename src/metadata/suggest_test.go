@@ -0,0 +1,105 @@
+package metadata
+
+import (
+    "reflect"
+    "testing"
+    "time"
+)
+
+func TestFilenameTokens(t *testing.T) {
+    for _, test := range []struct {
+        filename string
+        want     []string
+    }{
+        {"berlin-2024-wall.jpg", []string{"berlin", "year:2024", "wall"}},
+        {"IMG_20240312_091500.jpg", nil},
+        {"DSC01234.JPG", nil},
+        {"Screenshot 2023-11-02 at 10.15.00.png", []string{"year:2023"}},
+        {"holiday_photo_copy_final.heic", []string{"holiday"}},
+        {"Paris paris PARIS.png", []string{"paris"}},
+        {"1999 2024 1999.jpg", []string{"year:1999", "year:2024"}},
+        // Too early, too late or not four digits
+        {"1850-9999-0042-12345.jpg", nil},
+        {"sunset4k.jpg", nil},
+        {"ox-cat-dog.jpg", []string{"cat", "dog"}},
+        {"/uploads/2024/lake.tar.gz", []string{"lake", "tar"}},
+        {"über_straße.jpg", []string{"über", "straße"}},
+    } {
+        got := filenameTokens(test.filename)
+        if len(got) == 0 && len(test.want) == 0 {
+            continue
+        }
+        if !reflect.DeepEqual(got, test.want) {
+            t.Errorf("filenameTokens(%q) = %q, want %q", test.filename, got, test.want)
+        }
+    }
+}
+
+func TestSuggestTags(t *testing.T) {
+    taken := time.Date(2024, time.March, 12, 9, 15, 0, 0, time.UTC)
+    for _, test := range []struct {
+        name     string
+        filename string
+        exif     *EXIF
+        want     []Suggestion
+    }{
+        {
+            name:     "filename only",
+            filename: "berlin-2024-wall.jpg",
+            want: []Suggestion{
+                {"berlin", SourceFilename},
+                {"year:2024", SourceFilename},
+                {"wall", SourceFilename},
+            },
+        },
+        {
+            name:     "same year from the date",
+            filename: "berlin-2024-wall.jpg",
+            exif:     &EXIF{Make: "Canon", Model: "Canon EOS R5", TakenAt: &taken},
+            want: []Suggestion{
+                {"berlin", SourceFilename},
+                {"wall", SourceFilename},
+                {"year:2024", SourceDate},
+                {"camera:Canon EOS R5", SourceCamera},
+            },
+        },
+        {
+            name:     "different years",
+            filename: "berlin-2019.jpg",
+            exif:     &EXIF{TakenAt: &taken},
+            want: []Suggestion{
+                {"berlin", SourceFilename},
+                {"year:2019", SourceFilename},
+                {"year:2024", SourceDate},
+            },
+        },
+        {
+            name:     "camera without date",
+            filename: "IMG_0042.jpg",
+            exif:     &EXIF{Make: "Apple", Model: "iPhone 15 Pro"},
+            want:     []Suggestion{{"camera:Apple iPhone 15 Pro", SourceCamera}},
+        },
+    } {
+        if got := SuggestTags(test.filename, test.exif); !reflect.DeepEqual(got, test.want) {
+            t.Errorf("%s: SuggestTags(%q) = %v, want %v", test.name, test.filename, got, test.want)
+        }
+    }
+}
+
+func TestCameraName(t *testing.T) {
+    for _, test := range []struct {
+        maker, model, want string
+    }{
+        {"Canon", "Canon EOS R5", "Canon EOS R5"},
+        {"NIKON CORPORATION", "NIKON Z 6", "NIKON Z 6"},
+        {"FUJIFILM", "X-T4", "FUJIFILM X-T4"},
+        {"Apple", "iPhone 15 Pro", "Apple iPhone 15 Pro"},
+        {" SONY ", "", "SONY"},
+        {"", "ILCE-7M3", "ILCE-7M3"},
+        {"", "", ""},
+    } {
+        if got := cameraName(test.maker, test.model); got != test.want {
+            t.Errorf("cameraName(%q, %q) = %q, want %q", test.maker, test.model, got, test.want)
+        }
+    }
+}
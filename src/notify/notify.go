@@ -0,0 +1,131 @@
+package notify
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "net"
+    "net/http"
+    "net/url"
+    "syscall"
+    "time"
+    "github.com/grrywlsn/imagerr/src/db"
+    "github.com/grrywlsn/imagerr/src/search"
+)
+
+const webhookTimeout = 10 * time.Second
+
+// webhookClient refuses to connect to internal addresses. Checking at dial
+// time covers redirects and hosts whose DNS changes after the URL was
+// validated.
+var webhookClient = &http.Client{
+    Timeout: webhookTimeout,
+    Transport: &http.Transport{
+        DialContext: (&net.Dialer{
+            Timeout: webhookTimeout,
+            Control: func(network, address string, _ syscall.RawConn) error {
+                host, _, err := net.SplitHostPort(address)
+                if err != nil {
+                    return err
+                }
+                if ip := net.ParseIP(host); ip == nil || internalAddress(ip) {
+                    return fmt.Errorf("%w: %s", ErrInternalAddress, host)
+                }
+                return nil
+            },
+        }).DialContext,
+        TLSHandshakeTimeout: webhookTimeout,
+    },
+}
+
+// ErrInternalAddress is returned for webhooks pointing at loopback,
+// private or link-local addresses, which would let anyone who can save a
+// search make the server send requests into its own network.
+var ErrInternalAddress = errors.New("webhook host is an internal address")
+
+func internalAddress(ip net.IP) bool {
+    return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+        ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified()
+}
+
+// ValidateWebhookURL checks that a webhook URL is http or https and that
+// every address its host resolves to is public.
+func ValidateWebhookURL(ctx context.Context, raw string) error {
+    u, err := url.Parse(raw)
+    if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+        return errors.New("webhook URL must be an http or https URL")
+    }
+
+    addresses, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+    if err != nil {
+        return fmt.Errorf("webhook host can't be resolved: %v", err)
+    }
+    for _, address := range addresses {
+        if internalAddress(address.IP) {
+            return fmt.Errorf("%w: %s", ErrInternalAddress, address.IP)
+        }
+    }
+    return nil
+}
+
+// WebhookPayload is posted as JSON to a saved search's webhook URL when a
+// new upload matches it.
+type WebhookPayload struct {
+    Event       string          `json:"event"`
+    SavedSearch savedSearchInfo `json:"saved_search"`
+    Image       *db.Image       `json:"image"`
+}
+
+type savedSearchInfo struct {
+    ID   int64  `json:"id"`
+    Name string `json:"name"`
+}
+
+// NewImage tells the saved searches matching a newly uploaded image about
+// it, adding it to their notification feed and calling their webhooks.
+// Failures are logged, as the upload itself has already succeeded.
+func NewImage(image *db.Image) {
+    matched, err := search.MatchingSavedSearches(image)
+    if err != nil {
+        log.Printf("Error matching image %d against saved searches: %v", image.ID, err)
+        return
+    }
+
+    for _, saved := range matched {
+        if saved.Notify {
+            if _, err := db.CreateNotification(saved.ID, image.ID); err != nil {
+                log.Printf("Error recording notification for saved search %d: %v", saved.ID, err)
+            }
+        }
+        if saved.WebhookURL != "" {
+            if err := postWebhook(saved, image); err != nil {
+                log.Printf("Error calling webhook for saved search %d: %v", saved.ID, err)
+            }
+        }
+    }
+}
+
+func postWebhook(saved db.SavedSearch, image *db.Image) error {
+    body, err := json.Marshal(WebhookPayload{
+        Event:       "saved_search.match",
+        SavedSearch: savedSearchInfo{ID: saved.ID, Name: saved.Name},
+        Image:       image,
+    })
+    if err != nil {
+        return err
+    }
+
+    res, err := webhookClient.Post(saved.WebhookURL, "application/json", bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    defer res.Body.Close()
+
+    if res.StatusCode >= 300 {
+        return fmt.Errorf("webhook returned %s", res.Status)
+    }
+    return nil
+}
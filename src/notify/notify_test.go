@@ -0,0 +1,62 @@
+package notify
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "github.com/grrywlsn/imagerr/src/db"
+)
+
+func TestValidateWebhookURL(t *testing.T) {
+    for _, test := range []struct {
+        url      string
+        internal bool
+    }{
+        {"http://127.0.0.1/hook", true},
+        {"http://127.0.0.1:8080/hook", true},
+        {"http://[::1]/hook", true},
+        {"http://10.1.2.3/hook", true},
+        {"https://172.16.0.1/hook", true},
+        {"http://192.168.1.10/hook", true},
+        {"http://169.254.169.254/latest/meta-data/", true},
+        {"http://[fe80::1]/hook", true},
+        {"http://0.0.0.0/hook", true},
+        {"http://[::ffff:127.0.0.1]/hook", true},
+        {"https://93.184.215.14/hook", false},
+        {"https://[2606:4700::1111]/hook", false},
+    } {
+        err := ValidateWebhookURL(context.Background(), test.url)
+        if got := errors.Is(err, ErrInternalAddress); got != test.internal {
+            t.Errorf("ValidateWebhookURL(%q) = %v, want internal %v", test.url, err, test.internal)
+        }
+        if !test.internal && err != nil {
+            t.Errorf("ValidateWebhookURL(%q) = %v, want nil", test.url, err)
+        }
+    }
+}
+
+func TestValidateWebhookURLRejectsOtherSchemes(t *testing.T) {
+    for _, url := range []string{"", "ftp://93.184.215.14/", "file:///etc/passwd", "https://", "not a url"} {
+        if err := ValidateWebhookURL(context.Background(), url); err == nil {
+            t.Errorf("ValidateWebhookURL(%q) succeeded", url)
+        }
+    }
+}
+
+func TestPostWebhookRefusesLoopback(t *testing.T) {
+    called := false
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        called = true
+    }))
+    defer server.Close()
+
+    err := postWebhook(db.SavedSearch{ID: 1, Name: "test", WebhookURL: server.URL}, &db.Image{ID: 2})
+    if !errors.Is(err, ErrInternalAddress) {
+        t.Errorf("postWebhook to %s: got %v, want ErrInternalAddress", server.URL, err)
+    }
+    if called {
+        t.Error("webhook on a loopback address was called")
+    }
+}
@@ -0,0 +1,351 @@
+package search
+
+import (
+    "errors"
+    "log"
+    "os"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+    "github.com/grrywlsn/imagerr/src/db"
+)
+
+const (
+    healthCheckInterval = 30 * time.Second
+    healthCheckTimeout  = 2 * time.Second
+    suggestLimit        = 10
+
+    // Searches returning fewer results than this may include a spelling
+    // suggestion for the query.
+    sparseResultCount = 3
+)
+
+// Backend is implemented by each search engine imagerr can query.
+type Backend interface {
+    Name() string
+    Ping() error
+    SearchImages(params SearchParams) ([]SearchResult, error)
+    DidYouMean(q string) (string, error)
+    SuggestTags(query string, recent []string) ([]string, error)
+    RelatedTags(tags []string, limit int) ([]string, error)
+    RelatedImages(image *db.Image, limit int) ([]SearchResult, error)
+    SimilarImages(vector []float32, excludeID int64, limit int) ([]SearchResult, error)
+    IndexImage(image *db.Image) error
+    UpdateViewCount(image *db.Image) error
+    IndexSavedSearch(saved *db.SavedSearch) error
+    DeleteSavedSearch(id int64) error
+    MatchSavedSearches(image *db.Image, searches []db.SavedSearch) ([]int64, error)
+    SyncTags(names []string) error
+    ReindexAll(images []db.Image) error
+    IndexStatus() (*IndexStatus, error)
+}
+
+// SearchParams describes a search request.
+type SearchParams struct {
+    Query string
+    // Tags is a comma separated list of tags
+    Tags string
+    // RelevanceOnly skips the popularity and recency boosts
+    RelevanceOnly bool
+    Dates         db.DateFilter
+    Geo           db.GeoFilter
+    // Album restricts the search to the images in an album when not 0
+    Album int64
+}
+
+// Filtered reports whether the search is restricted by date, location or
+// album.
+func (p SearchParams) Filtered() bool {
+    return !p.Dates.IsZero() || !p.Geo.IsZero() || p.Album != 0
+}
+
+var (
+    primary  Backend
+    fallback Backend
+
+    healthMu      sync.Mutex
+    healthy       = true
+    lastCheckedAt time.Time
+    // checking is set while a request pings the primary backend, so others
+    // use the cached state rather than waiting on or repeating the ping
+    checking atomic.Bool
+)
+
+// Init selects the search backend from SEARCH_BACKEND: elasticsearch (the
+// default), postgres or embedded. When Elasticsearch is the primary backend,
+// Postgres is used as a fallback while it is unhealthy unless SEARCH_FALLBACK
+// is set to "none".
+func Init() {
+    switch name := strings.ToLower(os.Getenv("SEARCH_BACKEND")); name {
+    case "", "elasticsearch":
+        InitElasticsearch()
+        primary = elasticsearchBackend{}
+    case "postgres":
+        primary = postgresBackend{}
+    case "embedded":
+        primary = initEmbedded()
+    default:
+        log.Fatalf("Unknown SEARCH_BACKEND %q", name)
+    }
+
+    if primary.Name() == "elasticsearch" {
+        switch name := strings.ToLower(os.Getenv("SEARCH_FALLBACK")); name {
+        case "", "postgres":
+            fallback = postgresBackend{}
+        case "none":
+        default:
+            log.Fatalf("Unknown SEARCH_FALLBACK %q", name)
+        }
+    }
+
+    loadRanking()
+    loadLanguages()
+    if err := ReloadSynonyms(); err != nil {
+        log.Printf("Error loading tag synonyms: %v", err)
+    }
+    // The mapping depends on the configured languages. Elasticsearch may
+    // not be up yet, in which case the first index write retries this.
+    if primary.Name() == "elasticsearch" {
+        if err := ensureIndexes(); err != nil {
+            log.Printf("Warning: Could not verify search indexes: %v", err)
+        }
+    }
+
+    log.Printf("Using %s search backend", primary.Name())
+}
+
+// initEmbedded loads the on-disk index, building it from the database the
+// first time the embedded backend is used.
+func initEmbedded() Backend {
+    backend := newEmbeddedBackend()
+    found, err := backend.load()
+    if err != nil {
+        log.Fatal("Error loading embedded search index:", err)
+    }
+    if !found {
+        images, err := db.GetAllImages()
+        if err != nil {
+            log.Fatal("Error fetching images for embedded search index:", err)
+        }
+        if err := backend.ReindexAll(images); err != nil {
+            log.Fatal("Error building embedded search index:", err)
+        }
+        log.Printf("Built embedded search index with %d images", len(images))
+    }
+    return backend
+}
+
+// isHealthy returns the cached health of the primary backend, pinging it
+// when the state is older than healthCheckInterval. The ping runs outside
+// healthMu, so a slow backend only delays the request that checks it.
+func isHealthy() bool {
+    healthMu.Lock()
+    current, fresh := healthy, time.Since(lastCheckedAt) < healthCheckInterval
+    healthMu.Unlock()
+
+    if fresh || !checking.CompareAndSwap(false, true) {
+        return current
+    }
+    defer checking.Store(false)
+
+    startedAt := time.Now()
+    err := primary.Ping()
+
+    healthMu.Lock()
+    defer healthMu.Unlock()
+
+    // Keep a failure reported by a request while the ping was running
+    if lastCheckedAt.After(startedAt) {
+        return healthy
+    }
+    if err != nil && healthy {
+        log.Printf("Search backend %s is unhealthy: %v", primary.Name(), err)
+    } else if err == nil && !healthy {
+        log.Printf("Search backend %s has recovered", primary.Name())
+    }
+    healthy = err == nil
+    lastCheckedAt = time.Now()
+    return healthy
+}
+
+func markUnhealthy(err error) {
+    healthMu.Lock()
+    defer healthMu.Unlock()
+
+    if healthy {
+        log.Printf("Search backend %s is unhealthy: %v", primary.Name(), err)
+    }
+    healthy = false
+    lastCheckedAt = time.Now()
+}
+
+// active returns the backend queries should be sent to.
+func active() Backend {
+    if fallback == nil || isHealthy() {
+        return primary
+    }
+    return fallback
+}
+
+func ActiveBackend() string {
+    return active().Name()
+}
+
+// expandQuery turns the tag filter and any words of the text query that have
+// synonyms into the expanded tag list a backend should match, so searching
+// for an alias finds images tagged with its canonical tag and vice versa,
+// and a parent tag or namespace matches everything below it.
+func expandQuery(q string, tags string) string {
+    refreshSynonyms()
+
+    var tagList []string
+    if tags != "" {
+        tagList = strings.Split(tags, ",")
+    }
+    for _, term := range append([]string{q}, tokenize(q)...) {
+        if term = db.NormalizeTag(term); term != "" && synonymsOf(term) != nil {
+            tagList = append(tagList, term)
+        }
+    }
+    return strings.Join(uniqueTerms(tagDescendants(expandTags(tagList))), ",")
+}
+
+// SearchResponse holds search results and, when there are few of them, a
+// corrected spelling of the query that may find more.
+type SearchResponse struct {
+    Results    []SearchResult
+    DidYouMean string
+}
+
+func SearchImages(params SearchParams) (*SearchResponse, error) {
+    q := params.Query
+    params.Tags = expandQuery(q, params.Tags)
+
+    backend := active()
+    results, err := backend.SearchImages(params)
+    if errors.Is(err, ErrUnavailable) && backend == primary && fallback != nil {
+        markUnhealthy(err)
+        backend = fallback
+        results, err = backend.SearchImages(params)
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    response := &SearchResponse{Results: results}
+    if q != "" && len(results) < sparseResultCount {
+        // A failed suggestion shouldn't fail the search itself
+        suggestion, err := backend.DidYouMean(q)
+        if err != nil {
+            log.Printf("Error fetching spelling suggestion for %q: %v", q, err)
+        } else if !strings.EqualFold(suggestion, q) {
+            response.DidYouMean = suggestion
+        }
+    }
+    return response, nil
+}
+
+// SuggestTags returns tags matching what has been typed so far, ranked by
+// how well they match, how many images use them and whether they are among
+// the user's recently used tags.
+func SuggestTags(query string, recent []string) ([]string, error) {
+    backend := active()
+    tags, err := backend.SuggestTags(query, recent)
+    if errors.Is(err, ErrUnavailable) && backend == primary && fallback != nil {
+        markUnhealthy(err)
+        tags, err = fallback.SuggestTags(query, recent)
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    // Offer the canonical tag for any alias being typed
+    var canonical []string
+    for _, tag := range canonicalSuggestions(query) {
+        if !containsString(tags, tag) && !containsString(canonical, tag) {
+            canonical = append(canonical, tag)
+        }
+    }
+    tags = append(canonical, tags...)
+    if len(tags) > suggestLimit {
+        tags = tags[:suggestLimit]
+    }
+    return tags, nil
+}
+
+func containsString(values []string, value string) bool {
+    for _, v := range values {
+        if v == value {
+            return true
+        }
+    }
+    return false
+}
+
+// IndexImage and ReindexAll always write to the primary backend; the
+// Postgres fallback reads straight from the images table.
+// IndexImage also caches any new nested tags of the image, so searching for
+// their parents finds it straight away on this instance. Other instances pick
+// them up on their next synonym refresh.
+func IndexImage(image *db.Image) error {
+    addNestedTags(image.Tags)
+    return primary.IndexImage(image)
+}
+
+// UpdateViewCount writes just the view count of an image, which changes on
+// every view, without reindexing the rest of it.
+func UpdateViewCount(image *db.Image) error {
+    return primary.UpdateViewCount(image)
+}
+
+// RelatedTags returns tags that frequently appear alongside all of the given
+// tags, most related first.
+func RelatedTags(tags []string, limit int) ([]string, error) {
+    backend := active()
+    related, err := backend.RelatedTags(tags, limit)
+    if errors.Is(err, ErrUnavailable) && backend == primary && fallback != nil {
+        markUnhealthy(err)
+        return fallback.RelatedTags(tags, limit)
+    }
+    return related, err
+}
+
+// RelatedImages returns images similar to image in description and tags,
+// most similar first and never including image itself. more_like_this finds
+// nothing when the image isn't indexed yet or its terms are too rare, so
+// Elasticsearch's empty results are replaced by tag overlap in Postgres.
+func RelatedImages(image *db.Image, limit int) ([]SearchResult, error) {
+    backend := active()
+    related, err := backend.RelatedImages(image, limit)
+    if errors.Is(err, ErrUnavailable) && backend == primary && fallback != nil {
+        markUnhealthy(err)
+        return fallback.RelatedImages(image, limit)
+    }
+    if err == nil && len(related) == 0 && backend.Name() == "elasticsearch" {
+        return postgresBackend{}.RelatedImages(image, limit)
+    }
+    return related, err
+}
+
+// SimilarImages returns the images whose embeddings are closest to vector,
+// leaving out the image with excludeID when it is not 0.
+func SimilarImages(vector []float32, excludeID int64, limit int) ([]SearchResult, error) {
+    backend := active()
+    similar, err := backend.SimilarImages(vector, excludeID, limit)
+    if errors.Is(err, ErrUnavailable) && backend == primary && fallback != nil {
+        markUnhealthy(err)
+        return fallback.SimilarImages(vector, excludeID, limit)
+    }
+    return similar, err
+}
+
+// SyncTags refreshes the suggestion data for tags whose usage has changed
+// without their images being reindexed, such as renamed or deleted tags.
+func SyncTags(names []string) error {
+    return primary.SyncTags(names)
+}
+
+func ReindexAll(images []db.Image) error {
+    return primary.ReindexAll(images)
+}
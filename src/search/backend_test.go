@@ -0,0 +1,84 @@
+package search
+
+import (
+    "errors"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+// pingBackend is a primary backend whose Ping blocks until released.
+type pingBackend struct {
+    Backend
+    pings   atomic.Int32
+    started chan struct{}
+    release chan error
+}
+
+func (b *pingBackend) Name() string {
+    return "test"
+}
+
+func (b *pingBackend) Ping() error {
+    b.pings.Add(1)
+    b.started <- struct{}{}
+    return <-b.release
+}
+
+func resetHealth(t *testing.T, backend Backend) {
+    t.Helper()
+    previous := primary
+    primary = backend
+    healthy, lastCheckedAt = true, time.Time{}
+    t.Cleanup(func() {
+        primary = previous
+        healthy, lastCheckedAt = true, time.Time{}
+    })
+}
+
+func TestIsHealthyDoesNotWaitOnPing(t *testing.T) {
+    backend := &pingBackend{started: make(chan struct{}, 1), release: make(chan error)}
+    resetHealth(t, backend)
+
+    result := make(chan bool)
+    go func() { result <- isHealthy() }()
+    <-backend.started
+
+    // Others get the cached state while the ping is in flight
+    done := make(chan bool)
+    go func() { done <- isHealthy() }()
+    select {
+    case got := <-done:
+        if !got {
+            t.Errorf("isHealthy during ping = false, want the cached true")
+        }
+    case <-time.After(time.Second):
+        t.Fatal("isHealthy waited on the ping in progress")
+    }
+
+    backend.release <- errors.New("connection refused")
+    if <-result {
+        t.Errorf("isHealthy after a failed ping = true, want false")
+    }
+    if got := isHealthy(); got {
+        t.Errorf("cached isHealthy = true, want false")
+    }
+    if n := backend.pings.Load(); n != 1 {
+        t.Errorf("pinged %d times, want 1", n)
+    }
+}
+
+func TestIsHealthyKeepsFailureReportedDuringPing(t *testing.T) {
+    backend := &pingBackend{started: make(chan struct{}, 1), release: make(chan error)}
+    resetHealth(t, backend)
+
+    result := make(chan bool)
+    go func() { result <- isHealthy() }()
+    <-backend.started
+
+    markUnhealthy(ErrUnavailable)
+    backend.release <- nil
+    if <-result {
+        t.Errorf("isHealthy = true, want the failure reported during the ping")
+    }
+}
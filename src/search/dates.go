@@ -0,0 +1,94 @@
+package search
+
+import (
+    "fmt"
+    "regexp"
+    "strconv"
+    "time"
+    "github.com/grrywlsn/imagerr/src/db"
+)
+
+var relativeDate = regexp.MustCompile(`^(\d+)([hdwmy])$`)
+
+// Calendar periods accepted as date bounds, most specific first
+var dateLayouts = []struct {
+    layout string
+    years  int
+    months int
+    days   int
+}{
+    {"2006-01-02", 0, 0, 1},
+    {"2006-01", 0, 1, 0},
+    {"2006", 1, 0, 0},
+}
+
+// parseDateBound reads a from or to search parameter. It accepts an RFC 3339
+// time, a day (2006-01-02), month (2006-01) or year (2006) in UTC, or an age
+// such as 12h, 7d, 2w, 3m or 1y counted back from now. A calendar period
+// given as the upper bound includes the whole period, so from=2024-03 and
+// to=2024-03 together select March 2024.
+func parseDateBound(value string, now time.Time, upper bool) (*time.Time, error) {
+    if match := relativeDate.FindStringSubmatch(value); match != nil {
+        n, err := strconv.Atoi(match[1])
+        if err != nil {
+            return nil, err
+        }
+        var t time.Time
+        switch match[2] {
+        case "h":
+            t = now.Add(-time.Duration(n) * time.Hour)
+        case "d":
+            t = now.AddDate(0, 0, -n)
+        case "w":
+            t = now.AddDate(0, 0, -7*n)
+        case "m":
+            t = now.AddDate(0, -n, 0)
+        case "y":
+            t = now.AddDate(-n, 0, 0)
+        }
+        return &t, nil
+    }
+
+    if t, err := time.Parse(time.RFC3339, value); err == nil {
+        return &t, nil
+    }
+    for _, period := range dateLayouts {
+        t, err := time.Parse(period.layout, value)
+        if err != nil {
+            continue
+        }
+        if upper {
+            t = t.AddDate(period.years, period.months, period.days)
+        }
+        return &t, nil
+    }
+    return nil, fmt.Errorf("invalid date %q, use a date like 2024-03-14, 2024-03 or 2024, or an age like 7d", value)
+}
+
+// ParseDateFilter reads the date, from and to parameters of a search. date
+// selects whether the range applies to the upload time ("uploaded", the
+// default when empty) or the time the photo was taken ("taken"). Relative
+// bounds are counted back from now.
+func ParseDateFilter(date, from, to string, now time.Time) (db.DateFilter, error) {
+    var filter db.DateFilter
+    switch date {
+    case "", "uploaded":
+    case "taken":
+        filter.Taken = true
+    default:
+        return filter, fmt.Errorf("invalid date %q, use uploaded or taken", date)
+    }
+
+    var err error
+    if from != "" {
+        if filter.From, err = parseDateBound(from, now, false); err != nil {
+            return filter, err
+        }
+    }
+    if to != "" {
+        if filter.To, err = parseDateBound(to, now, true); err != nil {
+            return filter, err
+        }
+    }
+    return filter, nil
+}
@@ -0,0 +1,124 @@
+package search
+
+import (
+    "testing"
+    "time"
+)
+
+func TestParseDateBound(t *testing.T) {
+    now := time.Date(2024, time.March, 31, 12, 0, 0, 0, time.UTC)
+    for _, test := range []struct {
+        value string
+        upper bool
+        want  time.Time
+    }{
+        // Ages count back from now whichever bound they are
+        {"12h", false, time.Date(2024, time.March, 31, 0, 0, 0, 0, time.UTC)},
+        {"7d", false, time.Date(2024, time.March, 24, 12, 0, 0, 0, time.UTC)},
+        {"7d", true, time.Date(2024, time.March, 24, 12, 0, 0, 0, time.UTC)},
+        {"2w", false, time.Date(2024, time.March, 17, 12, 0, 0, 0, time.UTC)},
+        {"3m", false, time.Date(2023, time.December, 31, 12, 0, 0, 0, time.UTC)},
+        {"1y", false, time.Date(2023, time.March, 31, 12, 0, 0, 0, time.UTC)},
+        {"0d", false, now},
+        // Times are used as given
+        {"2024-03-14T10:00:00Z", false, time.Date(2024, time.March, 14, 10, 0, 0, 0, time.UTC)},
+        {"2024-03-14T10:00:00+01:00", true, time.Date(2024, time.March, 14, 9, 0, 0, 0, time.UTC)},
+        // Calendar periods start at their beginning, and as an upper bound
+        // end at the start of the next one
+        {"2024-03-14", false, time.Date(2024, time.March, 14, 0, 0, 0, 0, time.UTC)},
+        {"2024-03-14", true, time.Date(2024, time.March, 15, 0, 0, 0, 0, time.UTC)},
+        {"2024-02-29", true, time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)},
+        {"2024-03", false, time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)},
+        {"2024-02", true, time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)},
+        {"2024-12", true, time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)},
+        {"2024", false, time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)},
+        {"2024", true, time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)},
+    } {
+        got, err := parseDateBound(test.value, now, test.upper)
+        if err != nil || got == nil || !got.Equal(test.want) {
+            t.Errorf("parseDateBound(%q, upper %v) = %v, %v, want %v", test.value, test.upper, got, err, test.want)
+        }
+    }
+}
+
+func TestParseDateBoundRejectsInvalid(t *testing.T) {
+    now := time.Date(2024, time.March, 31, 12, 0, 0, 0, time.UTC)
+    for _, value := range []string{
+        "yesterday", "7", "7x", "-7d", "d", "7 d", "2024-13", "2024-02-30", "24", "2024-03-14T10:00", "2024-03-14 10:00:00",
+    } {
+        if got, err := parseDateBound(value, now, false); err == nil {
+            t.Errorf("parseDateBound(%q) = %v, want an error", value, got)
+        }
+    }
+}
+
+func TestParseDateFilter(t *testing.T) {
+    now := time.Date(2024, time.March, 31, 12, 0, 0, 0, time.UTC)
+    march := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+    april := time.Date(2024, time.April, 1, 0, 0, 0, 0, time.UTC)
+
+    for _, test := range []struct {
+        date, from, to string
+        taken          bool
+        wantFrom       *time.Time
+        wantTo         *time.Time
+    }{
+        {"", "", "", false, nil, nil},
+        {"uploaded", "2024-03", "", false, &march, nil},
+        {"taken", "", "2024-03", true, nil, &april},
+        {"", "2024-03", "2024-03", false, &march, &april},
+    } {
+        filter, err := ParseDateFilter(test.date, test.from, test.to, now)
+        if err != nil {
+            t.Errorf("ParseDateFilter(%q, %q, %q): %v", test.date, test.from, test.to, err)
+            continue
+        }
+        if filter.Taken != test.taken || !equalTime(filter.From, test.wantFrom) || !equalTime(filter.To, test.wantTo) {
+            t.Errorf("ParseDateFilter(%q, %q, %q) = taken %v from %v to %v, want taken %v from %v to %v",
+                test.date, test.from, test.to, filter.Taken, filter.From, filter.To, test.taken, test.wantFrom, test.wantTo)
+        }
+        if filter.IsZero() != (test.wantFrom == nil && test.wantTo == nil) {
+            t.Errorf("ParseDateFilter(%q, %q, %q).IsZero() = %v", test.date, test.from, test.to, filter.IsZero())
+        }
+    }
+
+    for _, test := range []struct {
+        date, from, to string
+    }{
+        {"modified", "", ""},
+        {"", "soon", ""},
+        {"taken", "", "2024-13"},
+    } {
+        if _, err := ParseDateFilter(test.date, test.from, test.to, now); err == nil {
+            t.Errorf("ParseDateFilter(%q, %q, %q) succeeded, want an error", test.date, test.from, test.to)
+        }
+    }
+}
+
+func TestDateOnlyUpperBoundIsExclusive(t *testing.T) {
+    now := time.Date(2024, time.March, 31, 12, 0, 0, 0, time.UTC)
+    filter, err := ParseDateFilter("", "2024-03-14", "2024-03-14", now)
+    if err != nil {
+        t.Fatalf("ParseDateFilter: %v", err)
+    }
+    for _, test := range []struct {
+        createdAt time.Time
+        want      bool
+    }{
+        {time.Date(2024, time.March, 13, 23, 59, 59, 0, time.UTC), false},
+        {time.Date(2024, time.March, 14, 0, 0, 0, 0, time.UTC), true},
+        {time.Date(2024, time.March, 14, 23, 59, 59, 999000000, time.UTC), true},
+        {time.Date(2024, time.March, 15, 0, 0, 0, 0, time.UTC), false},
+    } {
+        if got := inDateRange(SearchResult{CreatedAt: test.createdAt}, filter); got != test.want {
+            t.Errorf("inDateRange(%v) = %v, want %v", test.createdAt, got, test.want)
+        }
+    }
+}
+
+func equalTime(a, b *time.Time) bool {
+    if a == nil || b == nil {
+        return a == b
+    }
+    return a.Equal(*b)
+}
@@ -0,0 +1,83 @@
+package search
+
+import (
+    "sort"
+    "strings"
+    "unicode/utf16"
+)
+
+// suggestEdits is how far a word may be from a known word to be corrected
+// to it. It allows more than fuzzyEdits, since words within that distance
+// already match when searching.
+func suggestEdits(word string) int {
+    switch n := len([]rune(word)); {
+    case n <= 2:
+        return 0
+    case n <= 4:
+        return 1
+    default:
+        return 2
+    }
+}
+
+// correctQuery replaces each word of q that isn't in vocabulary with the
+// closest word that is, preferring the most common, and returns "" if no
+// word could be corrected. Backends without a suggester of their own use it
+// with the words of the descriptions and tags they search.
+func correctQuery(q string, vocabulary map[string]int) string {
+    words := tokenize(q)
+    changed := false
+    for i, word := range words {
+        if vocabulary[word] > 0 {
+            continue
+        }
+
+        maxEdits := suggestEdits(word)
+        best, bestDistance, bestCount := "", maxEdits+1, 0
+        for candidate, count := range vocabulary {
+            distance := levenshtein(word, candidate, maxEdits)
+            if distance > maxEdits {
+                continue
+            }
+            if distance < bestDistance || (distance == bestDistance && (count > bestCount || (count == bestCount && candidate < best))) {
+                best, bestDistance, bestCount = candidate, distance, count
+            }
+        }
+        if best != "" {
+            words[i] = best
+            changed = true
+        }
+    }
+
+    if !changed {
+        return ""
+    }
+    return strings.Join(words, " ")
+}
+
+// textCorrection replaces Length characters of a query from Offset with
+// Text. Elasticsearch counts both in UTF-16 code units, as Java does.
+type textCorrection struct {
+    Offset int
+    Length int
+    Text   string
+}
+
+// applyCorrections makes the corrections to q, skipping any that fall
+// outside it or overlap one already made.
+func applyCorrections(q string, corrections []textCorrection) string {
+    sort.Slice(corrections, func(i, j int) bool { return corrections[i].Offset > corrections[j].Offset })
+
+    // Replace from the end so earlier offsets stay valid
+    corrected := utf16.Encode([]rune(q))
+    limit := len(corrected)
+    for _, c := range corrections {
+        end := c.Offset + c.Length
+        if c.Offset < 0 || c.Length < 0 || end > limit {
+            continue
+        }
+        corrected = append(corrected[:c.Offset:c.Offset], append(utf16.Encode([]rune(c.Text)), corrected[end:]...)...)
+        limit = c.Offset
+    }
+    return string(utf16.Decode(corrected))
+}
@@ -0,0 +1,102 @@
+package search
+
+import "testing"
+
+func TestSuggestEdits(t *testing.T) {
+    for _, test := range []struct {
+        word string
+        want int
+    }{
+        {"", 0},
+        {"ox", 0},
+        {"cat", 1},
+        {"tree", 1},
+        {"beach", 2},
+        {"mountains", 2},
+        // Runes, not bytes
+        {"éé", 0},
+        {"über", 1},
+    } {
+        if got := suggestEdits(test.word); got != test.want {
+            t.Errorf("suggestEdits(%q) = %d, want %d", test.word, got, test.want)
+        }
+    }
+}
+
+func TestCorrectQuery(t *testing.T) {
+    vocabulary := map[string]int{
+        "sunset":    12,
+        "sunsets":   2,
+        "beach":     8,
+        "bench":     3,
+        "mountains": 5,
+        "cat":       4,
+        "car":       9,
+        "berlin":    6,
+    }
+    for _, test := range []struct {
+        q    string
+        want string
+    }{
+        // Nothing to correct
+        {"", ""},
+        {"sunset beach", ""},
+        {"zebra", ""},
+        // Single words, including two edits on longer words
+        {"sunest", "sunset"},
+        {"moutnains", "mountains"},
+        {"brelin", "berlin"},
+        // Closest wins over most common
+        {"beech", "beach"},
+        // Equally close goes to the most common
+        {"cax", "car"},
+        // Short words are left alone
+        {"ct", ""},
+        // Known words are kept while others are corrected, lower cased
+        {"Sunset beahc", "sunset beach"},
+        {"sunst over the beahc", "sunset over the beach"},
+    } {
+        if got := correctQuery(test.q, vocabulary); got != test.want {
+            t.Errorf("correctQuery(%q) = %q, want %q", test.q, got, test.want)
+        }
+    }
+}
+
+func TestCorrectQueryBreaksTiesAlphabetically(t *testing.T) {
+    vocabulary := map[string]int{"bat": 1, "cat": 1, "hat": 1}
+    for i := 0; i < 10; i++ {
+        if got := correctQuery("zat", vocabulary); got != "bat" {
+            t.Fatalf("correctQuery(zat) = %q, want bat", got)
+        }
+    }
+}
+
+func TestApplyCorrections(t *testing.T) {
+    for _, test := range []struct {
+        name        string
+        q           string
+        corrections []textCorrection
+        want        string
+    }{
+        {"none", "sunest", nil, "sunest"},
+        {"one", "sunest beach", []textCorrection{{0, 6, "sunset"}}, "sunset beach"},
+        {
+            "several out of order",
+            "sunest over the beahc",
+            []textCorrection{{0, 6, "sunset"}, {16, 5, "beach"}},
+            "sunset over the beach",
+        },
+        {"longer replacement", "nyc skyline", []textCorrection{{0, 3, "new york"}}, "new york skyline"},
+        // Offsets count UTF-16 code units: é is one unit, the emoji two
+        {"accented", "café sunest", []textCorrection{{5, 6, "sunset"}}, "café sunset"},
+        {"astral", "📷 sunest", []textCorrection{{3, 6, "sunset"}}, "📷 sunset"},
+        {"astral replacement", "sunest 📷 beahc", []textCorrection{{10, 5, "beach"}, {0, 6, "sunset"}}, "sunset 📷 beach"},
+        {"out of range", "sunest", []textCorrection{{2, 6, "sunset"}}, "sunest"},
+        {"negative", "sunest", []textCorrection{{-1, 2, "x"}}, "sunest"},
+        {"overlapping", "abcdef", []textCorrection{{0, 4, "wxyz"}, {2, 4, "CDEF"}}, "abCDEF"},
+    } {
+        if got := applyCorrections(test.q, test.corrections); got != test.want {
+            t.Errorf("%s: applyCorrections(%q) = %q, want %q", test.name, test.q, got, test.want)
+        }
+    }
+}
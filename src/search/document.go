@@ -0,0 +1,132 @@
+package search
+
+import (
+    "bytes"
+    "fmt"
+    "strconv"
+    "time"
+    "github.com/grrywlsn/imagerr/src/db"
+    "github.com/grrywlsn/imagerr/src/features"
+)
+
+// indexDateFormat is the Go layout for the strict_date_time format declared
+// on created_at in createIndexMapping. Elasticsearch dates keep millisecond
+// precision, so anything finer is dropped when a document is written.
+const indexDateFormat = "2006-01-02T15:04:05.000Z07:00"
+
+type indexTime time.Time
+
+func (t indexTime) MarshalJSON() ([]byte, error) {
+    return []byte(strconv.Quote(time.Time(t).UTC().Format(indexDateFormat))), nil
+}
+
+// UnmarshalJSON also accepts RFC 3339 strings and epoch milliseconds, which
+// documents indexed before the format was fixed may still contain.
+func (t *indexTime) UnmarshalJSON(data []byte) error {
+    if bytes.Equal(data, []byte("null")) {
+        *t = indexTime{}
+        return nil
+    }
+
+    if millis, err := strconv.ParseInt(string(data), 10, 64); err == nil {
+        *t = indexTime(time.UnixMilli(millis).UTC())
+        return nil
+    }
+
+    value, err := strconv.Unquote(string(data))
+    if err != nil {
+        return fmt.Errorf("invalid date %s", data)
+    }
+    parsed, err := time.Parse(indexDateFormat, value)
+    if err != nil {
+        if parsed, err = time.Parse(time.RFC3339Nano, value); err != nil {
+            return fmt.Errorf("invalid date %q: %v", value, err)
+        }
+    }
+    *t = indexTime(parsed)
+    return nil
+}
+
+// document is the shape of an image in the Elasticsearch index.
+type document struct {
+    ID               int64      `json:"id"`
+    OriginalFilename string     `json:"original_filename"`
+    UUIDFilename     string     `json:"uuid_filename"`
+    Description      string     `json:"description"`
+    URL              string     `json:"url,omitempty"`
+    Tags             []string   `json:"tags"`
+    StoragePath      string     `json:"storage_path"`
+    CreatedAt        indexTime  `json:"created_at"`
+    ViewCount        int        `json:"view_count"`
+    TakenAt          *indexTime `json:"taken_at,omitempty"`
+    Embedding        []float32  `json:"embedding,omitempty"`
+    Language         string     `json:"language"`
+    // LocalDescription holds the description under its language, so it is
+    // analysed by that language's analyzer. See descriptionLanguage.
+    LocalDescription map[string]string `json:"description_by_language"`
+    Location         *geoPoint         `json:"location,omitempty"`
+    Albums           []int64           `json:"albums,omitempty"`
+}
+
+// geoPoint is the object form of an Elasticsearch geo_point.
+type geoPoint struct {
+    Lat float64 `json:"lat"`
+    Lon float64 `json:"lon"`
+}
+
+func newGeoPoint(latitude, longitude *float64) *geoPoint {
+    if latitude == nil || longitude == nil {
+        return nil
+    }
+    return &geoPoint{Lat: *latitude, Lon: *longitude}
+}
+
+func newDocument(image *db.Image) document {
+    return document{
+        ID:               image.ID,
+        OriginalFilename: image.OriginalFilename,
+        UUIDFilename:     image.UUIDFilename,
+        Description:      image.Description,
+        URL:              image.URL,
+        Tags:             image.Tags,
+        StoragePath:      image.StoragePath,
+        CreatedAt:        indexTime(image.CreatedAt),
+        ViewCount:        image.ViewCount,
+        TakenAt:          (*indexTime)(image.TakenAt),
+        Embedding:        validEmbedding(image.Embedding),
+        Language:         imageLanguage(image.Language),
+        LocalDescription: map[string]string{descriptionLanguage(image.Language): image.Description},
+        Location:         newGeoPoint(image.Latitude, image.Longitude),
+        Albums:           image.Albums,
+    }
+}
+
+// validEmbedding drops vectors a cosine dense_vector field would reject.
+func validEmbedding(embedding []float32) []float32 {
+    if !features.Valid(embedding) {
+        return nil
+    }
+    return embedding
+}
+
+func (d document) searchResult() SearchResult {
+    result := SearchResult{
+        ID:               d.ID,
+        OriginalFilename: d.OriginalFilename,
+        UUIDFilename:     d.UUIDFilename,
+        Description:      d.Description,
+        URL:              d.URL,
+        Tags:             d.Tags,
+        StoragePath:      d.StoragePath,
+        CreatedAt:        time.Time(d.CreatedAt),
+        ViewCount:        d.ViewCount,
+        TakenAt:          (*time.Time)(d.TakenAt),
+        Language:         d.Language,
+        Albums:           d.Albums,
+    }
+    if d.Location != nil {
+        lat, lon := d.Location.Lat, d.Location.Lon
+        result.Latitude, result.Longitude = &lat, &lon
+    }
+    return result
+}
@@ -0,0 +1,171 @@
+package search
+
+import (
+    "encoding/json"
+    "reflect"
+    "testing"
+    "time"
+    "github.com/grrywlsn/imagerr/src/db"
+)
+
+func testImage() *db.Image {
+    takenAt := time.Date(2024, time.March, 12, 9, 15, 0, 0, time.UTC)
+    latitude, longitude := 52.505, 13.4397
+    return &db.Image{
+        ID:               42,
+        OriginalFilename: "berlin-wall.jpg",
+        UUIDFilename:     "2f1c6e0a-8a8e-4f57-9c55-5d1b2f0c7e11.jpg",
+        Description:      "Sunset over the East Side Gallery",
+        URL:              "https://cdn.example.com/images/2f1c6e0a-8a8e-4f57-9c55-5d1b2f0c7e11.jpg",
+        Tags:             []string{"berlin", "sunset"},
+        StoragePath:      "images/2f1c6e0a-8a8e-4f57-9c55-5d1b2f0c7e11.jpg",
+        CreatedAt:        time.Date(2024, time.March, 14, 18, 30, 5, 123000000, time.FixedZone("CET", 3600)),
+        ViewCount:        7,
+        TakenAt:          &takenAt,
+        Language:         "german",
+        Latitude:         &latitude,
+        Longitude:        &longitude,
+        Albums:           []int64{3, 8},
+    }
+}
+
+// roundTrip encodes an image as IndexImage does and decodes it the way
+// SearchImages reads hits back.
+func roundTrip(t *testing.T, image *db.Image) SearchResult {
+    t.Helper()
+
+    data, err := json.Marshal(newDocument(image))
+    if err != nil {
+        t.Fatalf("encoding document: %v", err)
+    }
+
+    hit := []byte(`{"hits":{"hits":[{"_id":"42","_score":1.5,"_source":` + string(data) + `}]}}`)
+    var response searchResponse
+    if err := json.Unmarshal(hit, &response); err != nil {
+        t.Fatalf("decoding search response: %v", err)
+    }
+    if len(response.Hits.Hits) != 1 {
+        t.Fatalf("got %d hits, want 1", len(response.Hits.Hits))
+    }
+    return response.Hits.Hits[0].Source.searchResult()
+}
+
+func TestDocumentRoundTripCoversEveryField(t *testing.T) {
+    image := testImage()
+    got := roundTrip(t, image)
+
+    want := reflect.ValueOf(imageToSearchResult(*image))
+    gotValue := reflect.ValueOf(got)
+    for i := 0; i < want.NumField(); i++ {
+        field := want.Type().Field(i).Name
+        if want.Field(i).IsZero() {
+            t.Fatalf("test image leaves SearchResult.%s empty; set it so the field is covered", field)
+        }
+
+        if field == "CreatedAt" {
+            wantTime := want.Field(i).Interface().(time.Time)
+            gotTime := gotValue.Field(i).Interface().(time.Time)
+            if !gotTime.Equal(wantTime) {
+                t.Errorf("CreatedAt = %v, want %v", gotTime, wantTime)
+            }
+            continue
+        }
+        if field == "TakenAt" {
+            wantTime := want.Field(i).Interface().(*time.Time)
+            gotTime := gotValue.Field(i).Interface().(*time.Time)
+            if gotTime == nil || !gotTime.Equal(*wantTime) {
+                t.Errorf("TakenAt = %v, want %v", gotTime, *wantTime)
+            }
+            continue
+        }
+        if !reflect.DeepEqual(gotValue.Field(i).Interface(), want.Field(i).Interface()) {
+            t.Errorf("%s = %v, want %v", field, gotValue.Field(i).Interface(), want.Field(i).Interface())
+        }
+    }
+}
+
+func TestDocumentCreatedAtFormat(t *testing.T) {
+    data, err := json.Marshal(newDocument(testImage()))
+    if err != nil {
+        t.Fatalf("encoding document: %v", err)
+    }
+
+    var raw map[string]interface{}
+    if err := json.Unmarshal(data, &raw); err != nil {
+        t.Fatalf("decoding document: %v", err)
+    }
+    if got, want := raw["created_at"], "2024-03-14T17:30:05.123Z"; got != want {
+        t.Errorf("created_at = %v, want %v", got, want)
+    }
+}
+
+func TestDocumentCreatedAtTruncatesToMilliseconds(t *testing.T) {
+    image := testImage()
+    image.CreatedAt = time.Date(2024, time.March, 14, 17, 30, 5, 123456789, time.UTC)
+
+    got := roundTrip(t, image).CreatedAt
+    want := time.Date(2024, time.March, 14, 17, 30, 5, 123000000, time.UTC)
+    if !got.Equal(want) {
+        t.Errorf("CreatedAt = %v, want %v", got, want)
+    }
+}
+
+func TestIndexTimeUnmarshal(t *testing.T) {
+    want := time.Date(2024, time.March, 14, 17, 30, 5, 123000000, time.UTC)
+    tests := []struct {
+        name  string
+        input string
+    }{
+        {"strict date time", `"2024-03-14T17:30:05.123Z"`},
+        {"offset", `"2024-03-14T18:30:05.123+01:00"`},
+        {"rfc3339 nanos", `"2024-03-14T17:30:05.123000000Z"`},
+        {"epoch millis", `1710437405123`},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            var got indexTime
+            if err := json.Unmarshal([]byte(tt.input), &got); err != nil {
+                t.Fatalf("unmarshal %s: %v", tt.input, err)
+            }
+            if !time.Time(got).Equal(want) {
+                t.Errorf("got %v, want %v", time.Time(got), want)
+            }
+        })
+    }
+}
+
+func TestIndexTimeUnmarshalRejectsGarbage(t *testing.T) {
+    var got indexTime
+    if err := json.Unmarshal([]byte(`"last tuesday"`), &got); err == nil {
+        t.Errorf("expected an error, got %v", time.Time(got))
+    }
+}
+
+func TestDocumentDescriptionLanguage(t *testing.T) {
+    defer func(enabled []string, fallback string) {
+        languages, defaultLanguage = enabled, fallback
+    }(languages, defaultLanguage)
+    languages, defaultLanguage = []string{"english", "german"}, "german"
+
+    for _, test := range []struct {
+        language, want string
+    }{
+        {"german", "german"},
+        {"english", "english"},
+        // Stored before languages were recorded
+        {"", "english"},
+        // Stemmable but not enabled, so there is no analyzer for it
+        {"french", "german"},
+    } {
+        image := testImage()
+        image.Language = test.language
+        document := newDocument(image)
+        if want := map[string]string{test.want: image.Description}; !reflect.DeepEqual(document.LocalDescription, want) {
+            t.Errorf("language %q: LocalDescription = %v, want %v", test.language, document.LocalDescription, want)
+        }
+        if want := imageLanguage(test.language); document.Language != want {
+            t.Errorf("language %q: Language = %q, want %q", test.language, document.Language, want)
+        }
+    }
+}
@@ -6,16 +6,20 @@ import (
     "encoding/json"
     "fmt"
     "log"
+    "net/http"
     "os"
     "strconv"
     "strings"
     "time"
     "github.com/elastic/go-elasticsearch/v8"
     "github.com/grrywlsn/imagerr/src/db"
+    "github.com/grrywlsn/imagerr/src/features"
 )
 
 var esClient *elasticsearch.Client
 
+type elasticsearchBackend struct{}
+
 func InitElasticsearch() {
     cfg := elasticsearch.Config{
         Addresses: []string{os.Getenv("ES_URL")},
@@ -30,6 +34,26 @@ func InitElasticsearch() {
     }
 }
 
+func (elasticsearchBackend) Name() string {
+    return "elasticsearch"
+}
+
+func (elasticsearchBackend) Ping() error {
+    ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+    defer cancel()
+
+    res, err := esClient.Ping(esClient.Ping.WithContext(ctx))
+    if err != nil {
+        return transportError(err)
+    }
+    defer res.Body.Close()
+
+    if res.IsError() {
+        return &Error{StatusCode: res.StatusCode}
+    }
+    return nil
+}
+
 func getIndexName() string {
     prefix := os.Getenv("ES_INDEX_PREFIX")
     if prefix != "" {
@@ -38,19 +62,53 @@ func getIndexName() string {
     return "images"
 }
 
+type searchResponse struct {
+    Hits struct {
+        Hits []struct {
+            ID     string       `json:"_id"`
+            Score  float64      `json:"_score"`
+            Source document     `json:"_source"`
+        } `json:"hits"`
+    } `json:"hits"`
+    Aggregations map[string]struct {
+        Buckets []struct {
+            Key      string `json:"key"`
+            DocCount int64  `json:"doc_count"`
+        } `json:"buckets"`
+    } `json:"aggregations"`
+    Suggest map[string][]suggestEntry `json:"suggest"`
+}
+
+type suggestEntry struct {
+    Text    string `json:"text"`
+    Offset  int    `json:"offset"`
+    Length  int    `json:"length"`
+    Options []struct {
+        Text  string  `json:"text"`
+        Score float64 `json:"score"`
+        Freq  int     `json:"freq"`
+    } `json:"options"`
+}
+
 type SearchResult struct {
-    ID               int64     `json:"id"`
-    OriginalFilename string    `json:"original_filename"`
-    UUIDFilename     string    `json:"uuid_filename"`
-    Description      string    `json:"description"`
-    URL              string    `json:"url"`
-    Tags             []string  `json:"tags"`
-    StoragePath      string    `json:"storage_path"`
-    CreatedAt        time.Time `json:"created_at"`
-    ViewCount        int       `json:"view_count"`
+    ID               int64      `json:"id"`
+    OriginalFilename string     `json:"original_filename"`
+    UUIDFilename     string     `json:"uuid_filename"`
+    Description      string     `json:"description"`
+    URL              string     `json:"url"`
+    Tags             []string   `json:"tags"`
+    StoragePath      string     `json:"storage_path"`
+    CreatedAt        time.Time  `json:"created_at"`
+    ViewCount        int        `json:"view_count"`
+    TakenAt          *time.Time `json:"taken_at,omitempty"`
+    Language         string     `json:"language,omitempty"`
+    Latitude         *float64   `json:"latitude,omitempty"`
+    Longitude        *float64   `json:"longitude,omitempty"`
+    Albums           []int64    `json:"albums,omitempty"`
 }
 
-func SearchImages(q string, tags string) ([]SearchResult, error) {
+func (elasticsearchBackend) SearchImages(params SearchParams) ([]SearchResult, error) {
+    q, tags := params.Query, params.Tags
     searchQuery := map[string]interface{}{
         "query": map[string]interface{}{
             "bool": map[string]interface{}{},
@@ -60,32 +118,24 @@ func SearchImages(q string, tags string) ([]SearchResult, error) {
     // Build query based on provided parameters
     boolQuery := searchQuery["query"].(map[string]interface{})["bool"].(map[string]interface{})
     
+    var filters []map[string]interface{}
+    if filter := dateRangeFilter(params.Dates); filter != nil {
+        filters = append(filters, filter)
+    }
+    filters = append(filters, geoFilters(params.Geo)...)
+    if params.Album != 0 {
+        filters = append(filters, map[string]interface{}{
+            "term": map[string]interface{}{"albums": params.Album},
+        })
+    }
+    if len(filters) > 0 {
+        boolQuery["filter"] = filters
+    }
+
     if q != "" || tags != "" {
-        var shouldClauses []map[string]interface{}
-        
-        if tags != "" {
-            shouldClauses = append(shouldClauses, map[string]interface{}{
-                "terms": map[string]interface{}{
-                    "tags": strings.Split(tags, ","),
-                    "boost": 2.0,
-                },
-            })
-        }
-        
-        if q != "" {
-            shouldClauses = append(shouldClauses, map[string]interface{}{
-                "match": map[string]interface{}{
-                    "description": map[string]interface{}{
-                        "query": q,
-                        "fuzziness": "AUTO",
-                        "boost": 1.0,
-                    },
-                },
-            })
-        }
-        
-        boolQuery["should"] = shouldClauses
+        boolQuery["should"] = matchClauses(params)
         boolQuery["minimum_should_match"] = 1
+        searchQuery["query"] = rankedQuery(searchQuery["query"], rankingFor(params))
         
         searchQuery["sort"] = []map[string]interface{}{
             {
@@ -103,7 +153,9 @@ func SearchImages(q string, tags string) ([]SearchResult, error) {
                 },
             },
         }
-        searchQuery["size"] = 9
+        if !params.Filtered() {
+            searchQuery["size"] = 9
+        }
     }
 
     var buf bytes.Buffer
@@ -117,90 +169,418 @@ func SearchImages(q string, tags string) ([]SearchResult, error) {
         esClient.Search.WithBody(&buf),
     )
     if err != nil {
-        return nil, err
+        return nil, transportError(err)
     }
     defer res.Body.Close()
 
-    var result map[string]interface{}
+    if res.IsError() {
+        return nil, responseError(res)
+    }
+
+    var result searchResponse
     if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
-        return nil, err
+        return nil, fmt.Errorf("error decoding search response: %v", err)
     }
 
     var searchResults []SearchResult
-    hits := result["hits"].(map[string]interface{})["hits"].([]interface{})
+    for _, hit := range result.Hits.Hits {
+        searchResults = append(searchResults, hit.Source.searchResult())
+    }
+
+    return searchResults, nil
+}
+
+// matchClauses returns the should clauses matching the text query and tags
+// of a search. Saved searches are percolated with the same clauses.
+func matchClauses(params SearchParams) []map[string]interface{} {
+    q, tags := params.Query, params.Tags
+    var shouldClauses []map[string]interface{}
     
-    for _, hit := range hits {
-        source := hit.(map[string]interface{})["_source"].(map[string]interface{})
-        searchResults = append(searchResults, SearchResult{
-            ID:               getInt64Value(source["id"]),
-            OriginalFilename: getString(source["original_filename"]),
-            UUIDFilename:     getString(source["uuid_filename"]),
-            Description:      getString(source["description"]),
-            Tags:             interfaceArrayToStringArray(source["tags"].([]interface{})),
-            StoragePath:      getString(source["storage_path"]),
-            CreatedAt:        time.Unix(0, getInt64Value(source["created_at"])),
-            ViewCount:        int(getInt64Value(source["view_count"])),
+    if tags != "" {
+        shouldClauses = append(shouldClauses, map[string]interface{}{
+            "terms": map[string]interface{}{
+                "tags": strings.Split(tags, ","),
+                "boost": 2.0,
+            },
+        })
+    }
+    
+    if q != "" {
+        boosts := rankingFor(params).Fields
+        shouldClauses = append(shouldClauses, map[string]interface{}{
+            "multi_match": map[string]interface{}{
+                "query":     q,
+                "fields":    textFields(boosts),
+                "type":      "best_fields",
+                "fuzziness": "AUTO",
+            },
+        }, map[string]interface{}{
+            "match": map[string]interface{}{
+                "description.exact": map[string]interface{}{
+                    "query": q,
+                    "boost": boosts.Description * exactDescriptionBoost,
+                },
+            },
         })
     }
 
+    return shouldClauses
+}
+
+// textFields lists the fields free text is matched against with their
+// boosts. Fields boosted to 0 aren't searched.
+func textFields(boosts db.FieldBoosts) []string {
+    var fields []string
+    for _, field := range []struct {
+        name  string
+        boost float64
+    }{
+        {"description", boosts.Description},
+        {"original_filename.text", boosts.Filename},
+        {"tags.text", boosts.Tags},
+    } {
+        if field.boost > 0 {
+            fields = append(fields, fmt.Sprintf("%s^%g", field.name, field.boost))
+        }
+    }
+    if boosts.Description > 0 {
+        fields = append(fields, languageFields(boosts.Description)...)
+    }
+    if len(fields) == 0 {
+        fields = []string{"description"}
+    }
+    return fields
+}
+
+// dateRangeFilter returns a range query for the date filter, or nil if it
+// has no bounds.
+func dateRangeFilter(dates db.DateFilter) map[string]interface{} {
+    if dates.IsZero() {
+        return nil
+    }
+    field := "created_at"
+    if dates.Taken {
+        field = "taken_at"
+    }
+    bounds := map[string]interface{}{}
+    if dates.From != nil {
+        bounds["gte"] = indexTime(*dates.From)
+    }
+    if dates.To != nil {
+        bounds["lt"] = indexTime(*dates.To)
+    }
+    return map[string]interface{}{
+        "range": map[string]interface{}{field: bounds},
+    }
+}
+
+// geoFilters returns the bounding box and distance queries for the
+// location filter.
+func geoFilters(geo db.GeoFilter) []map[string]interface{} {
+    var filters []map[string]interface{}
+    if box := geo.Box; box != nil {
+        filters = append(filters, map[string]interface{}{
+            "geo_bounding_box": map[string]interface{}{
+                "location": map[string]interface{}{
+                    "top_left":     geoPoint{Lat: box.North, Lon: box.West},
+                    "bottom_right": geoPoint{Lat: box.South, Lon: box.East},
+                },
+            },
+        })
+    }
+    if center := geo.Center; center != nil {
+        filters = append(filters, map[string]interface{}{
+            "geo_distance": map[string]interface{}{
+                "distance": fmt.Sprintf("%gm", geo.Radius),
+                "location": geoPoint{Lat: center.Latitude, Lon: center.Longitude},
+            },
+        })
+    }
+    return filters
+}
+
+// RelatedImages uses more_like_this with the indexed image as the example,
+// which leaves the image itself out of the results.
+func (elasticsearchBackend) RelatedImages(image *db.Image, limit int) ([]SearchResult, error) {
+    searchQuery := map[string]interface{}{
+        "size": limit,
+        "query": map[string]interface{}{
+            "more_like_this": map[string]interface{}{
+                "fields": []string{"description", "tags"},
+                "like": []map[string]interface{}{
+                    {"_index": getIndexName(), "_id": strconv.FormatInt(image.ID, 10)},
+                },
+                // Collections are small, so don't require terms to be common
+                "min_term_freq":   1,
+                "min_doc_freq":    1,
+                "max_query_terms": 25,
+            },
+        },
+    }
+
+    var buf bytes.Buffer
+    if err := json.NewEncoder(&buf).Encode(searchQuery); err != nil {
+        return nil, err
+    }
+
+    res, err := esClient.Search(
+        esClient.Search.WithContext(context.Background()),
+        esClient.Search.WithIndex(getIndexName()),
+        esClient.Search.WithBody(&buf),
+    )
+    if err != nil {
+        return nil, transportError(err)
+    }
+    defer res.Body.Close()
+
+    if res.IsError() {
+        return nil, responseError(res)
+    }
+
+    var result searchResponse
+    if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+        return nil, fmt.Errorf("error decoding related images: %v", err)
+    }
+
+    var searchResults []SearchResult
+    for _, hit := range result.Hits.Hits {
+        searchResults = append(searchResults, hit.Source.searchResult())
+    }
     return searchResults, nil
 }
 
-func interfaceArrayToStringArray(arr []interface{}) []string {
-    result := make([]string, len(arr))
-    for i, v := range arr {
-        result[i] = getString(v)
+// SimilarImages runs an approximate kNN search over the image embeddings.
+func (elasticsearchBackend) SimilarImages(vector []float32, excludeID int64, limit int) ([]SearchResult, error) {
+    knn := map[string]interface{}{
+        "field":          "embedding",
+        "query_vector":   vector,
+        "k":              limit,
+        "num_candidates": max(limit*10, 100),
+    }
+    if excludeID != 0 {
+        knn["filter"] = map[string]interface{}{
+            "bool": map[string]interface{}{
+                "must_not": map[string]interface{}{"term": map[string]interface{}{"id": excludeID}},
+            },
+        }
+    }
+    searchQuery := map[string]interface{}{
+        "size":    limit,
+        "knn":     knn,
+        "_source": map[string]interface{}{"excludes": []string{"embedding"}},
+    }
+
+    var buf bytes.Buffer
+    if err := json.NewEncoder(&buf).Encode(searchQuery); err != nil {
+        return nil, err
+    }
+
+    res, err := esClient.Search(
+        esClient.Search.WithContext(context.Background()),
+        esClient.Search.WithIndex(getIndexName()),
+        esClient.Search.WithBody(&buf),
+    )
+    if err != nil {
+        return nil, transportError(err)
+    }
+    defer res.Body.Close()
+
+    if res.IsError() {
+        return nil, responseError(res)
+    }
+
+    var result searchResponse
+    if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+        return nil, fmt.Errorf("error decoding similar images: %v", err)
     }
-    return result
+
+    var searchResults []SearchResult
+    for _, hit := range result.Hits.Hits {
+        searchResults = append(searchResults, hit.Source.searchResult())
+    }
+    return searchResults, nil
 }
 
-func getString(v interface{}) string {
-    if v == nil {
-        return ""
+// rankedQuery wraps query in a function_score that multiplies relevance by
+// one plus the popularity and recency boosts.
+func rankedQuery(query interface{}, r db.SearchRanking) interface{} {
+    functions := []map[string]interface{}{
+        {"weight": 1},
+    }
+    if r.PopularityWeight > 0 {
+        functions = append(functions, map[string]interface{}{
+            "field_value_factor": map[string]interface{}{
+                "field":    "view_count",
+                "modifier": "ln1p",
+                "missing":  0,
+            },
+            "weight": r.PopularityWeight,
+        })
+    }
+    if r.RecencyWeight > 0 && r.RecencyScale > 0 {
+        functions = append(functions, map[string]interface{}{
+            "exp": map[string]interface{}{
+                "created_at": map[string]interface{}{
+                    "origin": "now",
+                    "scale":  fmt.Sprintf("%ds", int64(r.RecencyScale.Seconds())),
+                    "decay":  0.5,
+                },
+            },
+            "weight": r.RecencyWeight,
+        })
     }
-    switch v := v.(type) {
-    case string:
-        return v
-    case float64:
-        return fmt.Sprintf("%v", v)
-    default:
-        return fmt.Sprintf("%v", v)
+    if len(functions) == 1 {
+        return query
+    }
+
+    return map[string]interface{}{
+        "function_score": map[string]interface{}{
+            "query":      query,
+            "functions":  functions,
+            "score_mode": "sum",
+            "boost_mode": "multiply",
+        },
     }
 }
 
-func getInt64Value(v interface{}) int64 {
-    if v == nil {
-        return 0
+// DidYouMean asks a phrase suggester over descriptions for a corrected
+// query, falling back to correcting single words against descriptions and
+// tags.
+func (elasticsearchBackend) DidYouMean(q string) (string, error) {
+    termSuggester := func(field string) map[string]interface{} {
+        return map[string]interface{}{
+            "term": map[string]interface{}{
+                "field":        field,
+                "analyzer":     "standard",
+                "suggest_mode": "missing",
+                "size":         1,
+            },
+        }
+    }
+    searchQuery := map[string]interface{}{
+        "size": 0,
+        "suggest": map[string]interface{}{
+            "text": q,
+            "description_phrase": map[string]interface{}{
+                "phrase": map[string]interface{}{
+                    "field":      "description",
+                    "size":       1,
+                    "gram_size":  1,
+                    "max_errors": 2,
+                    "direct_generator": []map[string]interface{}{
+                        {"field": "description", "suggest_mode": "missing"},
+                    },
+                },
+            },
+            "description_terms": termSuggester("description"),
+            "tag_terms":         termSuggester("tags"),
+        },
+    }
+
+    var buf bytes.Buffer
+    if err := json.NewEncoder(&buf).Encode(searchQuery); err != nil {
+        return "", err
+    }
+
+    res, err := esClient.Search(
+        esClient.Search.WithContext(context.Background()),
+        esClient.Search.WithIndex(getIndexName()),
+        esClient.Search.WithBody(&buf),
+    )
+    if err != nil {
+        return "", transportError(err)
+    }
+    defer res.Body.Close()
+
+    if res.IsError() {
+        return "", responseError(res)
     }
-    switch v := v.(type) {
-    case float64:
-        return int64(v)
-    case string:
-        if val, err := strconv.ParseInt(v, 10, 64); err == nil {
-            return val
+
+    var result searchResponse
+    if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+        return "", fmt.Errorf("error decoding spelling suggestions: %v", err)
+    }
+
+    for _, entry := range result.Suggest["description_phrase"] {
+        if len(entry.Options) > 0 {
+            return entry.Options[0].Text, nil
+        }
+    }
+
+    // Take the best correction offered for each word from either field
+    best := make(map[int]float64)
+    corrections := make(map[int]textCorrection)
+    for _, name := range []string{"description_terms", "tag_terms"} {
+        for _, entry := range result.Suggest[name] {
+            if len(entry.Options) == 0 {
+                continue
+            }
+            option := entry.Options[0]
+            if score, ok := best[entry.Offset]; !ok || option.Score > score {
+                best[entry.Offset] = option.Score
+                corrections[entry.Offset] = textCorrection{Offset: entry.Offset, Length: entry.Length, Text: option.Text}
+            }
         }
-    case int64:
-        return v
-    case int:
-        return int64(v)
     }
-    return 0
+    if len(corrections) == 0 {
+        return "", nil
+    }
+
+    list := make([]textCorrection, 0, len(corrections))
+    for _, correction := range corrections {
+        list = append(list, correction)
+    }
+    return applyCorrections(q, list), nil
+}
+
+func (b elasticsearchBackend) IndexImage(image *db.Image) error {
+    if err := indexDocument(image); err != nil {
+        return err
+    }
+    // The image itself is indexed, so a stale suggestion count is not
+    // worth failing the request over
+    if err := b.SyncTags(image.Tags); err != nil {
+        log.Printf("Error updating tag suggestions for image %d: %v", image.ID, err)
+    }
+    return nil
+}
+
+// UpdateViewCount updates the view count in place, indexing the whole
+// image only if it is missing from the index.
+func (b elasticsearchBackend) UpdateViewCount(image *db.Image) error {
+    var buf bytes.Buffer
+    update := map[string]interface{}{"doc": map[string]interface{}{"view_count": image.ViewCount}}
+    if err := json.NewEncoder(&buf).Encode(update); err != nil {
+        return err
+    }
+
+    res, err := esClient.Update(
+        getIndexName(),
+        fmt.Sprintf("%d", image.ID),
+        &buf,
+        esClient.Update.WithContext(context.Background()),
+    )
+    if err != nil {
+        return transportError(err)
+    }
+    defer res.Body.Close()
+
+    if res.StatusCode == http.StatusNotFound {
+        return b.IndexImage(image)
+    }
+    if res.IsError() {
+        return fmt.Errorf("error updating view count: %w", responseError(res))
+    }
+    return nil
 }
 
-func IndexImage(image *db.Image) error {
-    document := map[string]interface{}{
-        "id":               image.ID,
-        "original_filename": image.OriginalFilename,
-        "uuid_filename":     image.UUIDFilename,
-        "description":      image.Description,
-        "tags":             image.Tags,
-        "storage_path":      image.StoragePath,
-        "created_at":       image.CreatedAt,
-        "view_count":       image.ViewCount,
+func indexDocument(image *db.Image) error {
+    if err := ensureIndexes(); err != nil {
+        return err
     }
 
     var buf bytes.Buffer
-    if err := json.NewEncoder(&buf).Encode(document); err != nil {
+    if err := json.NewEncoder(&buf).Encode(newDocument(image)); err != nil {
         return err
     }
 
@@ -211,12 +591,12 @@ func IndexImage(image *db.Image) error {
         esClient.Index.WithContext(context.Background()),
     )
     if err != nil {
-        return err
+        return transportError(err)
     }
     defer res.Body.Close()
 
     if res.IsError() {
-        return fmt.Errorf("error indexing document: %s", res.String())
+        return fmt.Errorf("error indexing document: %w", responseError(res))
     }
 
     return nil
@@ -225,64 +605,126 @@ func IndexImage(image *db.Image) error {
 func DeleteIndex() error {
     res, err := esClient.Indices.Delete([]string{getIndexName()})
     if err != nil {
-        return err
+        return transportError(err)
     }
     defer res.Body.Close()
 
-    if res.IsError() {
-        return fmt.Errorf("error deleting index: %s", res.String())
+    // A missing index is already in the state we want
+    if res.IsError() && res.StatusCode != http.StatusNotFound {
+        return fmt.Errorf("error deleting index: %w", responseError(res))
     }
     return nil
 }
 
-func createIndexMapping() error {
-    mapping := `{
+// Filenames are indexed whole and split into words at punctuation, case
+// changes and digits, so IMG_4021.jpg matches "IMG_4021", "img" and "4021".
+// Queries are only split, as fuzzy matching can't follow the branching
+// token graph preserving the original produces.
+//
+// Descriptions are indexed accent-folded, unchanged in description.exact,
+// and stemmed for the image's language in description_by_language. That
+// object is not dynamic, so a language without an analyzer is never mapped
+// as plain text.
+func indexMapping() string {
+    languageFilters, languageAnalyzers, languageProperties := languageAnalysis()
+    return fmt.Sprintf(`{
+        "settings": {
+            "analysis": {
+                "filter": {
+                    "filename_parts": { "type": "word_delimiter_graph", "preserve_original": true },
+                    "filename_query_parts": { "type": "word_delimiter_graph" },
+                    %s
+                },
+                "analyzer": {
+                    "filename": { "tokenizer": "keyword", "filter": ["filename_parts", "lowercase", "flatten_graph"] },
+                    "filename_query": { "tokenizer": "keyword", "filter": ["filename_query_parts", "lowercase"] },
+                    "folded": { "tokenizer": "standard", "filter": ["lowercase", "asciifolding"] },
+                    %s
+                }
+            }
+        },
         "mappings": {
             "properties": {
                 "id": { "type": "long" },
-                "original_filename": { "type": "keyword" },
+                "original_filename": {
+                    "type": "keyword",
+                    "fields": {
+                        "text": { "type": "text", "analyzer": "filename", "search_analyzer": "filename_query" }
+                    }
+                },
                 "uuid_filename": { "type": "keyword" },
-                "description": { "type": "text", "analyzer": "standard" },
+                "description": {
+                    "type": "text",
+                    "analyzer": "folded",
+                    "fields": {
+                        "exact": { "type": "text", "analyzer": "standard" }
+                    }
+                },
+                "language": { "type": "keyword" },
+                "description_by_language": {
+                    "dynamic": false,
+                    "properties": { %s }
+                },
                 "url": { "type": "keyword", "index": false },
-                "tags": { "type": "keyword" },
+                "tags": {
+                    "type": "keyword",
+                    "fields": {
+                        "text": { "type": "text", "analyzer": "standard" }
+                    }
+                },
                 "storage_path": { "type": "keyword" },
-                "created_at": { "type": "date" },
-                "view_count": { "type": "integer" }
+                "created_at": { "type": "date", "format": "strict_date_time||epoch_millis" },
+                "view_count": { "type": "integer" },
+                "taken_at": { "type": "date", "format": "strict_date_time||epoch_millis" },
+                "location": { "type": "geo_point" },
+                "albums": { "type": "long" },
+                "embedding": { "type": "dense_vector", "dims": %d, "index": true, "similarity": "cosine" }
             }
         }
-    }`
+    }`, languageFilters, languageAnalyzers, languageProperties, features.Dims)
+}
+
+func createIndexMapping() error {
+    mapping := indexMapping()
 
     res, err := esClient.Indices.Create(
         getIndexName(),
         esClient.Indices.Create.WithBody(strings.NewReader(mapping)),
     )
     if err != nil {
-        return err
+        return transportError(err)
     }
     defer res.Body.Close()
 
     if res.IsError() {
-        return fmt.Errorf("error creating index mapping: %s", res.String())
+        return fmt.Errorf("error creating index mapping: %w", responseError(res))
     }
     return nil
 }
 
-func ReindexAll(images []db.Image) error {
+func (b elasticsearchBackend) ReindexAll(images []db.Image) error {
     // Delete existing index
     if err := DeleteIndex(); err != nil {
-        return fmt.Errorf("failed to delete index: %v", err)
+        return fmt.Errorf("failed to delete index: %w", err)
     }
 
     // Create new index with mapping
     if err := createIndexMapping(); err != nil {
-        return fmt.Errorf("failed to create index mapping: %v", err)
+        return fmt.Errorf("failed to create index mapping: %w", err)
     }
 
     // Reindex all images
     for _, image := range images {
-        if err := IndexImage(&image); err != nil {
-            return fmt.Errorf("failed to index image %d: %v", image.ID, err)
+        if err := indexDocument(&image); err != nil {
+            return fmt.Errorf("failed to index image %d: %w", image.ID, err)
         }
     }
+
+    if err := rebuildTagIndex(); err != nil {
+        return fmt.Errorf("failed to rebuild tag index: %w", err)
+    }
+    if err := rebuildPercolatorIndex(); err != nil {
+        return fmt.Errorf("failed to rebuild saved search index: %w", err)
+    }
     return nil
 }
\ No newline at end of file
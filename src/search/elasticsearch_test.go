@@ -0,0 +1,89 @@
+package search
+
+import (
+    "reflect"
+    "testing"
+    "time"
+    "github.com/grrywlsn/imagerr/src/db"
+)
+
+func TestTextFields(t *testing.T) {
+    defer func(enabled []string) { languages = enabled }(languages)
+    languages = []string{"english", "german"}
+
+    for _, test := range []struct {
+        name   string
+        boosts db.FieldBoosts
+        want   []string
+    }{
+        {
+            "all fields",
+            db.FieldBoosts{Description: 1, Filename: 2, Tags: 1.5},
+            []string{
+                "description^1", "original_filename.text^2", "tags.text^1.5",
+                "description_by_language.english^1", "description_by_language.german^1",
+            },
+        },
+        {
+            "without description",
+            db.FieldBoosts{Filename: 2, Tags: 0.5},
+            []string{"original_filename.text^2", "tags.text^0.5"},
+        },
+        {
+            "description only",
+            db.FieldBoosts{Description: 3},
+            []string{"description^3", "description_by_language.english^3", "description_by_language.german^3"},
+        },
+        {"negative boosts are off", db.FieldBoosts{Description: -1, Filename: -1, Tags: 2}, []string{"tags.text^2"}},
+        // Something has to be searched
+        {"all off", db.FieldBoosts{}, []string{"description"}},
+    } {
+        if got := textFields(test.boosts); !reflect.DeepEqual(got, test.want) {
+            t.Errorf("%s: textFields = %q, want %q", test.name, got, test.want)
+        }
+    }
+}
+
+func TestRankedQuery(t *testing.T) {
+    query := map[string]interface{}{"match_all": map[string]interface{}{}}
+    popularity := map[string]interface{}{
+        "field_value_factor": map[string]interface{}{"field": "view_count", "modifier": "ln1p", "missing": 0},
+        "weight":             0.5,
+    }
+    recency := map[string]interface{}{
+        "exp": map[string]interface{}{
+            "created_at": map[string]interface{}{"origin": "now", "scale": "129600s", "decay": 0.5},
+        },
+        "weight": 2.0,
+    }
+    ranked := func(functions ...map[string]interface{}) interface{} {
+        return map[string]interface{}{
+            "function_score": map[string]interface{}{
+                "query":      query,
+                "functions":  append([]map[string]interface{}{{"weight": 1}}, functions...),
+                "score_mode": "sum",
+                "boost_mode": "multiply",
+            },
+        }
+    }
+
+    for _, test := range []struct {
+        name string
+        r    db.SearchRanking
+        want interface{}
+    }{
+        {"relevance only", db.SearchRanking{}, query},
+        {"recency without a scale", db.SearchRanking{RecencyWeight: 2}, query},
+        {"popularity", db.SearchRanking{PopularityWeight: 0.5}, ranked(popularity)},
+        {"recency", db.SearchRanking{RecencyWeight: 2, RecencyScale: 36 * time.Hour}, ranked(recency)},
+        {
+            "both",
+            db.SearchRanking{PopularityWeight: 0.5, RecencyWeight: 2, RecencyScale: 36 * time.Hour},
+            ranked(popularity, recency),
+        },
+    } {
+        if got := rankedQuery(query, test.r); !reflect.DeepEqual(got, test.want) {
+            t.Errorf("%s: rankedQuery = %v, want %v", test.name, got, test.want)
+        }
+    }
+}
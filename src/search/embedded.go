@@ -0,0 +1,619 @@
+package search
+
+import (
+    "encoding/json"
+    "fmt"
+    "log"
+    "math"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+    "unicode"
+    "github.com/grrywlsn/imagerr/src/db"
+)
+
+const (
+    embeddedIndexFile    = "images.json"
+    embeddedSearchLimit  = 10
+    embeddedSuggestLimit = 10
+    embeddedRecentLimit  = 9
+
+    // Single image writes are snapshotted together after this delay. The
+    // database is the source of truth, so writes lost to a crash before
+    // then come back with the next reindex.
+    embeddedSaveDelay = 2 * time.Second
+)
+
+// embeddedBackend keeps the whole index in memory and snapshots it to
+// SEARCH_DATA_DIR, so small installs can search without Elasticsearch. It
+// matches words unstemmed, whatever an image's language.
+type embeddedBackend struct {
+    mu      sync.RWMutex
+    dir     string
+    docs    map[int64]*embeddedDocument
+    docFreq map[string]int
+
+    // saveTimer is set while a snapshot is scheduled. saveMu is held
+    // while a snapshot is taken and written.
+    saveTimer *time.Timer
+    saveMu    sync.Mutex
+}
+
+type embeddedDocument struct {
+    Result    SearchResult `json:"result"`
+    Embedding []float32    `json:"embedding,omitempty"`
+    terms     []string
+
+    // Filenames and tags are matched without idf weighting
+    filenameTerms []string
+    tagTerms      []string
+}
+
+func newEmbeddedBackend() *embeddedBackend {
+    dir := os.Getenv("SEARCH_DATA_DIR")
+    if dir == "" {
+        dir = "data/search"
+    }
+    return &embeddedBackend{
+        dir:     dir,
+        docs:    make(map[int64]*embeddedDocument),
+        docFreq: make(map[string]int),
+    }
+}
+
+func (b *embeddedBackend) path() string {
+    return filepath.Join(b.dir, embeddedIndexFile)
+}
+
+// load reads the snapshot from disk. It reports false when no snapshot
+// exists yet so the caller can build one from the database.
+func (b *embeddedBackend) load() (bool, error) {
+    data, err := os.ReadFile(b.path())
+    if os.IsNotExist(err) {
+        return false, nil
+    }
+    if err != nil {
+        return false, err
+    }
+
+    var docs []*embeddedDocument
+    if err := json.Unmarshal(data, &docs); err != nil {
+        return false, fmt.Errorf("error reading search index %s: %v", b.path(), err)
+    }
+
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    for _, doc := range docs {
+        b.add(doc)
+    }
+    return true, nil
+}
+
+// save writes the snapshot to a temporary file and renames it into place.
+// Snapshots are taken and written one at a time, so an older one never
+// replaces a newer one. Callers must not hold b.mu.
+func (b *embeddedBackend) save() error {
+    b.saveMu.Lock()
+    defer b.saveMu.Unlock()
+
+    b.mu.RLock()
+    docs := make([]*embeddedDocument, 0, len(b.docs))
+    for _, doc := range b.docs {
+        docs = append(docs, doc)
+    }
+    sort.Slice(docs, func(i, j int) bool { return docs[i].Result.ID < docs[j].Result.ID })
+    data, err := json.Marshal(docs)
+    b.mu.RUnlock()
+    if err != nil {
+        return err
+    }
+
+    // Searches can go on while the file is written
+    if err := os.MkdirAll(b.dir, 0o755); err != nil {
+        return err
+    }
+    tmp := b.path() + ".tmp"
+    if err := os.WriteFile(tmp, data, 0o644); err != nil {
+        return err
+    }
+    return os.Rename(tmp, b.path())
+}
+
+// scheduleSave saves the snapshot once embeddedSaveDelay has passed, so a
+// run of single image writes rewrites the file once rather than each time.
+// Callers must hold b.mu.
+func (b *embeddedBackend) scheduleSave() {
+    if b.saveTimer != nil {
+        return
+    }
+    b.saveTimer = time.AfterFunc(embeddedSaveDelay, func() {
+        b.mu.Lock()
+        b.saveTimer = nil
+        b.mu.Unlock()
+
+        if err := b.save(); err != nil {
+            log.Printf("Error saving embedded search index: %v", err)
+        }
+    })
+}
+
+// add and remove keep docFreq in step with docs. Callers must hold b.mu.
+func (b *embeddedBackend) add(doc *embeddedDocument) {
+    b.remove(doc.Result.ID)
+    doc.terms = tokenize(doc.Result.Description)
+    doc.filenameTerms = filenameTerms(doc.Result.OriginalFilename)
+    doc.tagTerms = tokenize(strings.Join(doc.Result.Tags, " "))
+    for _, term := range uniqueTerms(doc.terms) {
+        b.docFreq[term]++
+    }
+    b.docs[doc.Result.ID] = doc
+}
+
+func (b *embeddedBackend) remove(id int64) {
+    existing, ok := b.docs[id]
+    if !ok {
+        return
+    }
+    for _, term := range uniqueTerms(existing.terms) {
+        b.docFreq[term]--
+        if b.docFreq[term] <= 0 {
+            delete(b.docFreq, term)
+        }
+    }
+    delete(b.docs, id)
+}
+
+func (b *embeddedBackend) Name() string {
+    return "embedded"
+}
+
+func (b *embeddedBackend) Ping() error {
+    return nil
+}
+
+func (b *embeddedBackend) SearchImages(params SearchParams) ([]SearchResult, error) {
+    q, tags := params.Query, params.Tags
+
+    b.mu.RLock()
+    defer b.mu.RUnlock()
+
+    type scored struct {
+        result SearchResult
+        score  float64
+    }
+    var matches []scored
+
+    if q == "" && tags == "" {
+        limit := embeddedRecentLimit
+        if params.Filtered() {
+            limit = embeddedSearchLimit
+        }
+        for _, doc := range b.docs {
+            if matchesFilters(doc.Result, params) {
+                matches = append(matches, scored{result: doc.Result, score: float64(doc.Result.ID)})
+            }
+        }
+        sort.Slice(matches, func(i, j int) bool { return matches[i].score > matches[j].score })
+        if len(matches) > limit {
+            matches = matches[:limit]
+        }
+    } else {
+        var tagList []string
+        if tags != "" {
+            tagList = strings.Split(tags, ",")
+        }
+        queryTerms, filenameQueryTerms := tokenize(q), splitWords(q)
+        r, now := rankingFor(params), time.Now()
+
+        for _, doc := range b.docs {
+            if !matchesFilters(doc.Result, params) {
+                continue
+            }
+            score := 0.0
+            if hasAnyTag(doc.Result.Tags, tagList) {
+                score += 2.0
+            }
+            score += r.Fields.Description * b.scoreTerms(queryTerms, doc.terms)
+            score += r.Fields.Filename * matchTerms(filenameQueryTerms, doc.filenameTerms)
+            score += r.Fields.Tags * matchTerms(queryTerms, doc.tagTerms)
+            if score > 0 {
+                matches = append(matches, scored{result: doc.Result, score: boostScore(score, doc.Result, r, now)})
+            }
+        }
+        sort.Slice(matches, func(i, j int) bool {
+            if matches[i].score != matches[j].score {
+                return matches[i].score > matches[j].score
+            }
+            return matches[i].result.ID > matches[j].result.ID
+        })
+        if len(matches) > embeddedSearchLimit {
+            matches = matches[:embeddedSearchLimit]
+        }
+    }
+
+    var searchResults []SearchResult
+    for _, match := range matches {
+        searchResults = append(searchResults, match.result)
+    }
+    return searchResults, nil
+}
+
+// scoreTerms gives each query term the idf of its best match in the
+// document, discounted by edit distance to approximate fuzziness AUTO.
+func (b *embeddedBackend) scoreTerms(queryTerms, docTerms []string) float64 {
+    score := 0.0
+    for _, queryTerm := range queryTerms {
+        maxEdits := fuzzyEdits(queryTerm)
+        best := 0.0
+        for _, docTerm := range docTerms {
+            distance := levenshtein(queryTerm, docTerm, maxEdits)
+            if distance > maxEdits {
+                continue
+            }
+            idf := math.Log(1 + float64(len(b.docs))/float64(1+b.docFreq[docTerm]))
+            if s := idf / float64(1+distance); s > best {
+                best = s
+            }
+        }
+        score += best
+    }
+    return score
+}
+
+// matchTerms counts the query terms found in a field, discounting fuzzy
+// matches by their edit distance.
+func matchTerms(queryTerms, fieldTerms []string) float64 {
+    score := 0.0
+    for _, queryTerm := range queryTerms {
+        maxEdits := fuzzyEdits(queryTerm)
+        best := 0.0
+        for _, fieldTerm := range fieldTerms {
+            if distance := levenshtein(queryTerm, fieldTerm, maxEdits); distance <= maxEdits {
+                best = math.Max(best, 1/float64(1+distance))
+            }
+        }
+        score += best
+    }
+    return score
+}
+
+func (b *embeddedBackend) DidYouMean(q string) (string, error) {
+    b.mu.RLock()
+    defer b.mu.RUnlock()
+
+    terms := make(map[string]int, len(b.docFreq))
+    for term, count := range b.docFreq {
+        terms[term] = count
+    }
+    for _, doc := range b.docs {
+        for _, tag := range doc.Result.Tags {
+            for _, term := range tokenize(tag) {
+                terms[term]++
+            }
+        }
+    }
+    return correctQuery(q, terms), nil
+}
+
+func (b *embeddedBackend) SuggestTags(query string, recent []string) ([]string, error) {
+    b.mu.RLock()
+    defer b.mu.RUnlock()
+
+    counts := make(map[string]int)
+    for _, doc := range b.docs {
+        for _, tag := range doc.Result.Tags {
+            counts[tag]++
+        }
+    }
+    return rankTagSuggestions(query, counts, recent, embeddedSuggestLimit), nil
+}
+
+func (b *embeddedBackend) RelatedTags(tags []string, limit int) ([]string, error) {
+    b.mu.RLock()
+    defer b.mu.RUnlock()
+
+    counts := make(map[string]int)
+    for _, doc := range b.docs {
+        if !hasAllTags(doc.Result.Tags, tags) {
+            continue
+        }
+        for _, tag := range doc.Result.Tags {
+            if !containsString(tags, tag) {
+                counts[tag]++
+            }
+        }
+    }
+
+    related := []string{}
+    for tag := range counts {
+        related = append(related, tag)
+    }
+    sort.Slice(related, func(i, j int) bool {
+        if counts[related[i]] != counts[related[j]] {
+            return counts[related[i]] > counts[related[j]]
+        }
+        return related[i] < related[j]
+    })
+    if len(related) > limit {
+        related = related[:limit]
+    }
+    return related, nil
+}
+
+// RelatedImages scores other images by the tags they share with image plus
+// the weight of the description words they share.
+func (b *embeddedBackend) RelatedImages(image *db.Image, limit int) ([]SearchResult, error) {
+    b.mu.RLock()
+    defer b.mu.RUnlock()
+
+    terms := uniqueTerms(tokenize(image.Description))
+    type scored struct {
+        result SearchResult
+        score  float64
+    }
+    var matches []scored
+    for id, doc := range b.docs {
+        if id == image.ID {
+            continue
+        }
+        score := 0.0
+        for _, tag := range image.Tags {
+            if containsString(doc.Result.Tags, tag) {
+                score += 2.0
+            }
+        }
+        for _, term := range terms {
+            if containsString(doc.terms, term) {
+                score += math.Log(1 + float64(len(b.docs))/float64(1+b.docFreq[term]))
+            }
+        }
+        if score > 0 {
+            matches = append(matches, scored{result: doc.Result, score: score})
+        }
+    }
+    sort.Slice(matches, func(i, j int) bool {
+        if matches[i].score != matches[j].score {
+            return matches[i].score > matches[j].score
+        }
+        return matches[i].result.ID > matches[j].result.ID
+    })
+    if len(matches) > limit {
+        matches = matches[:limit]
+    }
+
+    var searchResults []SearchResult
+    for _, match := range matches {
+        searchResults = append(searchResults, match.result)
+    }
+    return searchResults, nil
+}
+
+func (b *embeddedBackend) SimilarImages(vector []float32, excludeID int64, limit int) ([]SearchResult, error) {
+    b.mu.RLock()
+    defer b.mu.RUnlock()
+
+    embeddings := make(map[int64][]float32)
+    for id, doc := range b.docs {
+        embeddings[id] = doc.Embedding
+    }
+
+    var searchResults []SearchResult
+    for _, id := range nearestImages(vector, embeddings, excludeID, limit) {
+        searchResults = append(searchResults, b.docs[id].Result)
+    }
+    return searchResults, nil
+}
+
+func (b *embeddedBackend) IndexSavedSearch(saved *db.SavedSearch) error {
+    return nil
+}
+
+func (b *embeddedBackend) DeleteSavedSearch(id int64) error {
+    return nil
+}
+
+func (b *embeddedBackend) MatchSavedSearches(image *db.Image, searches []db.SavedSearch) ([]int64, error) {
+    return matchSavedSearches(image, searches), nil
+}
+
+func (b *embeddedBackend) SyncTags(names []string) error {
+    return nil
+}
+
+func (b *embeddedBackend) IndexImage(image *db.Image) error {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    b.add(&embeddedDocument{Result: imageToSearchResult(*image), Embedding: image.Embedding})
+    b.scheduleSave()
+    return nil
+}
+
+// UpdateViewCount changes the count in memory, leaving it to the next
+// snapshot.
+func (b *embeddedBackend) UpdateViewCount(image *db.Image) error {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    doc, ok := b.docs[image.ID]
+    if !ok {
+        b.add(&embeddedDocument{Result: imageToSearchResult(*image), Embedding: image.Embedding})
+    } else {
+        doc.Result.ViewCount = image.ViewCount
+    }
+    b.scheduleSave()
+    return nil
+}
+
+// ReindexAll saves the rebuilt index straight away rather than scheduling
+// it, as it may be the first snapshot.
+func (b *embeddedBackend) ReindexAll(images []db.Image) error {
+    b.mu.Lock()
+    b.docs = make(map[int64]*embeddedDocument)
+    b.docFreq = make(map[string]int)
+    for _, image := range images {
+        b.add(&embeddedDocument{Result: imageToSearchResult(image), Embedding: image.Embedding})
+    }
+    b.mu.Unlock()
+
+    return b.save()
+}
+
+func tokenize(text string) []string {
+    return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+        return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+    })
+}
+
+// filenameTerms indexes a filename as the Elasticsearch filename analyzer
+// does: whole, lower cased, and split by splitWords. Unlike
+// word_delimiter_graph it keeps a trailing "'s" rather than stemming it.
+func filenameTerms(filename string) []string {
+    terms := splitWords(filename)
+    if original := strings.ToLower(filename); original != "" && (len(terms) != 1 || terms[0] != original) {
+        terms = append([]string{original}, terms...)
+    }
+    return terms
+}
+
+// splitWords lower cases text and splits it at anything other than letters
+// and digits, where lower case is followed by upper case and between
+// letters and digits, so "IMG_4021" and "PowerShot" give "img", "4021",
+// "power" and "shot". It follows word_delimiter_graph, which the filename
+// query analyzer uses.
+func splitWords(text string) []string {
+    words := []string{}
+    var word []rune
+    flush := func() {
+        if len(word) > 0 {
+            words = append(words, strings.ToLower(string(word)))
+            word = word[:0]
+        }
+    }
+    for _, r := range text {
+        if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+            flush()
+            continue
+        }
+        if len(word) > 0 {
+            last := word[len(word)-1]
+            if unicode.IsLower(last) && unicode.IsUpper(r) || unicode.IsDigit(last) != unicode.IsDigit(r) {
+                flush()
+            }
+        }
+        word = append(word, r)
+    }
+    flush()
+    return words
+}
+
+func uniqueTerms(terms []string) []string {
+    seen := make(map[string]bool)
+    var unique []string
+    for _, term := range terms {
+        if !seen[term] {
+            seen[term] = true
+            unique = append(unique, term)
+        }
+    }
+    return unique
+}
+
+// matchesFilters reports whether a result passes the date, location and
+// album filters of a search.
+func matchesFilters(result SearchResult, params SearchParams) bool {
+    if params.Album != 0 && !containsInt64(result.Albums, params.Album) {
+        return false
+    }
+    return inDateRange(result, params.Dates) && params.Geo.Contains(result.Latitude, result.Longitude)
+}
+
+func containsInt64(values []int64, value int64) bool {
+    for _, v := range values {
+        if v == value {
+            return true
+        }
+    }
+    return false
+}
+
+func inDateRange(result SearchResult, dates db.DateFilter) bool {
+    if dates.IsZero() {
+        return true
+    }
+    date := &result.CreatedAt
+    if dates.Taken {
+        date = result.TakenAt
+    }
+    if date == nil {
+        return false
+    }
+    return (dates.From == nil || !date.Before(*dates.From)) && (dates.To == nil || date.Before(*dates.To))
+}
+
+func hasAllTags(imageTags, tags []string) bool {
+    for _, tag := range tags {
+        if !containsString(imageTags, tag) {
+            return false
+        }
+    }
+    return true
+}
+
+func hasAnyTag(imageTags, tags []string) bool {
+    for _, tag := range tags {
+        for _, imageTag := range imageTags {
+            if tag == imageTag {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// fuzzyEdits follows Elasticsearch's AUTO fuzziness.
+func fuzzyEdits(term string) int {
+    switch n := len([]rune(term)); {
+    case n <= 2:
+        return 0
+    case n <= 5:
+        return 1
+    default:
+        return 2
+    }
+}
+
+// levenshtein returns the edit distance between a and b, or max+1 once the
+// distance is known to exceed max.
+func levenshtein(a, b string, max int) int {
+    ra, rb := []rune(a), []rune(b)
+    if diff := len(ra) - len(rb); diff > max || -diff > max {
+        return max + 1
+    }
+
+    prev := make([]int, len(rb)+1)
+    curr := make([]int, len(rb)+1)
+    for j := range prev {
+        prev[j] = j
+    }
+    for i := 1; i <= len(ra); i++ {
+        curr[0] = i
+        rowMin := curr[0]
+        for j := 1; j <= len(rb); j++ {
+            cost := 1
+            if ra[i-1] == rb[j-1] {
+                cost = 0
+            }
+            curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+            rowMin = min(rowMin, curr[j])
+        }
+        if rowMin > max {
+            return max + 1
+        }
+        prev, curr = curr, prev
+    }
+    return prev[len(rb)]
+}
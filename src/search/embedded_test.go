@@ -0,0 +1,231 @@
+package search
+
+import (
+    "reflect"
+    "testing"
+    "github.com/grrywlsn/imagerr/src/db"
+)
+
+func TestLevenshtein(t *testing.T) {
+    for _, test := range []struct {
+        a, b string
+        max  int
+        want int
+    }{
+        {"", "", 2, 0},
+        {"sunset", "sunset", 2, 0},
+        {"sunset", "sunsets", 2, 1},
+        {"sunset", "sunst", 2, 1},
+        {"sunset", "sumset", 2, 1},
+        {"kitten", "sitting", 3, 3},
+        {"flaw", "lawn", 2, 2},
+        {"", "abc", 3, 3},
+        // Past max the exact distance doesn't matter
+        {"kitten", "sitting", 2, 3},
+        {"sunset", "dog", 2, 3},
+        {"a", "abcdef", 1, 2},
+        // Runes, not bytes
+        {"café", "cafe", 1, 1},
+        {"straße", "strasse", 2, 2},
+    } {
+        if got := levenshtein(test.a, test.b, test.max); got != test.want {
+            t.Errorf("levenshtein(%q, %q, %d) = %d, want %d", test.a, test.b, test.max, got, test.want)
+        }
+    }
+}
+
+func TestTokenize(t *testing.T) {
+    for _, test := range []struct {
+        text string
+        want []string
+    }{
+        {"", []string{}},
+        {"Sunset over the Wall", []string{"sunset", "over", "the", "wall"}},
+        {"berlin-wall_2024.jpg", []string{"berlin", "wall", "2024", "jpg"}},
+        {"  Ünïcödé,  words!! ", []string{"ünïcödé", "words"}},
+        {"東京 タワー", []string{"東京", "タワー"}},
+    } {
+        if got := tokenize(test.text); !reflect.DeepEqual(got, test.want) {
+            t.Errorf("tokenize(%q) = %q, want %q", test.text, got, test.want)
+        }
+    }
+}
+
+func testEmbeddedBackend(descriptions ...string) *embeddedBackend {
+    b := &embeddedBackend{
+        docs:    make(map[int64]*embeddedDocument),
+        docFreq: make(map[string]int),
+    }
+    for i, description := range descriptions {
+        b.add(&embeddedDocument{Result: SearchResult{ID: int64(i + 1), Description: description}})
+    }
+    return b
+}
+
+func TestScoreTerms(t *testing.T) {
+    b := testEmbeddedBackend(
+        "sunset over the beach",
+        "sunset over the city",
+        "sunset in the mountains",
+        "a cat on the beach",
+    )
+    doc := tokenize("sunset over the beach")
+
+    if got := b.scoreTerms(nil, doc); got != 0 {
+        t.Errorf("no query terms scored %f, want 0", got)
+    }
+    if got := b.scoreTerms([]string{"dog"}, doc); got != 0 {
+        t.Errorf("unmatched term scored %f, want 0", got)
+    }
+
+    // Rarer words weigh more
+    sunset := b.scoreTerms([]string{"sunset"}, doc)
+    beach := b.scoreTerms([]string{"beach"}, doc)
+    if sunset <= 0 || beach <= sunset {
+        t.Errorf("beach scored %f and sunset %f, want beach above sunset above 0", beach, sunset)
+    }
+
+    // A typo matches at a discount
+    typo := b.scoreTerms([]string{"beech"}, doc)
+    if typo <= 0 || typo >= beach {
+        t.Errorf("beech scored %f, want between 0 and beach's %f", typo, beach)
+    }
+
+    // Short terms must match exactly, like fuzziness AUTO
+    if got := b.scoreTerms([]string{"th"}, doc); got != 0 {
+        t.Errorf("two letter typo scored %f, want 0", got)
+    }
+
+    // Each query term counts its best match once
+    both := b.scoreTerms([]string{"sunset", "beach"}, doc)
+    if diff := both - (sunset + beach); diff > 1e-9 || diff < -1e-9 {
+        t.Errorf("sunset beach scored %f, want %f", both, sunset+beach)
+    }
+}
+
+func TestEmbeddedReindexAllSavesSnapshot(t *testing.T) {
+    b := testEmbeddedBackend()
+    b.dir = t.TempDir()
+    images := []db.Image{
+        {ID: 1, Description: "sunset over the beach", Tags: []string{"beach"}},
+        {ID: 2, Description: "a cat", Tags: []string{"cat"}},
+    }
+    if err := b.ReindexAll(images); err != nil {
+        t.Fatalf("ReindexAll: %v", err)
+    }
+
+    loaded := testEmbeddedBackend()
+    loaded.dir = b.dir
+    found, err := loaded.load()
+    if err != nil || !found {
+        t.Fatalf("load: found %v, err %v", found, err)
+    }
+    if !reflect.DeepEqual(loaded.docFreq, b.docFreq) {
+        t.Errorf("loaded docFreq %v, want %v", loaded.docFreq, b.docFreq)
+    }
+    if len(loaded.docs) != 2 || loaded.docs[2].Result.Description != "a cat" {
+        t.Errorf("loaded docs %v", loaded.docs)
+    }
+}
+
+func TestSplitWords(t *testing.T) {
+    for _, test := range []struct {
+        text string
+        want []string
+    }{
+        {"", []string{}},
+        {"sunset", []string{"sunset"}},
+        {"IMG_4021.jpg", []string{"img", "4021", "jpg"}},
+        {"PowerShot", []string{"power", "shot"}},
+        {"sunsetBeach2024", []string{"sunset", "beach", "2024"}},
+        {"4k-video", []string{"4", "k", "video"}},
+        // Only lower to upper case changes split
+        {"HTMLParser", []string{"htmlparser"}},
+        {"DSC01234.JPG", []string{"dsc", "01234", "jpg"}},
+        {"Ölberg_über", []string{"ölberg", "über"}},
+        {"  --  ", []string{}},
+    } {
+        if got := splitWords(test.text); !reflect.DeepEqual(got, test.want) {
+            t.Errorf("splitWords(%q) = %q, want %q", test.text, got, test.want)
+        }
+    }
+}
+
+func TestFilenameTerms(t *testing.T) {
+    for _, test := range []struct {
+        filename string
+        want     []string
+    }{
+        {"", []string{}},
+        {"sunset", []string{"sunset"}},
+        {"Sunset", []string{"sunset"}},
+        {"IMG_4021.jpg", []string{"img_4021.jpg", "img", "4021", "jpg"}},
+        {"sunsetBeach.png", []string{"sunsetbeach.png", "sunset", "beach", "png"}},
+    } {
+        if got := filenameTerms(test.filename); !reflect.DeepEqual(got, test.want) {
+            t.Errorf("filenameTerms(%q) = %q, want %q", test.filename, got, test.want)
+        }
+    }
+}
+
+func TestMatchTerms(t *testing.T) {
+    for _, test := range []struct {
+        query, field []string
+        want         float64
+    }{
+        {nil, []string{"cat"}, 0},
+        {[]string{"cat"}, nil, 0},
+        {[]string{"cat"}, []string{"cat"}, 1},
+        {[]string{"cat", "dog"}, []string{"dog", "cat"}, 2},
+        // Fuzzy matches are discounted by their edit distance
+        {[]string{"cats"}, []string{"cat"}, 0.5},
+        {[]string{"beach"}, []string{"bench", "beach"}, 1},
+        {[]string{"moutnains"}, []string{"mountains"}, 1.0 / 3},
+        // Too short for any edits
+        {[]string{"ct"}, []string{"cat"}, 0},
+    } {
+        if got := matchTerms(test.query, test.field); got != test.want {
+            t.Errorf("matchTerms(%q, %q) = %g, want %g", test.query, test.field, got, test.want)
+        }
+    }
+}
+
+func TestEmbeddedSearchSplitsFilenames(t *testing.T) {
+    b := testEmbeddedBackend()
+    b.add(&embeddedDocument{Result: SearchResult{ID: 1, OriginalFilename: "IMG_4021.jpg"}})
+    b.add(&embeddedDocument{Result: SearchResult{ID: 2, OriginalFilename: "PowerShotBeach.png"}})
+
+    for _, test := range []struct {
+        q    string
+        want int64
+    }{
+        {"4021", 1},
+        {"IMG4021", 1},
+        {"img_4021.jpg", 1},
+        {"beach", 2},
+        {"PowerShot", 2},
+    } {
+        results, err := b.SearchImages(SearchParams{Query: test.q, RelevanceOnly: true})
+        if err != nil {
+            t.Fatalf("SearchImages(%q): %v", test.q, err)
+        }
+        if len(results) == 0 || results[0].ID != test.want {
+            t.Errorf("SearchImages(%q) = %v, want image %d first", test.q, results, test.want)
+        }
+    }
+}
+
+func TestEmbeddedSuggestTagsMatchesInfixAndFuzzy(t *testing.T) {
+    b := testEmbeddedBackend()
+    for i, tags := range [][]string{{"category"}, {"concat"}, {"cart"}, {"dog"}} {
+        b.add(&embeddedDocument{Result: SearchResult{ID: int64(i + 1), Tags: tags}})
+    }
+
+    got, err := b.SuggestTags("cat", nil)
+    if err != nil {
+        t.Fatalf("SuggestTags: %v", err)
+    }
+    if want := []string{"category", "concat", "cart"}; !reflect.DeepEqual(got, want) {
+        t.Errorf("SuggestTags(cat) = %q, want %q", got, want)
+    }
+}
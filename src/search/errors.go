@@ -0,0 +1,69 @@
+package search
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// ErrUnavailable is returned, possibly wrapped, when the search backend
+// cannot be reached or is refusing requests.
+var ErrUnavailable = errors.New("search backend unavailable")
+
+// Error describes an error response returned by Elasticsearch.
+type Error struct {
+    StatusCode int
+    Type       string
+    Reason     string
+}
+
+func (e *Error) Error() string {
+    if e.Type == "" {
+        return fmt.Sprintf("elasticsearch returned status %d", e.StatusCode)
+    }
+    return fmt.Sprintf("elasticsearch returned status %d: %s: %s", e.StatusCode, e.Type, e.Reason)
+}
+
+// Unwrap lets errors.Is(err, ErrUnavailable) match overload and outage
+// responses as well as connection failures.
+func (e *Error) Unwrap() error {
+    if e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError {
+        return ErrUnavailable
+    }
+    return nil
+}
+
+type esErrorResponse struct {
+    Error struct {
+        Type      string `json:"type"`
+        Reason    string `json:"reason"`
+        RootCause []struct {
+            Type   string `json:"type"`
+            Reason string `json:"reason"`
+        } `json:"root_cause"`
+    } `json:"error"`
+}
+
+// responseError builds an *Error from an Elasticsearch error response,
+// preferring the root cause since it names the actual failure.
+func responseError(res *esapi.Response) error {
+    searchErr := &Error{StatusCode: res.StatusCode}
+
+    var body esErrorResponse
+    if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+        return searchErr
+    }
+    searchErr.Type = body.Error.Type
+    searchErr.Reason = body.Error.Reason
+    if len(body.Error.RootCause) > 0 && body.Error.RootCause[0].Reason != "" {
+        searchErr.Type = body.Error.RootCause[0].Type
+        searchErr.Reason = body.Error.RootCause[0].Reason
+    }
+    return searchErr
+}
+
+func transportError(err error) error {
+    return fmt.Errorf("%w: %v", ErrUnavailable, err)
+}
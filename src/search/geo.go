@@ -0,0 +1,112 @@
+package search
+
+import (
+    "errors"
+    "fmt"
+    "math"
+    "strconv"
+    "strings"
+    "github.com/grrywlsn/imagerr/src/db"
+)
+
+const defaultRadius = 10000
+
+var errNearWithoutRadius = errors.New("radius needs near, a point like 52.52,13.40")
+
+// ParseBoundingBox reads a bounding box given as west,south,east,north in
+// decimal degrees, the order GeoJSON uses. A box whose west edge is east of
+// its east edge crosses the antimeridian.
+func ParseBoundingBox(value string) (*db.BoundingBox, error) {
+    coords, err := parseCoordinates(value, 4)
+    if err != nil {
+        return nil, fmt.Errorf("invalid bbox %q, use west,south,east,north", value)
+    }
+    box := &db.BoundingBox{West: coords[0], South: coords[1], East: coords[2], North: coords[3]}
+    if !ValidLongitude(box.West) || !ValidLongitude(box.East) || !ValidLatitude(box.South) ||
+        !ValidLatitude(box.North) || box.South > box.North {
+        return nil, fmt.Errorf("invalid bbox %q, use west,south,east,north", value)
+    }
+    return box, nil
+}
+
+// ParseGeoFilter reads the bbox, near and radius parameters of a search.
+// near is a latitude,longitude point and radius a distance from it in
+// metres, or with a unit such as 500m or 2.5km. radius defaults to 10km.
+func ParseGeoFilter(bbox, near, radius string) (db.GeoFilter, error) {
+    var filter db.GeoFilter
+    var err error
+    if bbox != "" {
+        if filter.Box, err = ParseBoundingBox(bbox); err != nil {
+            return filter, err
+        }
+    }
+
+    if near == "" {
+        if radius != "" {
+            return filter, errNearWithoutRadius
+        }
+        return filter, nil
+    }
+    coords, err := parseCoordinates(near, 2)
+    if err != nil || !ValidLatitude(coords[0]) || !ValidLongitude(coords[1]) {
+        return filter, fmt.Errorf("invalid near %q, use latitude,longitude", near)
+    }
+    filter.Center = &db.GeoPoint{Latitude: coords[0], Longitude: coords[1]}
+
+    filter.Radius = defaultRadius
+    if radius != "" {
+        if filter.Radius, err = parseDistance(radius); err != nil {
+            return filter, err
+        }
+    }
+    return filter, nil
+}
+
+func parseCoordinates(value string, n int) ([]float64, error) {
+    parts := strings.Split(value, ",")
+    if len(parts) != n {
+        return nil, fmt.Errorf("want %d coordinates, got %d", n, len(parts))
+    }
+    coords := make([]float64, n)
+    for i, part := range parts {
+        coord, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+        if err != nil {
+            return nil, err
+        }
+        coords[i] = coord
+    }
+    return coords, nil
+}
+
+// parseDistance reads a distance in metres, kilometres or miles, returning
+// metres.
+func parseDistance(value string) (float64, error) {
+    number, scale := value, 1.0
+    for _, unit := range []struct {
+        suffix string
+        metres float64
+    }{
+        {"km", 1000},
+        {"mi", 1609.344},
+        {"m", 1},
+    } {
+        if strings.HasSuffix(value, unit.suffix) {
+            number, scale = strings.TrimSuffix(value, unit.suffix), unit.metres
+            break
+        }
+    }
+    // ParseFloat accepts NaN and Inf, which no distance comparison can use
+    distance, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+    if err != nil || distance <= 0 || math.IsInf(distance, 0) || math.IsNaN(distance) {
+        return 0, fmt.Errorf("invalid radius %q, use a distance like 500m or 2.5km", value)
+    }
+    return distance * scale, nil
+}
+
+func ValidLatitude(latitude float64) bool {
+    return latitude >= -90 && latitude <= 90
+}
+
+func ValidLongitude(longitude float64) bool {
+    return longitude >= -180 && longitude <= 180
+}
@@ -0,0 +1,84 @@
+package search
+
+import (
+    "reflect"
+    "testing"
+    "github.com/grrywlsn/imagerr/src/db"
+)
+
+func TestParseBoundingBox(t *testing.T) {
+    for _, test := range []struct {
+        value string
+        want  *db.BoundingBox
+    }{
+        {"13.0,52.3,13.8,52.7", &db.BoundingBox{West: 13, South: 52.3, East: 13.8, North: 52.7}},
+        {" -180 , -90 , 180 , 90 ", &db.BoundingBox{West: -180, South: -90, East: 180, North: 90}},
+        // Crossing the antimeridian
+        {"170,-20,-170,10", &db.BoundingBox{West: 170, South: -20, East: -170, North: 10}},
+        {"", nil},
+        {"13,52,14", nil},
+        {"13,52,14,53,1", nil},
+        {"a,52,14,53", nil},
+        {"181,52,14,53", nil},
+        {"13,-91,14,53", nil},
+        {"13,53,14,52", nil},
+        {"NaN,52,14,53", nil},
+        {"13,52,Inf,53", nil},
+    } {
+        got, err := ParseBoundingBox(test.value)
+        if test.want == nil {
+            if err == nil {
+                t.Errorf("ParseBoundingBox(%q) = %+v, want an error", test.value, got)
+            }
+            continue
+        }
+        if err != nil || !reflect.DeepEqual(got, test.want) {
+            t.Errorf("ParseBoundingBox(%q) = %+v, %v, want %+v", test.value, got, err, test.want)
+        }
+    }
+}
+
+func TestParseGeoFilter(t *testing.T) {
+    berlin := &db.GeoPoint{Latitude: 52.52, Longitude: 13.4}
+    for _, test := range []struct {
+        bbox, near, radius string
+        want               db.GeoFilter
+        wantErr            bool
+    }{
+        {"", "", "", db.GeoFilter{}, false},
+        {"13,52,14,53", "", "", db.GeoFilter{Box: &db.BoundingBox{West: 13, South: 52, East: 14, North: 53}}, false},
+        {"", "52.52,13.4", "", db.GeoFilter{Center: berlin, Radius: 10000}, false},
+        {"", "52.52, 13.4", "500", db.GeoFilter{Center: berlin, Radius: 500}, false},
+        {"", "52.52,13.4", "500m", db.GeoFilter{Center: berlin, Radius: 500}, false},
+        {"", "52.52,13.4", "2.5km", db.GeoFilter{Center: berlin, Radius: 2500}, false},
+        {"", "52.52,13.4", "1mi", db.GeoFilter{Center: berlin, Radius: 1609.344}, false},
+        {
+            "13,52,14,53", "52.52,13.4", "3km",
+            db.GeoFilter{Box: &db.BoundingBox{West: 13, South: 52, East: 14, North: 53}, Center: berlin, Radius: 3000},
+            false,
+        },
+        {"", "", "5km", db.GeoFilter{}, true},
+        {"", "52.52", "", db.GeoFilter{}, true},
+        {"", "13.4,181", "", db.GeoFilter{}, true},
+        {"", "91,13.4", "", db.GeoFilter{}, true},
+        {"", "52.52,13.4", "0", db.GeoFilter{}, true},
+        {"", "52.52,13.4", "-5km", db.GeoFilter{}, true},
+        {"", "52.52,13.4", "far", db.GeoFilter{}, true},
+        {"", "52.52,13.4", "km", db.GeoFilter{}, true},
+        {"", "52.52,13.4", "NaN", db.GeoFilter{}, true},
+        {"", "52.52,13.4", "Infkm", db.GeoFilter{}, true},
+        {"", "52.52,13.4", "+Inf", db.GeoFilter{}, true},
+        {"1,2,3", "52.52,13.4", "", db.GeoFilter{}, true},
+    } {
+        got, err := ParseGeoFilter(test.bbox, test.near, test.radius)
+        if test.wantErr {
+            if err == nil {
+                t.Errorf("ParseGeoFilter(%q, %q, %q) = %+v, want an error", test.bbox, test.near, test.radius, got)
+            }
+            continue
+        }
+        if err != nil || !reflect.DeepEqual(got, test.want) {
+            t.Errorf("ParseGeoFilter(%q, %q, %q) = %+v, %v, want %+v", test.bbox, test.near, test.radius, got, err, test.want)
+        }
+    }
+}
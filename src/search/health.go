@@ -0,0 +1,270 @@
+package search
+
+import (
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "sort"
+    "strings"
+    "sync/atomic"
+    "github.com/grrywlsn/imagerr/src/db"
+)
+
+// IndexStatus describes the primary backend's image index and how it
+// compares with the database.
+type IndexStatus struct {
+    Backend       string `json:"backend"`
+    Index         string `json:"index,omitempty"`
+    Exists        bool   `json:"exists"`
+    DocumentCount int64  `json:"document_count"`
+    DatabaseCount int64  `json:"database_count"`
+    // Missing is the number of images not in the index, or negative when
+    // the index holds documents for images that no longer exist
+    Missing int64 `json:"missing"`
+    // MappingMismatches lists how the index mapping differs from the one
+    // a reindex would create
+    MappingMismatches []string `json:"mapping_mismatches"`
+}
+
+func GetIndexStatus() (*IndexStatus, error) {
+    status, err := primary.IndexStatus()
+    if err != nil {
+        return nil, err
+    }
+    if status.MappingMismatches == nil {
+        status.MappingMismatches = []string{}
+    }
+
+    if status.DatabaseCount, err = db.CountImages(); err != nil {
+        return nil, fmt.Errorf("failed to count images: %v", err)
+    }
+    status.Missing = status.DatabaseCount - status.DocumentCount
+    return status, nil
+}
+
+func (postgresBackend) IndexStatus() (*IndexStatus, error) {
+    // Postgres searches the images table itself
+    count, err := db.CountImages()
+    if err != nil {
+        return nil, err
+    }
+    return &IndexStatus{Backend: "postgres", Index: "images", Exists: true, DocumentCount: count}, nil
+}
+
+func (b *embeddedBackend) IndexStatus() (*IndexStatus, error) {
+    b.mu.RLock()
+    defer b.mu.RUnlock()
+
+    return &IndexStatus{Backend: b.Name(), Index: b.path(), Exists: true, DocumentCount: int64(len(b.docs))}, nil
+}
+
+func (b elasticsearchBackend) IndexStatus() (*IndexStatus, error) {
+    status := &IndexStatus{Backend: b.Name(), Index: getIndexName()}
+
+    actual, err := currentMapping(getIndexName())
+    if err != nil || actual == nil {
+        return status, err
+    }
+    status.Exists = true
+    if status.MappingMismatches, err = mappingMismatches(indexMapping(), actual); err != nil {
+        return nil, err
+    }
+
+    res, err := esClient.Count(esClient.Count.WithIndex(getIndexName()))
+    if err != nil {
+        return nil, transportError(err)
+    }
+    defer res.Body.Close()
+    if res.IsError() {
+        return nil, responseError(res)
+    }
+
+    var count struct {
+        Count int64 `json:"count"`
+    }
+    if err := json.NewDecoder(res.Body).Decode(&count); err != nil {
+        return nil, fmt.Errorf("error decoding count response: %v", err)
+    }
+    status.DocumentCount = count.Count
+    return status, nil
+}
+
+// currentMapping returns the field mappings of an index, or nil if the
+// index doesn't exist.
+func currentMapping(index string) (map[string]interface{}, error) {
+    res, err := esClient.Indices.GetMapping(esClient.Indices.GetMapping.WithIndex(index))
+    if err != nil {
+        return nil, transportError(err)
+    }
+    defer res.Body.Close()
+
+    if res.StatusCode == http.StatusNotFound {
+        return nil, nil
+    }
+    if res.IsError() {
+        return nil, responseError(res)
+    }
+
+    var body map[string]struct {
+        Mappings map[string]interface{} `json:"mappings"`
+    }
+    if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+        return nil, fmt.Errorf("error decoding mapping response: %v", err)
+    }
+    // The response is keyed by the concrete index name, which differs when
+    // index is an alias
+    for _, mapping := range body {
+        if mapping.Mappings == nil {
+            return map[string]interface{}{}, nil
+        }
+        return mapping.Mappings, nil
+    }
+    return nil, nil
+}
+
+// mappingMismatches compares the properties of an index's mapping with the
+// expected index definition.
+func mappingMismatches(expected string, actual map[string]interface{}) ([]string, error) {
+    var definition struct {
+        Mappings map[string]interface{} `json:"mappings"`
+    }
+    if err := json.Unmarshal([]byte(expected), &definition); err != nil {
+        return nil, err
+    }
+    return compareProperties("", definition.Mappings["properties"], actual["properties"]), nil
+}
+
+// compareProperties walks the expected field definitions and reports fields
+// that are missing, unexpected or defined differently. Fields defined
+// without a type are objects, as Elasticsearch reports them.
+func compareProperties(prefix string, expected, actual interface{}) []string {
+    want, _ := expected.(map[string]interface{})
+    got, _ := actual.(map[string]interface{})
+
+    var mismatches []string
+    for _, name := range sortedKeys(want) {
+        path := prefix + name
+        wantField, _ := want[name].(map[string]interface{})
+        gotField, ok := got[name].(map[string]interface{})
+        if !ok {
+            mismatches = append(mismatches, fmt.Sprintf("%s is missing", path))
+            continue
+        }
+
+        for _, setting := range sortedKeys(wantField) {
+            if setting == "properties" || setting == "fields" {
+                continue
+            }
+            if fmt.Sprint(wantField[setting]) != fmt.Sprint(gotField[setting]) {
+                mismatches = append(mismatches, fmt.Sprintf("%s has %s %v, want %v", path, setting, describe(gotField[setting]), wantField[setting]))
+            }
+        }
+        if _, typed := wantField["type"]; !typed {
+            if gotType, ok := gotField["type"]; ok && gotType != "object" {
+                mismatches = append(mismatches, fmt.Sprintf("%s has type %v, want object", path, gotType))
+            }
+        }
+
+        mismatches = append(mismatches, compareProperties(path+".", wantField["properties"], gotField["properties"])...)
+        mismatches = append(mismatches, compareProperties(path+".", wantField["fields"], gotField["fields"])...)
+    }
+
+    for _, name := range sortedKeys(got) {
+        if _, ok := want[name]; !ok {
+            mismatches = append(mismatches, fmt.Sprintf("%s%s is not in the mapping", prefix, name))
+        }
+    }
+    return mismatches
+}
+
+func describe(value interface{}) interface{} {
+    if value == nil {
+        return "unset"
+    }
+    return value
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+    keys := make([]string, 0, len(m))
+    for key := range m {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+// indexCheck records whether verifyIndexes has succeeded. Elasticsearch may
+// not be reachable at startup, so writes retry it until it does. Only one
+// write runs the check at a time, outside any lock, so a slow or down
+// Elasticsearch doesn't queue every write behind the previous attempt.
+var indexCheck struct {
+    verified  atomic.Bool
+    verifying atomic.Bool
+}
+
+// errVerifyingIndexes is returned to writes made while another write is
+// verifying the indexes.
+var errVerifyingIndexes = fmt.Errorf("search indexes are being verified: %w", ErrUnavailable)
+
+// ensureIndexes verifies the indexes once, returning an error until that
+// succeeds so nothing is indexed into a missing, dynamically mapped index.
+func ensureIndexes() error {
+    if indexCheck.verified.Load() {
+        return nil
+    }
+    if !indexCheck.verifying.CompareAndSwap(false, true) {
+        return errVerifyingIndexes
+    }
+    defer indexCheck.verifying.Store(false)
+
+    // Another write may have finished verifying since the check above
+    if indexCheck.verified.Load() {
+        return nil
+    }
+    if err := verifyIndexes(); err != nil {
+        return err
+    }
+    indexCheck.verified.Store(true)
+    return nil
+}
+
+// verifyIndexes creates the image, tag and saved search indexes when they
+// are missing and warns when their mappings are out of date.
+func verifyIndexes() error {
+    if err := verifyIndex("search index", getIndexName(), indexMapping(), createIndexMapping); err != nil {
+        return err
+    }
+    if err := verifyIndex("tag index", getTagIndexName(), tagIndexMapping, rebuildTagIndex); err != nil {
+        return err
+    }
+    mapping, err := percolatorMapping()
+    if err != nil {
+        return err
+    }
+    return verifyIndex("saved search index", getPercolatorIndexName(), mapping, createPercolatorIndex)
+}
+
+func verifyIndex(description, name, expected string, create func() error) error {
+    actual, err := currentMapping(name)
+    if err != nil {
+        return fmt.Errorf("could not verify %s %s: %w", description, name, err)
+    }
+    if actual == nil {
+        if err := create(); err != nil {
+            return fmt.Errorf("failed to create %s %s: %w", description, name, err)
+        }
+        log.Printf("Created %s %s", description, name)
+        return nil
+    }
+
+    mismatches, err := mappingMismatches(expected, actual)
+    if err != nil {
+        return fmt.Errorf("could not compare %s mapping: %v", description, err)
+    }
+    if len(mismatches) > 0 {
+        log.Printf("Warning: The %s %s mapping is out of date, reindex to update it: %s",
+            description, name, strings.Join(mismatches, "; "))
+    }
+    return nil
+}
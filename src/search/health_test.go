@@ -0,0 +1,130 @@
+package search
+
+import (
+    "encoding/json"
+    "errors"
+    "reflect"
+    "testing"
+)
+
+func decodeMapping(t *testing.T, mapping string) map[string]interface{} {
+    t.Helper()
+    var value map[string]interface{}
+    if err := json.Unmarshal([]byte(mapping), &value); err != nil {
+        t.Fatalf("decoding %s: %v", mapping, err)
+    }
+    return value
+}
+
+func TestCompareProperties(t *testing.T) {
+    expected := `{
+        "name": { "type": "keyword", "fields": { "text": { "type": "text", "analyzer": "standard" } } },
+        "description": { "type": "text", "analyzer": "folded" },
+        "location": { "type": "geo_point" },
+        "by_language": { "dynamic": false, "properties": { "english": { "type": "text" } } }
+    }`
+    for _, test := range []struct {
+        name   string
+        actual string
+        want   []string
+    }{
+        {
+            "identical",
+            expected,
+            nil,
+        },
+        {
+            // Elasticsearch reports dynamic as a string and adds defaults
+            "as reported",
+            `{
+                "name": { "type": "keyword", "ignore_above": 256, "fields": { "text": { "type": "text", "analyzer": "standard" } } },
+                "description": { "type": "text", "analyzer": "folded" },
+                "location": { "type": "geo_point" },
+                "by_language": { "dynamic": "false", "properties": { "english": { "type": "text" } } }
+            }`,
+            nil,
+        },
+        {
+            "missing and unexpected",
+            `{
+                "name": { "type": "keyword", "fields": { "text": { "type": "text", "analyzer": "standard" } } },
+                "description": { "type": "text", "analyzer": "folded" },
+                "by_language": { "dynamic": "false", "properties": { "english": { "type": "text" } } },
+                "extra": { "type": "long" }
+            }`,
+            []string{"location is missing", "extra is not in the mapping"},
+        },
+        {
+            "different settings",
+            `{
+                "name": { "type": "text", "fields": { "text": { "type": "text" } } },
+                "description": { "type": "text" },
+                "location": { "properties": { "lat": { "type": "float" }, "lon": { "type": "float" } } },
+                "by_language": { "type": "nested", "properties": { "english": { "type": "text" }, "german": { "type": "text" } } }
+            }`,
+            []string{
+                "by_language has dynamic unset, want false",
+                "by_language has type nested, want object",
+                "by_language.german is not in the mapping",
+                "description has analyzer unset, want folded",
+                "location has type unset, want geo_point",
+                "location.lat is not in the mapping",
+                "location.lon is not in the mapping",
+                "name has type text, want keyword",
+                "name.text has analyzer unset, want standard",
+            },
+        },
+    } {
+        got := compareProperties("", decodeMapping(t, expected), decodeMapping(t, test.actual))
+        if !reflect.DeepEqual(got, test.want) {
+            t.Errorf("%s: compareProperties = %q, want %q", test.name, got, test.want)
+        }
+    }
+}
+
+func TestCurrentMappingsMatch(t *testing.T) {
+    percolator, err := percolatorMapping()
+    if err != nil {
+        t.Fatalf("percolatorMapping: %v", err)
+    }
+    for name, mapping := range map[string]string{
+        "search":     indexMapping(),
+        "tag":        tagIndexMapping,
+        "percolator": percolator,
+    } {
+        actual := decodeMapping(t, mapping)["mappings"].(map[string]interface{})
+        mismatches, err := mappingMismatches(mapping, actual)
+        if err != nil || len(mismatches) != 0 {
+            t.Errorf("%s index mapping compared with itself = %q, %v, want no mismatches", name, mismatches, err)
+        }
+    }
+
+    mismatches, err := mappingMismatches(percolator, decodeMapping(t, indexMapping())["mappings"].(map[string]interface{}))
+    if err != nil || !reflect.DeepEqual(mismatches, []string{"query is missing"}) {
+        t.Errorf("percolator mapping compared with the search index = %q, %v, want the query field missing", mismatches, err)
+    }
+}
+
+func TestMappingMismatchesRejectsInvalidDefinition(t *testing.T) {
+    if _, err := mappingMismatches("{", map[string]interface{}{}); err == nil {
+        t.Error("mappingMismatches accepted an invalid definition")
+    }
+}
+
+func TestEnsureIndexesDoesNotWaitOnVerification(t *testing.T) {
+    defer func() {
+        indexCheck.verified.Store(false)
+        indexCheck.verifying.Store(false)
+    }()
+
+    // Another write is verifying the indexes
+    indexCheck.verifying.Store(true)
+    if err := ensureIndexes(); !errors.Is(err, ErrUnavailable) {
+        t.Errorf("ensureIndexes during verification = %v, want ErrUnavailable", err)
+    }
+
+    indexCheck.verified.Store(true)
+    if err := ensureIndexes(); err != nil {
+        t.Errorf("ensureIndexes once verified = %v, want nil", err)
+    }
+}
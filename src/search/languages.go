@@ -0,0 +1,122 @@
+package search
+
+import (
+    "encoding/json"
+    "fmt"
+    "log"
+    "os"
+    "strings"
+    "github.com/grrywlsn/imagerr/src/db"
+)
+
+// SEARCH_LANGUAGES lists the description languages searches stem queries
+// in, and SEARCH_DEFAULT_LANGUAGE the one uploads use when they don't name
+// one. Elasticsearch gets an analyzer per language, so changing the list
+// needs a reindex.
+var (
+    languages       = []string{db.DefaultLanguage}
+    defaultLanguage = db.DefaultLanguage
+)
+
+func loadLanguages() {
+    if value := os.Getenv("SEARCH_LANGUAGES"); value != "" {
+        var enabled []string
+        for _, language := range strings.Split(value, ",") {
+            language = strings.TrimSpace(strings.ToLower(language))
+            if !db.StemmableLanguage(language) {
+                log.Printf("Ignoring unsupported SEARCH_LANGUAGES entry %q", language)
+                continue
+            }
+            if !containsString(enabled, language) {
+                enabled = append(enabled, language)
+            }
+        }
+        if len(enabled) > 0 {
+            languages = enabled
+        }
+    }
+
+    defaultLanguage = languages[0]
+    if value := strings.ToLower(os.Getenv("SEARCH_DEFAULT_LANGUAGE")); value != "" {
+        if containsString(languages, value) {
+            defaultLanguage = value
+        } else {
+            log.Printf("SEARCH_DEFAULT_LANGUAGE %q is not in SEARCH_LANGUAGES, using %s", value, defaultLanguage)
+        }
+    }
+}
+
+// Languages returns the languages images may be described in.
+func Languages() []string {
+    return languages
+}
+
+// DefaultLanguage returns the language of images uploaded without one.
+func DefaultLanguage() string {
+    return defaultLanguage
+}
+
+// ValidLanguage reports whether images may be described in language, that
+// is whether it is one of the enabled SEARCH_LANGUAGES rather than merely
+// stemmable, as db.StemmableLanguage checks.
+func ValidLanguage(language string) bool {
+    return containsString(languages, language)
+}
+
+// imageLanguage returns the language an image's description is analysed
+// in. Images stored before languages were recorded are English, matching
+// the search_vector column.
+func imageLanguage(language string) string {
+    if language == "" {
+        return db.DefaultLanguage
+    }
+    return language
+}
+
+// descriptionLanguage returns the description_by_language field an image's
+// description is indexed under. Images described in a language that is no
+// longer enabled use the default language's field, as there is no analyzer
+// for theirs.
+func descriptionLanguage(language string) string {
+    language = imageLanguage(language)
+    if !ValidLanguage(language) {
+        return defaultLanguage
+    }
+    return language
+}
+
+// languageAnalysis returns the analysis filters and analyzers for each
+// language, and the description_by_language properties using them, as JSON
+// to splice into the index mapping.
+func languageAnalysis() (filters, analyzers, properties string) {
+    filterMap := map[string]interface{}{}
+    analyzerMap := map[string]interface{}{}
+    propertyMap := map[string]interface{}{}
+    for _, language := range languages {
+        filterMap[language+"_stop"] = map[string]interface{}{"type": "stop", "stopwords": "_" + language + "_"}
+        filterMap[language+"_stemmer"] = map[string]interface{}{"type": "stemmer", "language": language}
+        // Fold accents after stemming, since stemmers expect them
+        analyzerMap[language+"_description"] = map[string]interface{}{
+            "tokenizer": "standard",
+            "filter":    []string{"lowercase", language + "_stop", language + "_stemmer", "asciifolding"},
+        }
+        propertyMap[language] = map[string]interface{}{"type": "text", "analyzer": language + "_description"}
+    }
+    return spliceJSON(filterMap), spliceJSON(analyzerMap), spliceJSON(propertyMap)
+}
+
+// spliceJSON encodes an object without its braces. Maps of strings always
+// encode, so there is no error to report.
+func spliceJSON(value map[string]interface{}) string {
+    data, _ := json.Marshal(value)
+    return strings.TrimSuffix(strings.TrimPrefix(string(data), "{"), "}")
+}
+
+// languageFields lists the per-language description fields for multi_match.
+func languageFields(boost float64) []string {
+    var fields []string
+    for _, language := range languages {
+        fields = append(fields, fmt.Sprintf("description_by_language.%s^%g", language, boost))
+    }
+    return fields
+}
@@ -0,0 +1,244 @@
+package search
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+    "github.com/grrywlsn/imagerr/src/db"
+)
+
+// Saved searches are stored as percolator queries in their own index, so a
+// newly indexed image can be checked against all of them in one request.
+func getPercolatorIndexName() string {
+    prefix := os.Getenv("ES_INDEX_PREFIX")
+    if prefix != "" {
+        return prefix + "_saved_searches"
+    }
+    return "saved_searches"
+}
+
+type percolatorDocument struct {
+    Query interface{} `json:"query"`
+}
+
+type percolateResponse struct {
+    Hits struct {
+        Hits []struct {
+            ID string `json:"_id"`
+        } `json:"hits"`
+    } `json:"hits"`
+}
+
+// percolatorMapping copies the image index mapping, so stored queries are
+// parsed against the same fields and analyzers, and adds the query field.
+func percolatorMapping() (string, error) {
+    var mapping map[string]interface{}
+    if err := json.Unmarshal([]byte(indexMapping()), &mapping); err != nil {
+        return "", err
+    }
+    properties := mapping["mappings"].(map[string]interface{})["properties"].(map[string]interface{})
+    properties["query"] = map[string]interface{}{"type": "percolator"}
+
+    data, err := json.Marshal(mapping)
+    return string(data), err
+}
+
+// percolatorQuery matches images on the text query and tags of a saved
+// search. Tags are expanded when the search is saved.
+func percolatorQuery(saved *db.SavedSearch) interface{} {
+    params := SearchParams{
+        Query: saved.Query,
+        Tags:  expandQuery(saved.Query, strings.Join(saved.Tags, ",")),
+    }
+    if params.Query == "" && params.Tags == "" {
+        return map[string]interface{}{"match_all": map[string]interface{}{}}
+    }
+    return map[string]interface{}{
+        "bool": map[string]interface{}{
+            "should":               matchClauses(params),
+            "minimum_should_match": 1,
+        },
+    }
+}
+
+func createPercolatorIndex() error {
+    mapping, err := percolatorMapping()
+    if err != nil {
+        return err
+    }
+
+    res, err := esClient.Indices.Create(
+        getPercolatorIndexName(),
+        esClient.Indices.Create.WithBody(strings.NewReader(mapping)),
+    )
+    if err != nil {
+        return transportError(err)
+    }
+    defer res.Body.Close()
+    if res.IsError() {
+        return fmt.Errorf("error creating saved search index mapping: %w", responseError(res))
+    }
+    return nil
+}
+
+// ensurePercolatorIndex creates the saved search index the first time a
+// search is saved, as indexing into a missing index would map the query
+// field as an ordinary object.
+func ensurePercolatorIndex() error {
+    res, err := esClient.Indices.Exists([]string{getPercolatorIndexName()})
+    if err != nil {
+        return transportError(err)
+    }
+    res.Body.Close()
+    if res.StatusCode == http.StatusNotFound {
+        return createPercolatorIndex()
+    }
+    if res.IsError() {
+        return &Error{StatusCode: res.StatusCode}
+    }
+    return nil
+}
+
+func (elasticsearchBackend) IndexSavedSearch(saved *db.SavedSearch) error {
+    if err := ensurePercolatorIndex(); err != nil {
+        return err
+    }
+    return indexSavedSearch(saved)
+}
+
+func indexSavedSearch(saved *db.SavedSearch) error {
+    var buf bytes.Buffer
+    if err := json.NewEncoder(&buf).Encode(percolatorDocument{Query: percolatorQuery(saved)}); err != nil {
+        return err
+    }
+
+    res, err := esClient.Index(
+        getPercolatorIndexName(),
+        &buf,
+        esClient.Index.WithDocumentID(strconv.FormatInt(saved.ID, 10)),
+        esClient.Index.WithContext(context.Background()),
+    )
+    if err != nil {
+        return transportError(err)
+    }
+    defer res.Body.Close()
+
+    if res.IsError() {
+        return fmt.Errorf("error indexing saved search: %w", responseError(res))
+    }
+    return nil
+}
+
+func (elasticsearchBackend) DeleteSavedSearch(id int64) error {
+    res, err := esClient.Delete(getPercolatorIndexName(), strconv.FormatInt(id, 10))
+    if err != nil {
+        return transportError(err)
+    }
+    defer res.Body.Close()
+
+    // A missing search or index is already in the state we want
+    if res.IsError() && res.StatusCode != http.StatusNotFound {
+        return fmt.Errorf("error deleting saved search: %w", responseError(res))
+    }
+    return nil
+}
+
+// MatchSavedSearches percolates the image document against the stored
+// queries of the given searches. The index holds every saved search, so
+// the others are filtered out to keep them from taking up the hits.
+// Searches missing from the index never match.
+func (elasticsearchBackend) MatchSavedSearches(image *db.Image, searches []db.SavedSearch) ([]int64, error) {
+    if len(searches) == 0 {
+        return nil, nil
+    }
+    ids := make([]string, len(searches))
+    for i, saved := range searches {
+        ids[i] = strconv.FormatInt(saved.ID, 10)
+    }
+
+    query := map[string]interface{}{
+        "query": map[string]interface{}{
+            "bool": map[string]interface{}{
+                "must": map[string]interface{}{
+                    "percolate": map[string]interface{}{
+                        "field":    "query",
+                        "document": newDocument(image),
+                    },
+                },
+                "filter": map[string]interface{}{
+                    "ids": map[string]interface{}{"values": ids},
+                },
+            },
+        },
+        "size":    len(searches),
+        "_source": false,
+    }
+
+    var buf bytes.Buffer
+    if err := json.NewEncoder(&buf).Encode(query); err != nil {
+        return nil, err
+    }
+
+    res, err := esClient.Search(
+        esClient.Search.WithContext(context.Background()),
+        esClient.Search.WithIndex(getPercolatorIndexName()),
+        esClient.Search.WithBody(&buf),
+    )
+    if err != nil {
+        return nil, transportError(err)
+    }
+    defer res.Body.Close()
+
+    if res.StatusCode == http.StatusNotFound {
+        return nil, nil
+    }
+    if res.IsError() {
+        return nil, responseError(res)
+    }
+
+    var result percolateResponse
+    if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+        return nil, fmt.Errorf("error decoding percolate response: %v", err)
+    }
+
+    var matched []int64
+    for _, hit := range result.Hits.Hits {
+        if id, err := strconv.ParseInt(hit.ID, 10, 64); err == nil {
+            matched = append(matched, id)
+        }
+    }
+    return matched, nil
+}
+
+// rebuildPercolatorIndex recreates the saved search index from the
+// database, picking up changes to the image mapping and to tag synonyms.
+func rebuildPercolatorIndex() error {
+    res, err := esClient.Indices.Delete([]string{getPercolatorIndexName()})
+    if err != nil {
+        return transportError(err)
+    }
+    res.Body.Close()
+    if res.IsError() && res.StatusCode != http.StatusNotFound {
+        return fmt.Errorf("error deleting saved search index: %s", res.Status())
+    }
+
+    if err := createPercolatorIndex(); err != nil {
+        return err
+    }
+
+    searches, err := db.GetSavedSearches()
+    if err != nil {
+        return fmt.Errorf("failed to fetch saved searches: %v", err)
+    }
+    for i := range searches {
+        if err := indexSavedSearch(&searches[i]); err != nil {
+            return fmt.Errorf("failed to index saved search %d: %w", searches[i].ID, err)
+        }
+    }
+    return nil
+}
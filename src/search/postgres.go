@@ -0,0 +1,202 @@
+package search
+
+import (
+    "log"
+    "strings"
+    "sync"
+    "time"
+    "github.com/grrywlsn/imagerr/src/db"
+)
+
+// postgresBackend queries the images table directly, so it needs no index
+// maintenance and can stand in for Elasticsearch while it is unavailable.
+type postgresBackend struct{}
+
+// Match the page sizes Elasticsearch uses for the same requests
+const (
+    postgresSearchLimit  = 10
+    postgresSuggestLimit = 10
+)
+
+// postgresTagCandidates caps how many of the most used matching tags are
+// ranked for suggestions.
+const postgresTagCandidates = 200
+
+func (postgresBackend) Name() string {
+    return "postgres"
+}
+
+func (postgresBackend) Ping() error {
+    return db.DB.Ping()
+}
+
+func (postgresBackend) SearchImages(params SearchParams) ([]SearchResult, error) {
+    var tagList []string
+    if params.Tags != "" {
+        tagList = strings.Split(params.Tags, ",")
+    }
+
+    images, err := db.SearchImages(db.SearchQuery{
+        Text:      params.Query,
+        Tags:      tagList,
+        Dates:     params.Dates,
+        Geo:       params.Geo,
+        Album:     params.Album,
+        Limit:     postgresSearchLimit,
+        Languages: languages,
+        Ranking:   rankingFor(params),
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    var searchResults []SearchResult
+    for _, image := range images {
+        searchResults = append(searchResults, imageToSearchResult(image))
+    }
+    return searchResults, nil
+}
+
+func (postgresBackend) DidYouMean(q string) (string, error) {
+    terms, err := searchVocabulary()
+    if err != nil {
+        return "", err
+    }
+    return correctQuery(q, terms), nil
+}
+
+// Reading the vocabulary for spelling suggestions scans every description
+// and tag, so it is cached rather than read on each sparse search.
+const vocabularyRefreshInterval = 5 * time.Minute
+
+var vocabularyCache struct {
+    mu       sync.Mutex
+    terms    map[string]int
+    loadedAt time.Time
+}
+
+// searchVocabulary returns the cached vocabulary, reloading it once it is
+// stale. Only one caller reloads at a time while the others keep using the
+// old vocabulary, and a failed reload is logged and keeps the old vocabulary
+// until the interval has passed again.
+func searchVocabulary() (map[string]int, error) {
+    vocabularyCache.mu.Lock()
+    terms := vocabularyCache.terms
+    if time.Since(vocabularyCache.loadedAt) < vocabularyRefreshInterval {
+        vocabularyCache.mu.Unlock()
+        return terms, nil
+    }
+    vocabularyCache.loadedAt = time.Now()
+    vocabularyCache.mu.Unlock()
+
+    loaded, err := db.SearchTerms()
+    if err != nil {
+        log.Printf("Error loading search vocabulary: %v", err)
+        return terms, nil
+    }
+
+    vocabularyCache.mu.Lock()
+    vocabularyCache.terms = loaded
+    vocabularyCache.mu.Unlock()
+    return loaded, nil
+}
+
+// SuggestTags ranks the most used tags that contain the query or are
+// similar to it, so infix and fuzzy matches are offered as in Elasticsearch.
+func (postgresBackend) SuggestTags(query string, recent []string) ([]string, error) {
+    counts, err := db.CountMatchingTagImages(query, postgresTagCandidates)
+    if err != nil {
+        return nil, err
+    }
+    return rankTagSuggestions(query, counts, recent, postgresSuggestLimit), nil
+}
+
+func (postgresBackend) RelatedTags(tags []string, limit int) ([]string, error) {
+    return db.RelatedTags(tags, limit)
+}
+
+func (postgresBackend) RelatedImages(image *db.Image, limit int) ([]SearchResult, error) {
+    images, err := db.RelatedImages(image.ID, image.Tags, limit)
+    if err != nil {
+        return nil, err
+    }
+
+    var searchResults []SearchResult
+    for _, related := range images {
+        searchResults = append(searchResults, imageToSearchResult(related))
+    }
+    return searchResults, nil
+}
+
+func (postgresBackend) SimilarImages(vector []float32, excludeID int64, limit int) ([]SearchResult, error) {
+    embeddings, err := db.GetImageEmbeddings()
+    if err != nil {
+        return nil, err
+    }
+    ids := nearestImages(vector, embeddings, excludeID, limit)
+    images, err := db.GetImagesByIDs(ids)
+    if err != nil {
+        return nil, err
+    }
+
+    // GetImagesByIDs returns images in ID order
+    byID := make(map[int64]db.Image)
+    for _, image := range images {
+        byID[image.ID] = image
+    }
+    var searchResults []SearchResult
+    for _, id := range ids {
+        if image, ok := byID[id]; ok {
+            searchResults = append(searchResults, imageToSearchResult(image))
+        }
+    }
+    return searchResults, nil
+}
+
+func (postgresBackend) IndexImage(image *db.Image) error {
+    return nil
+}
+
+func (postgresBackend) UpdateViewCount(image *db.Image) error {
+    return nil
+}
+
+// Saved searches are read from the database and matched in Go.
+func (postgresBackend) IndexSavedSearch(saved *db.SavedSearch) error {
+    return nil
+}
+
+func (postgresBackend) DeleteSavedSearch(id int64) error {
+    return nil
+}
+
+func (postgresBackend) MatchSavedSearches(image *db.Image, searches []db.SavedSearch) ([]int64, error) {
+    return matchSavedSearches(image, searches), nil
+}
+
+func (postgresBackend) SyncTags(names []string) error {
+    return nil
+}
+
+func (postgresBackend) ReindexAll(images []db.Image) error {
+    return nil
+}
+
+func imageToSearchResult(image db.Image) SearchResult {
+    return SearchResult{
+        ID:               image.ID,
+        OriginalFilename: image.OriginalFilename,
+        UUIDFilename:     image.UUIDFilename,
+        Description:      image.Description,
+        URL:              image.URL,
+        Tags:             image.Tags,
+        StoragePath:      image.StoragePath,
+        CreatedAt:        image.CreatedAt,
+        ViewCount:        image.ViewCount,
+        TakenAt:          image.TakenAt,
+        Language:         imageLanguage(image.Language),
+        Latitude:         image.Latitude,
+        Longitude:        image.Longitude,
+        Albums:           image.Albums,
+    }
+}
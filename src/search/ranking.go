@@ -0,0 +1,90 @@
+package search
+
+import (
+    "log"
+    "math"
+    "os"
+    "strconv"
+    "time"
+    "github.com/grrywlsn/imagerr/src/db"
+)
+
+// Search relevance is multiplied by one plus a popularity boost of
+// SEARCH_POPULARITY_WEIGHT * log(1 + views) and a recency boost of
+// SEARCH_RECENCY_WEIGHT, halving every SEARCH_RECENCY_SCALE of an image's
+// age. Setting both weights to 0 ranks on relevance alone.
+//
+// Text matches are weighted by field with SEARCH_BOOST_DESCRIPTION,
+// SEARCH_BOOST_FILENAME and SEARCH_BOOST_TAGS.
+const (
+    defaultDescriptionBoost = 1.0
+    defaultFilenameBoost    = 1.5
+    defaultTagsBoost        = 2.0
+    defaultPopularityWeight = 1.0
+    defaultRecencyWeight    = 1.0
+    defaultRecencyScale     = 30 * 24 * time.Hour
+
+    // Unstemmed description matches count for this much more
+    exactDescriptionBoost = 2.0
+)
+
+var ranking = db.SearchRanking{
+    Fields: db.FieldBoosts{
+        Description: defaultDescriptionBoost,
+        Filename:    defaultFilenameBoost,
+        Tags:        defaultTagsBoost,
+    },
+    PopularityWeight: defaultPopularityWeight,
+    RecencyWeight:    defaultRecencyWeight,
+    RecencyScale:     defaultRecencyScale,
+}
+
+func loadRanking() {
+    ranking.Fields.Description = envWeight("SEARCH_BOOST_DESCRIPTION", defaultDescriptionBoost)
+    ranking.Fields.Filename = envWeight("SEARCH_BOOST_FILENAME", defaultFilenameBoost)
+    ranking.Fields.Tags = envWeight("SEARCH_BOOST_TAGS", defaultTagsBoost)
+    ranking.PopularityWeight = envWeight("SEARCH_POPULARITY_WEIGHT", defaultPopularityWeight)
+    ranking.RecencyWeight = envWeight("SEARCH_RECENCY_WEIGHT", defaultRecencyWeight)
+
+    ranking.RecencyScale = defaultRecencyScale
+    if value := os.Getenv("SEARCH_RECENCY_SCALE"); value != "" {
+        scale, err := time.ParseDuration(value)
+        if err != nil || scale <= 0 {
+            log.Printf("Invalid SEARCH_RECENCY_SCALE %q, using %s", value, defaultRecencyScale)
+        } else {
+            ranking.RecencyScale = scale
+        }
+    }
+}
+
+func envWeight(name string, def float64) float64 {
+    value := os.Getenv(name)
+    if value == "" {
+        return def
+    }
+    weight, err := strconv.ParseFloat(value, 64)
+    if err != nil || weight < 0 || math.IsNaN(weight) || math.IsInf(weight, 0) {
+        log.Printf("Invalid %s %q, using %g", name, value, def)
+        return def
+    }
+    return weight
+}
+
+// rankingFor returns the ranking a search should use.
+func rankingFor(params SearchParams) db.SearchRanking {
+    if params.RelevanceOnly {
+        return db.SearchRanking{Fields: ranking.Fields}
+    }
+    return ranking
+}
+
+// boostScore applies the popularity and recency boosts to a relevance score
+// the way the Elasticsearch function_score query does.
+func boostScore(score float64, result SearchResult, r db.SearchRanking, now time.Time) float64 {
+    boost := 1 + r.PopularityWeight*math.Log1p(float64(max(result.ViewCount, 0)))
+    if r.RecencyWeight > 0 && r.RecencyScale > 0 {
+        age := math.Max(now.Sub(result.CreatedAt).Seconds(), 0)
+        boost += r.RecencyWeight * math.Pow(0.5, age/r.RecencyScale.Seconds())
+    }
+    return score * boost
+}
@@ -0,0 +1,75 @@
+package search
+
+import (
+    "math"
+    "testing"
+    "time"
+    "github.com/grrywlsn/imagerr/src/db"
+)
+
+func TestBoostScore(t *testing.T) {
+    now := time.Date(2024, time.March, 31, 12, 0, 0, 0, time.UTC)
+    month := 30 * 24 * time.Hour
+    for _, test := range []struct {
+        name   string
+        r      db.SearchRanking
+        views  int
+        age    time.Duration
+        want   float64
+    }{
+        {"no boosts", db.SearchRanking{}, 100, 0, 2},
+        {"unviewed", db.SearchRanking{PopularityWeight: 1}, 0, 0, 2},
+        {"popular", db.SearchRanking{PopularityWeight: 1}, 3, 0, 2 * (1 + math.Log(4))},
+        {"half popularity weight", db.SearchRanking{PopularityWeight: 0.5}, 3, 0, 2 * (1 + 0.5*math.Log(4))},
+        {"negative views", db.SearchRanking{PopularityWeight: 1}, -5, 0, 2},
+        {"new", db.SearchRanking{RecencyWeight: 1, RecencyScale: month}, 0, 0, 4},
+        {"one scale old", db.SearchRanking{RecencyWeight: 1, RecencyScale: month}, 0, month, 3},
+        {"two scales old", db.SearchRanking{RecencyWeight: 1, RecencyScale: month}, 0, 2 * month, 2.5},
+        {"double recency weight", db.SearchRanking{RecencyWeight: 2, RecencyScale: month}, 0, month, 4},
+        // Clock skew counts as brand new rather than boosting further
+        {"created in the future", db.SearchRanking{RecencyWeight: 1, RecencyScale: month}, 0, -time.Hour, 4},
+        {"zero scale", db.SearchRanking{RecencyWeight: 1}, 0, 0, 2},
+        {"negative scale", db.SearchRanking{RecencyWeight: 1, RecencyScale: -month}, 0, 0, 2},
+        {
+            "both",
+            db.SearchRanking{PopularityWeight: 0.5, RecencyWeight: 2, RecencyScale: month},
+            3, month,
+            2 * (1 + 0.5*math.Log(4) + 1),
+        },
+    } {
+        result := SearchResult{ViewCount: test.views, CreatedAt: now.Add(-test.age)}
+        if got := boostScore(2, result, test.r, now); math.Abs(got-test.want) > 1e-9 {
+            t.Errorf("%s: boostScore = %g, want %g", test.name, got, test.want)
+        }
+    }
+}
+
+func TestEnvWeight(t *testing.T) {
+    for _, test := range []struct {
+        value string
+        want  float64
+    }{
+        {"", 1.5},
+        {"2.5", 2.5},
+        {"0", 0},
+        {"-1", 1.5},
+        {"heavy", 1.5},
+        {"NaN", 1.5},
+        {"Inf", 1.5},
+    } {
+        t.Setenv("SEARCH_TEST_WEIGHT", test.value)
+        if got := envWeight("SEARCH_TEST_WEIGHT", 1.5); got != test.want {
+            t.Errorf("envWeight with %q = %g, want %g", test.value, got, test.want)
+        }
+    }
+}
+
+func TestRankingFor(t *testing.T) {
+    if got := rankingFor(SearchParams{}); got != ranking {
+        t.Errorf("rankingFor = %+v, want %+v", got, ranking)
+    }
+    want := db.SearchRanking{Fields: ranking.Fields}
+    if got := rankingFor(SearchParams{RelevanceOnly: true}); got != want {
+        t.Errorf("rankingFor relevance only = %+v, want %+v", got, want)
+    }
+}
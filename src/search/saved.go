@@ -0,0 +1,114 @@
+package search
+
+import (
+    "errors"
+    "log"
+    "strings"
+    "time"
+    "github.com/grrywlsn/imagerr/src/db"
+)
+
+// SavedSearchParams returns the parameters to run a saved search with,
+// resolving relative date bounds against now.
+func SavedSearchParams(saved *db.SavedSearch, now time.Time) (SearchParams, error) {
+    dates, err := ParseDateFilter(saved.Date, saved.From, saved.To, now)
+    if err != nil {
+        return SearchParams{}, err
+    }
+    return SearchParams{
+        Query: saved.Query,
+        Tags:  strings.Join(saved.Tags, ","),
+        Dates: dates,
+    }, nil
+}
+
+// IndexSavedSearch and DeleteSavedSearch keep the primary backend's copy of
+// the saved searches in step with the database.
+func IndexSavedSearch(saved *db.SavedSearch) error {
+    return primary.IndexSavedSearch(saved)
+}
+
+func DeleteSavedSearch(id int64) error {
+    return primary.DeleteSavedSearch(id)
+}
+
+// MatchingSavedSearches returns the saved searches that notify on new
+// uploads and that image matches. Backends match the text query and tags;
+// date bounds are checked here, as relative bounds move with time.
+func MatchingSavedSearches(image *db.Image) ([]db.SavedSearch, error) {
+    all, err := db.GetSavedSearches()
+    if err != nil {
+        return nil, err
+    }
+    var searches []db.SavedSearch
+    for _, saved := range all {
+        if saved.Notify || saved.WebhookURL != "" {
+            searches = append(searches, saved)
+        }
+    }
+    if len(searches) == 0 {
+        return nil, nil
+    }
+
+    backend := active()
+    ids, err := backend.MatchSavedSearches(image, searches)
+    if errors.Is(err, ErrUnavailable) && backend == primary && fallback != nil {
+        markUnhealthy(err)
+        ids, err = fallback.MatchSavedSearches(image, searches)
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    matchedIDs := make(map[int64]bool)
+    for _, id := range ids {
+        matchedIDs[id] = true
+    }
+    result, now := imageToSearchResult(*image), time.Now()
+    var matched []db.SavedSearch
+    for _, saved := range searches {
+        if !matchedIDs[saved.ID] {
+            continue
+        }
+        params, err := SavedSearchParams(&saved, now)
+        if err != nil {
+            log.Printf("Skipping saved search %d with invalid dates: %v", saved.ID, err)
+            continue
+        }
+        if inDateRange(result, params.Dates) {
+            matched = append(matched, saved)
+        }
+    }
+    return matched, nil
+}
+
+// matchSavedSearches is used by the backends that don't store saved
+// searches. Like the embedded backend's scoring, an image matches on any of
+// the expanded tags or on any query word found, allowing for typos, in its
+// description, filename or tags. Words are compared unstemmed.
+func matchSavedSearches(image *db.Image, searches []db.SavedSearch) []int64 {
+    descriptionTerms := tokenize(image.Description)
+    filenameTerms := tokenize(image.OriginalFilename)
+    tagTerms := tokenize(strings.Join(image.Tags, " "))
+
+    var ids []int64
+    for _, saved := range searches {
+        tags := expandQuery(saved.Query, strings.Join(saved.Tags, ","))
+        queryTerms := tokenize(saved.Query)
+
+        matched := saved.Query == "" && tags == ""
+        if tags != "" && hasAnyTag(image.Tags, strings.Split(tags, ",")) {
+            matched = true
+        }
+        fields := ranking.Fields
+        if (fields.Description > 0 && matchTerms(queryTerms, descriptionTerms) > 0) ||
+            (fields.Filename > 0 && matchTerms(queryTerms, filenameTerms) > 0) ||
+            (fields.Tags > 0 && matchTerms(queryTerms, tagTerms) > 0) {
+            matched = true
+        }
+        if matched {
+            ids = append(ids, saved.ID)
+        }
+    }
+    return ids
+}
@@ -0,0 +1,31 @@
+package search
+
+import (
+    "sort"
+    "github.com/grrywlsn/imagerr/src/features"
+)
+
+// nearestImages compares vector with every embedding, for backends without
+// a vector index, and returns the IDs of the closest, most similar first.
+func nearestImages(vector []float32, embeddings map[int64][]float32, excludeID int64, limit int) []int64 {
+    similarity := make(map[int64]float64)
+    var ids []int64
+    for id, embedding := range embeddings {
+        if id == excludeID || !features.Valid(embedding) {
+            continue
+        }
+        similarity[id] = features.Similarity(vector, embedding)
+        ids = append(ids, id)
+    }
+
+    sort.Slice(ids, func(i, j int) bool {
+        if similarity[ids[i]] != similarity[ids[j]] {
+            return similarity[ids[i]] > similarity[ids[j]]
+        }
+        return ids[i] > ids[j]
+    })
+    if len(ids) > limit {
+        ids = ids[:limit]
+    }
+    return ids
+}
@@ -0,0 +1,78 @@
+package search
+
+import (
+    "math"
+    "sort"
+    "strings"
+    "github.com/grrywlsn/imagerr/src/db"
+)
+
+// Relative weights for the ways a tag can match what is being typed. The
+// Elasticsearch tags index boosts its subfields by the same amounts.
+const (
+    exactMatchBoost   = 10.0
+    prefixMatchBoost  = 6.0
+    segmentMatchBoost = 5.0
+    infixMatchBoost   = 2.0
+    fuzzyMatchBoost   = 1.0
+    recentTagBoost    = 3.0
+)
+
+// matchTag scores how well tag matches query, or returns 0 if it doesn't.
+func matchTag(query, tag string) float64 {
+    switch {
+    case tag == query:
+        return exactMatchBoost
+    case strings.HasPrefix(tag, query):
+        return prefixMatchBoost
+    case strings.Contains(tag, db.TagPathSeparator+query), strings.Contains(tag, db.TagNamespaceSeparator+query):
+        return segmentMatchBoost
+    case strings.Contains(tag, query):
+        return infixMatchBoost
+    }
+
+    // Compare against each word of the tag, as the standard analyzer would
+    maxEdits := fuzzyEdits(query)
+    best := 0.0
+    for _, term := range tokenize(tag) {
+        if distance := levenshtein(query, term, maxEdits); distance <= maxEdits {
+            best = math.Max(best, fuzzyMatchBoost/float64(1+distance))
+        }
+    }
+    return best
+}
+
+// rankTagSuggestions orders the tags matching query by match quality scaled
+// by popularity, favouring tags the user has used recently.
+func rankTagSuggestions(query string, counts map[string]int, recent []string, limit int) []string {
+    recentSet := make(map[string]bool)
+    for _, tag := range recent {
+        recentSet[tag] = true
+    }
+
+    scores := make(map[string]float64)
+    var tags []string
+    for tag, count := range counts {
+        score := matchTag(query, tag)
+        if score == 0 {
+            continue
+        }
+        score *= 1 + math.Log1p(float64(count))
+        if recentSet[tag] {
+            score *= recentTagBoost
+        }
+        scores[tag] = score
+        tags = append(tags, tag)
+    }
+
+    sort.Slice(tags, func(i, j int) bool {
+        if scores[tags[i]] != scores[tags[j]] {
+            return scores[tags[i]] > scores[tags[j]]
+        }
+        return tags[i] < tags[j]
+    })
+    if len(tags) > limit {
+        tags = tags[:limit]
+    }
+    return tags
+}
@@ -0,0 +1,69 @@
+package search
+
+import (
+    "reflect"
+    "testing"
+)
+
+func TestMatchTag(t *testing.T) {
+    for _, test := range []struct {
+        query, tag string
+        want       float64
+    }{
+        {"cat", "cat", exactMatchBoost},
+        {"cat", "category", prefixMatchBoost},
+        {"animal", "animal/cat", prefixMatchBoost},
+        {"cat", "animal/cat", segmentMatchBoost},
+        {"berlin", "place:berlin", segmentMatchBoost},
+        {"ego", "category", infixMatchBoost},
+        {"bech", "beach", fuzzyMatchBoost / 2},
+        {"moutnains", "places/mountains", fuzzyMatchBoost / 3},
+        // Too short for any edits
+        {"ct", "cat", 0},
+        {"dog", "cat", 0},
+    } {
+        if got := matchTag(test.query, test.tag); got != test.want {
+            t.Errorf("matchTag(%q, %q) = %g, want %g", test.query, test.tag, got, test.want)
+        }
+    }
+}
+
+func TestRankTagSuggestions(t *testing.T) {
+    counts := map[string]int{
+        "cat":        1,
+        "category":   50,
+        "animal/cat": 20,
+        "concat":     100,
+        "cart":       5,
+        "dog":        500,
+    }
+    for _, test := range []struct {
+        name   string
+        recent []string
+        limit  int
+        want   []string
+    }{
+        {"by match and popularity", nil, 10, []string{"category", "animal/cat", "cat", "concat", "cart"}},
+        {"recent tags first", []string{"cat", "dog"}, 10, []string{"cat", "category", "animal/cat", "concat", "cart"}},
+        {"limited", nil, 2, []string{"category", "animal/cat"}},
+    } {
+        if got := rankTagSuggestions("cat", counts, test.recent, test.limit); !reflect.DeepEqual(got, test.want) {
+            t.Errorf("%s: rankTagSuggestions = %q, want %q", test.name, got, test.want)
+        }
+    }
+}
+
+func TestRankTagSuggestionsBreaksTiesAlphabetically(t *testing.T) {
+    counts := map[string]int{"catc": 1, "cata": 1, "catb": 1}
+    for i := 0; i < 10; i++ {
+        if got, want := rankTagSuggestions("cat", counts, nil, 10), []string{"cata", "catb", "catc"}; !reflect.DeepEqual(got, want) {
+            t.Fatalf("rankTagSuggestions = %q, want %q", got, want)
+        }
+    }
+}
+
+func TestRankTagSuggestionsWithoutMatches(t *testing.T) {
+    if got := rankTagSuggestions("zebra", map[string]int{"cat": 3}, nil, 10); len(got) != 0 {
+        t.Errorf("rankTagSuggestions = %q, want none", got)
+    }
+}
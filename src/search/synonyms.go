@@ -0,0 +1,151 @@
+package search
+
+import (
+    "log"
+    "slices"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+    "github.com/grrywlsn/imagerr/src/db"
+)
+
+// Synonyms are applied by expanding queries rather than in the index, so
+// edits take effect on the next search without reindexing. They are cached
+// and reloaded periodically to pick up edits made by other instances, along
+// with the nested tags a parent tag or namespace expands to.
+const synonymRefreshInterval = time.Minute
+
+var synonymCache struct {
+    mu       sync.RWMutex
+    aliases  map[string]string
+    byTag    map[string][]string
+    nested   []string
+    loadedAt time.Time
+}
+
+// ReloadSynonyms replaces the cached synonym list and nested tags with the
+// ones in the database.
+func ReloadSynonyms() error {
+    synonyms, err := db.GetTagSynonyms()
+    if err != nil {
+        return err
+    }
+    nested, err := db.GetNestedTags()
+    if err != nil {
+        return err
+    }
+    // The database's collation may not order names byte by byte
+    sort.Strings(nested)
+
+    aliases := make(map[string]string)
+    byTag := make(map[string][]string)
+    for _, synonym := range synonyms {
+        aliases[synonym.Alias] = synonym.Tag
+        byTag[synonym.Tag] = append(byTag[synonym.Tag], synonym.Alias)
+    }
+
+    synonymCache.mu.Lock()
+    defer synonymCache.mu.Unlock()
+    synonymCache.aliases = aliases
+    synonymCache.byTag = byTag
+    synonymCache.nested = nested
+    synonymCache.loadedAt = time.Now()
+    return nil
+}
+
+func refreshSynonyms() {
+    synonymCache.mu.RLock()
+    stale := time.Since(synonymCache.loadedAt) > synonymRefreshInterval
+    synonymCache.mu.RUnlock()
+
+    if stale {
+        if err := ReloadSynonyms(); err != nil {
+            log.Printf("Error reloading tag synonyms: %v", err)
+            // Keep the previous synonyms until the next interval rather
+            // than querying the database on every search while it fails
+            synonymCache.mu.Lock()
+            synonymCache.loadedAt = time.Now()
+            synonymCache.mu.Unlock()
+        }
+    }
+}
+
+// synonymsOf returns every name for the tag, canonical tag first, or nil if
+// the tag has no synonyms.
+func synonymsOf(tag string) []string {
+    synonymCache.mu.RLock()
+    defer synonymCache.mu.RUnlock()
+
+    canonical := tag
+    if target, ok := synonymCache.aliases[tag]; ok {
+        canonical = target
+    }
+    aliases := synonymCache.byTag[canonical]
+    if len(aliases) == 0 {
+        return nil
+    }
+    return append([]string{canonical}, aliases...)
+}
+
+// expandTags adds every synonym of the given tags, keeping the originals.
+func expandTags(tags []string) []string {
+    seen := make(map[string]bool)
+    var expanded []string
+    add := func(tag string) {
+        if !seen[tag] {
+            seen[tag] = true
+            expanded = append(expanded, tag)
+        }
+    }
+    for _, tag := range tags {
+        add(tag)
+        for _, synonym := range synonymsOf(tag) {
+            add(synonym)
+        }
+    }
+    return expanded
+}
+
+// addNestedTags adds the nested tags among tags to the cache, keeping it
+// sorted.
+func addNestedTags(tags []string) {
+    synonymCache.mu.Lock()
+    defer synonymCache.mu.Unlock()
+
+    for _, tag := range tags {
+        if !strings.ContainsAny(tag, db.TagNamespaceSeparator+db.TagPathSeparator) {
+            continue
+        }
+        i := sort.SearchStrings(synonymCache.nested, tag)
+        if i < len(synonymCache.nested) && synonymCache.nested[i] == tag {
+            continue
+        }
+        synonymCache.nested = slices.Insert(synonymCache.nested, i, tag)
+    }
+}
+
+// tagDescendants adds every cached nested tag below the given tags.
+func tagDescendants(tags []string) []string {
+    synonymCache.mu.RLock()
+    defer synonymCache.mu.RUnlock()
+    return db.TagDescendants(tags, synonymCache.nested)
+}
+
+// canonicalSuggestions returns the canonical tags of aliases starting with
+// prefix, so typing an alias suggests the tag images are filed under.
+func canonicalSuggestions(prefix string) []string {
+    refreshSynonyms()
+
+    synonymCache.mu.RLock()
+    defer synonymCache.mu.RUnlock()
+
+    var tags []string
+    for alias, tag := range synonymCache.aliases {
+        if strings.HasPrefix(alias, prefix) {
+            tags = append(tags, tag)
+        }
+    }
+    sort.Strings(tags)
+    return tags
+}
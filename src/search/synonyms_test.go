@@ -0,0 +1,20 @@
+package search
+
+import (
+    "reflect"
+    "testing"
+)
+
+func TestAddNestedTags(t *testing.T) {
+    defer func(nested []string) { synonymCache.nested = nested }(synonymCache.nested)
+    synonymCache.nested = []string{"animal/dog", "location:paris"}
+
+    addNestedTags([]string{"sunset", "animal/cat", "location:paris", "animal/cat/kitten", "animal/cat"})
+    want := []string{"animal/cat", "animal/cat/kitten", "animal/dog", "location:paris"}
+    if !reflect.DeepEqual(synonymCache.nested, want) {
+        t.Errorf("nested tags = %q, want %q", synonymCache.nested, want)
+    }
+    if got, want := tagDescendants([]string{"animal"}), []string{"animal", "animal/cat", "animal/cat/kitten", "animal/dog"}; !reflect.DeepEqual(got, want) {
+        t.Errorf("tagDescendants(animal) = %q, want %q", got, want)
+    }
+}
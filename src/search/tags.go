@@ -4,20 +4,94 @@ import (
     "context"
     "encoding/json"
     "bytes"
+    "fmt"
+    "net/http"
+    "os"
+    "strings"
+    "github.com/grrywlsn/imagerr/src/db"
 )
 
-func SuggestTags(query string) ([]string, error) {
+// Tag suggestions come from a separate index holding one document per tag
+// with its image count, analysed for prefix, path segment, infix and fuzzy
+// matching.
+func getTagIndexName() string {
+    prefix := os.Getenv("ES_INDEX_PREFIX")
+    if prefix != "" {
+        return prefix + "_tags"
+    }
+    return "tags"
+}
+
+type tagDocument struct {
+    Name  string `json:"name"`
+    Count int    `json:"count"`
+}
+
+type tagSearchResponse struct {
+    Hits struct {
+        Hits []struct {
+            Source tagDocument `json:"_source"`
+        } `json:"hits"`
+    } `json:"hits"`
+}
+
+type bulkResponse struct {
+    Errors bool `json:"errors"`
+    Items  []map[string]struct {
+        Status int `json:"status"`
+        Error  *struct {
+            Type   string `json:"type"`
+            Reason string `json:"reason"`
+        } `json:"error"`
+    } `json:"items"`
+}
+
+func (elasticsearchBackend) SuggestTags(query string, recent []string) ([]string, error) {
+    functions := []map[string]interface{}{
+        {
+            "field_value_factor": map[string]interface{}{
+                "field":    "count",
+                "modifier": "log2p",
+                "missing":  1,
+            },
+        },
+    }
+    if len(recent) > 0 {
+        functions = append(functions, map[string]interface{}{
+            "filter": map[string]interface{}{
+                "terms": map[string]interface{}{"name": recent},
+            },
+            "weight": recentTagBoost,
+        })
+    }
+
     searchQuery := map[string]interface{}{
-        "size": 0,
-        "aggs": map[string]interface{}{
-            "tag_suggestions": map[string]interface{}{
-                "terms": map[string]interface{}{
-                    "field": "tags",
-                    "include": query + ".*",
-                    "size": 10,
+        "size":    suggestLimit,
+        "_source": []string{"name", "count"},
+        "query": map[string]interface{}{
+            "function_score": map[string]interface{}{
+                "query": map[string]interface{}{
+                    "bool": map[string]interface{}{
+                        "should": []map[string]interface{}{
+                            {"term": map[string]interface{}{"name": map[string]interface{}{"value": query, "boost": exactMatchBoost}}},
+                            {"match": map[string]interface{}{"name.prefix": map[string]interface{}{"query": query, "boost": prefixMatchBoost}}},
+                            {"match": map[string]interface{}{"name.segment": map[string]interface{}{"query": query, "boost": segmentMatchBoost}}},
+                            {"match": map[string]interface{}{"name.infix": map[string]interface{}{"query": query, "boost": infixMatchBoost}}},
+                            {"match": map[string]interface{}{"name.text": map[string]interface{}{"query": query, "fuzziness": "AUTO", "boost": fuzzyMatchBoost}}},
+                        },
+                        "minimum_should_match": 1,
+                    },
                 },
+                "functions":  functions,
+                "score_mode": "multiply",
+                "boost_mode": "multiply",
             },
         },
+        "sort": []interface{}{
+            "_score",
+            map[string]interface{}{"count": map[string]interface{}{"order": "desc"}},
+            map[string]interface{}{"name": map[string]interface{}{"order": "asc"}},
+        },
     }
 
     var buf bytes.Buffer
@@ -27,28 +101,226 @@ func SuggestTags(query string) ([]string, error) {
 
     res, err := esClient.Search(
         esClient.Search.WithContext(context.Background()),
-        esClient.Search.WithIndex(getIndexName()),
+        esClient.Search.WithIndex(getTagIndexName()),
         esClient.Search.WithBody(&buf),
     )
     if err != nil {
-        return nil, err
+        return nil, transportError(err)
     }
     defer res.Body.Close()
 
-    var result map[string]interface{}
-    if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
-        return nil, err
+    if res.IsError() {
+        return nil, responseError(res)
     }
 
-    aggregations := result["aggregations"].(map[string]interface{})
-    tagSuggestions := aggregations["tag_suggestions"].(map[string]interface{})
-    buckets := tagSuggestions["buckets"].([]interface{})
+    var result tagSearchResponse
+    if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+        return nil, fmt.Errorf("error decoding tag suggestions: %v", err)
+    }
 
     var tags []string
-    for _, bucket := range buckets {
-        bucketMap := bucket.(map[string]interface{})
-        tags = append(tags, bucketMap["key"].(string))
+    for _, hit := range result.Hits.Hits {
+        tags = append(tags, hit.Source.Name)
     }
 
     return tags, nil
-}
\ No newline at end of file
+}
+
+// SyncTags brings the tag documents for the given tags in line with the
+// database, deleting those no longer on any image.
+func (elasticsearchBackend) SyncTags(names []string) error {
+    if len(names) == 0 {
+        return nil
+    }
+    if err := ensureIndexes(); err != nil {
+        return err
+    }
+    return syncTags(names)
+}
+
+// syncTags is SyncTags without the index check, for use while the indexes
+// are being verified.
+func syncTags(names []string) error {
+    if len(names) == 0 {
+        return nil
+    }
+
+    counts, err := db.CountTagImages(names)
+    if err != nil {
+        return fmt.Errorf("failed to count tag images: %v", err)
+    }
+
+    var buf bytes.Buffer
+    encoder := json.NewEncoder(&buf)
+    for _, name := range names {
+        meta := map[string]interface{}{"_index": getTagIndexName(), "_id": name}
+        if counts[name] == 0 {
+            if err := encoder.Encode(map[string]interface{}{"delete": meta}); err != nil {
+                return err
+            }
+            continue
+        }
+        if err := encoder.Encode(map[string]interface{}{"index": meta}); err != nil {
+            return err
+        }
+        if err := encoder.Encode(tagDocument{Name: name, Count: counts[name]}); err != nil {
+            return err
+        }
+    }
+    return bulk(&buf)
+}
+
+func bulk(body *bytes.Buffer) error {
+    res, err := esClient.Bulk(body, esClient.Bulk.WithContext(context.Background()))
+    if err != nil {
+        return transportError(err)
+    }
+    defer res.Body.Close()
+
+    if res.IsError() {
+        return responseError(res)
+    }
+
+    var result bulkResponse
+    if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+        return fmt.Errorf("error decoding bulk response: %v", err)
+    }
+    if result.Errors {
+        for _, item := range result.Items {
+            for _, action := range item {
+                if action.Error != nil {
+                    return &Error{StatusCode: action.Status, Type: action.Error.Type, Reason: action.Error.Reason}
+                }
+            }
+        }
+    }
+    return nil
+}
+
+// rebuildTagIndex recreates the tag index from the tags currently in use.
+func rebuildTagIndex() error {
+    res, err := esClient.Indices.Delete([]string{getTagIndexName()})
+    if err != nil {
+        return transportError(err)
+    }
+    res.Body.Close()
+    if res.IsError() && res.StatusCode != http.StatusNotFound {
+        return fmt.Errorf("error deleting tag index: %s", res.Status())
+    }
+
+    res, err = esClient.Indices.Create(
+        getTagIndexName(),
+        esClient.Indices.Create.WithBody(strings.NewReader(tagIndexMapping)),
+    )
+    if err != nil {
+        return transportError(err)
+    }
+    defer res.Body.Close()
+    if res.IsError() {
+        return fmt.Errorf("error creating tag index mapping: %w", responseError(res))
+    }
+
+    counts, err := db.CountTagImages(nil)
+    if err != nil {
+        return fmt.Errorf("failed to count tag images: %v", err)
+    }
+    var names []string
+    for name := range counts {
+        names = append(names, name)
+    }
+    return syncTags(names)
+}
+
+const tagIndexMapping = `{
+    "settings": {
+        "index": { "max_ngram_diff": 8 },
+        "analysis": {
+            "tokenizer": {
+                "tag_prefix": { "type": "edge_ngram", "min_gram": 1, "max_gram": 64 },
+                "tag_infix": { "type": "ngram", "min_gram": 2, "max_gram": 10 },
+                "tag_segments": { "type": "pattern", "pattern": "[/:]" }
+            },
+            "filter": {
+                "tag_prefix": { "type": "edge_ngram", "min_gram": 1, "max_gram": 64 }
+            },
+            "analyzer": {
+                "tag_prefix": { "tokenizer": "tag_prefix", "filter": ["lowercase"] },
+                "tag_infix": { "tokenizer": "tag_infix", "filter": ["lowercase"] },
+                "tag_segment_prefix": { "tokenizer": "tag_segments", "filter": ["lowercase", "tag_prefix"] },
+                "tag_query": { "tokenizer": "keyword", "filter": ["lowercase"] }
+            }
+        }
+    },
+    "mappings": {
+        "properties": {
+            "name": {
+                "type": "keyword",
+                "fields": {
+                    "prefix": { "type": "text", "analyzer": "tag_prefix", "search_analyzer": "tag_query" },
+                    "segment": { "type": "text", "analyzer": "tag_segment_prefix", "search_analyzer": "tag_query" },
+                    "infix": { "type": "text", "analyzer": "tag_infix", "search_analyzer": "tag_query" },
+                    "text": { "type": "text", "analyzer": "standard" }
+                }
+            },
+            "count": { "type": "integer" }
+        }
+    }
+}`
+
+// RelatedTags uses a significant_terms aggregation, which favours tags that
+// are common alongside the selected ones but not common everywhere.
+func (elasticsearchBackend) RelatedTags(tags []string, limit int) ([]string, error) {
+    var filters []map[string]interface{}
+    for _, tag := range tags {
+        filters = append(filters, map[string]interface{}{
+            "term": map[string]interface{}{"tags": tag},
+        })
+    }
+
+    searchQuery := map[string]interface{}{
+        "size": 0,
+        "query": map[string]interface{}{
+            "bool": map[string]interface{}{"filter": filters},
+        },
+        "aggs": map[string]interface{}{
+            "related_tags": map[string]interface{}{
+                "significant_terms": map[string]interface{}{
+                    "field":         "tags",
+                    "exclude":       tags,
+                    "size":          limit,
+                    "min_doc_count": 1,
+                },
+            },
+        },
+    }
+
+    var buf bytes.Buffer
+    if err := json.NewEncoder(&buf).Encode(searchQuery); err != nil {
+        return nil, err
+    }
+
+    res, err := esClient.Search(
+        esClient.Search.WithContext(context.Background()),
+        esClient.Search.WithIndex(getIndexName()),
+        esClient.Search.WithBody(&buf),
+    )
+    if err != nil {
+        return nil, transportError(err)
+    }
+    defer res.Body.Close()
+
+    if res.IsError() {
+        return nil, responseError(res)
+    }
+
+    var result searchResponse
+    if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+        return nil, fmt.Errorf("error decoding related tags: %v", err)
+    }
+
+    related := []string{}
+    for _, bucket := range result.Aggregations["related_tags"].Buckets {
+        related = append(related, bucket.Key)
+    }
+    return related, nil
+}
@@ -7,6 +7,7 @@ import (
     "log"
     "os"
     "strings"
+    "time"
 
     "github.com/aws/aws-sdk-go-v2/aws"
     "github.com/aws/aws-sdk-go-v2/config"
@@ -75,10 +76,50 @@ func GetFileURL(storagePath string) string {
     return fmt.Sprintf("%s/%s", cdnDomain, storagePath)
 }
 
+// DownloadFile opens a stored file for reading. Callers must close it.
+func DownloadFile(storagePath string) (io.ReadCloser, error) {
+    output, err := s3Client.GetObject(context.TODO(), &s3.GetObjectInput{
+        Bucket: &bucketName,
+        Key:    &storagePath,
+    })
+    if err != nil {
+        return nil, fmt.Errorf("failed to download file: %v", err)
+    }
+    return output.Body, nil
+}
+
 func DeleteFile(storagePath string) error {
     _, err := s3Client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
         Bucket: &bucketName,
         Key:    &storagePath,
     })
     return err
-}
\ No newline at end of file
+}
+
+type Object struct {
+    Key          string
+    Size         int64
+    LastModified time.Time
+}
+
+func ListFiles(prefix string) ([]Object, error) {
+    var objects []Object
+    paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+        Bucket: &bucketName,
+        Prefix: aws.String(prefix),
+    })
+    for paginator.HasMorePages() {
+        page, err := paginator.NextPage(context.TODO())
+        if err != nil {
+            return nil, fmt.Errorf("failed to list files: %v", err)
+        }
+        for _, obj := range page.Contents {
+            object := Object{Key: aws.ToString(obj.Key), Size: aws.ToInt64(obj.Size)}
+            if obj.LastModified != nil {
+                object.LastModified = *obj.LastModified
+            }
+            objects = append(objects, object)
+        }
+    }
+    return objects, nil
+}